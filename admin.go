@@ -0,0 +1,58 @@
+package main
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// serveAdmin serves profiling and runtime debug endpoints on addr, which
+// should not be reachable from the internet:
+//
+//	/debug/pprof/  net/http/pprof profiles
+//	/debug/vars    expvar variables, including the "fittings" runtime stats
+func (s *EFContext) serveAdmin(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	expvar.Publish("fittings", expvar.Func(s.debugVars))
+	slog.Info("admin listening", "addr", addr)
+	fatal("admin serve", "err", http.ListenAndServe(addr, mux))
+}
+
+// debugVars returns runtime statistics of the server.
+func (s *EFContext) debugVars() interface{} {
+	s.live.Lock()
+	live := len(s.live.subs)
+	s.live.Unlock()
+	vars := map[string]interface{}{
+		"goroutines":       runtime.NumGoroutine(),
+		"live_subscribers": live,
+		"db":               s.DB.Stats(),
+		"items":            len(s.Global().Items),
+		"search_entries":   len(s.search.Load().entries),
+		"esi_error_limit":  esiErrors.vars(),
+		"throttled":        throttled.vars(),
+		"ingestion":        s.ingest.vars(),
+	}
+	if s.limiter != nil {
+		s.limiter.mu.Lock()
+		vars["rate_limited_clients"] = len(s.limiter.clients)
+		s.limiter.mu.Unlock()
+	}
+	if s.responses != nil {
+		vars["response_cache"] = s.responses.vars()
+	}
+	vars["encoding_cache"] = s.encodings.vars()
+	if s.replica != nil {
+		vars["replica_healthy"] = s.replica.healthy.Load()
+		vars["replica_db"] = s.replica.X.Stats()
+	}
+	return vars
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+)
+
+// AdminResult reports a completed admin action.
+type AdminResult struct {
+	Action  string
+	Seconds float64
+}
+
+// adminRoutes are maintenance endpoints served under the admin/ path of the
+// v1 API. They require a POST with an admin API key and are not documented in
+// the OpenAPI document.
+func (s *EFContext) adminRoutes() []apiRoute {
+	post := []string{http.MethodPost}
+	return []apiRoute{
+		{Name: "FlushCaches", Handler: s.FlushCaches, Methods: post},
+		{Name: "RebuildSearch", Handler: s.RebuildSearch, Methods: post},
+		{Name: "RunJob", Handler: s.RunJob, Methods: post},
+		{Name: "ReloadSDE", Handler: s.ReloadSDE, Methods: post},
+		{Name: "Reprocess", Handler: s.Reprocess, Methods: post, Timeout: time.Hour},
+		{Name: "DeadLetters", Handler: s.DeadLetters, Methods: post},
+		{Name: "RetryDeadLetters", Handler: s.RetryDeadLetters, Methods: post},
+		{Name: "ModerateComment", Handler: s.ModerateComment, Methods: post},
+	}
+}
+
+// requireAdmin wraps f to reject requests that aren't authenticated with an
+// admin API key.
+func (s *EFContext) requireAdmin(f apiHandler) apiHandler {
+	return func(ctx context.Context, r *http.Request, timing *servertiming.Header) (interface{}, error) {
+		key := apiKeyFromContext(r.Context())
+		if key == nil {
+			return nil, &httpError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "admin API key required"}
+		}
+		if !key.Admin {
+			return nil, &httpError{Status: http.StatusForbidden, Code: "forbidden", Message: "API key is not an admin key"}
+		}
+		logger(ctx).Info("admin request", "key", key.Name, "path", r.URL.Path)
+		return f(ctx, r, timing)
+	}
+}
+
+// FlushCaches clears the in-memory caches so they are reloaded from the
+// database on next use.
+func (s *EFContext) FlushCaches(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	start := time.Now()
+	s.fotd.Lock()
+	s.fotd.day = ""
+	s.fotd.fit = nil
+	s.fotd.Unlock()
+	s.popularity.Lock()
+	s.popularity.loaded = time.Time{}
+	s.popularity.Unlock()
+	s.apiKeys.flush()
+	if s.responses != nil {
+		if err := s.responses.store.flush(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return AdminResult{Action: "FlushCaches", Seconds: time.Since(start).Seconds()}, nil
+}
+
+// RebuildSearch reloads the synonyms and rebuilds the search index.
+func (s *EFContext) RebuildSearch(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	start := time.Now()
+	if err := s.buildSearchIndex(ctx); err != nil {
+		return nil, err
+	}
+	return AdminResult{Action: "RebuildSearch", Seconds: time.Since(start).Seconds()}, nil
+}
+
+// adminJobs are the aggregation jobs that RunJob can run by name. Unlike
+// their sync job counterparts they run regardless of when they last ran.
+func (s *EFContext) adminJobs() map[string]func(context.Context) {
+	return map[string]func(context.Context){
+		"GenerateReport": s.generateReport,
+		"UpdatePrices":   s.updatePrices,
+		"RefreshViews":   s.refreshViews,
+		"UpdatePopularity": func(ctx context.Context) {
+			s.updatePopularity(ctx)
+			s.popularity.Lock()
+			s.popularity.loaded = time.Time{}
+			s.popularity.Unlock()
+		},
+	}
+}
+
+// RunJob runs the aggregation job named by the job parameter.
+func (s *EFContext) RunJob(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	jobs := s.adminJobs()
+	name := r.FormValue("job")
+	f := jobs[name]
+	if f == nil {
+		var names []string
+		for n := range jobs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, badRequest("job must be one of %s", strings.Join(names, ", "))
+	}
+	start := time.Now()
+	f(ctx)
+	return AdminResult{Action: name, Seconds: time.Since(start).Seconds()}, nil
+}
+
+// ReloadSDE replaces the static data without a restart. With source=sde, the
+// default, it is read from the SDE directory and stored for other instances,
+// which reload it with source=db.
+func (s *EFContext) ReloadSDE(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	start := time.Now()
+	var g *staticData
+	var err error
+	switch source := r.FormValue("source"); source {
+	case "", "sde":
+		if g, err = loadSDE(s.sdeDir, s.sdeFormat); err != nil {
+			return nil, err
+		}
+		if err := s.storeStaticData(ctx, g); err != nil {
+			return nil, err
+		}
+	case "db":
+		if g, err = s.loadStaticData(ctx); err != nil {
+			return nil, err
+		}
+		if g == nil {
+			return nil, notFound("no static data stored")
+		}
+	default:
+		return nil, badRequest("source must be sde or db")
+	}
+	if err := s.setGlobal(ctx, g); err != nil {
+		return nil, err
+	}
+	logger(ctx).Info("reloaded static data", "items", len(g.Items), "groups", len(g.Groups))
+	return AdminResult{Action: "ReloadSDE", Seconds: time.Since(start).Seconds()}, nil
+}
+
+// reprocessBatch is the range of killmail IDs Reprocess queues at a time.
+const reprocessBatch = 10000
+
+// Reprocess queues processed killmails to have their fits derived again from
+// the stored killmails by the next ProcessFits, such as after a change to how
+// fits are derived. The killmail parameter selects one killmail; otherwise
+// all=1 is required to select all of them. Reprocessed fits aren't sent to
+// the live feed or webhooks again. Failed killmails are retried with
+// RetryDeadLetters instead. Reprocessed fits keep their cost, which may have
+// been recomputed from current prices.
+func (s *EFContext) Reprocess(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	start := time.Now()
+	var err error
+	if v := r.FormValue("killmail"); v != "" {
+		id, perr := strconv.Atoi(v)
+		if perr != nil {
+			return nil, badRequest("invalid killmail")
+		}
+		res, err := s.DB.ExecContext(ctx, `UPDATE killmails SET processed = $2 WHERE id = $1 AND processed > 0`, id, ProcKMReprocess)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, notFound("unknown or unprocessed killmail")
+		}
+	} else if all, _ := strconv.ParseBool(r.FormValue("all")); all {
+		var lo, hi sql.NullInt64
+		if err := s.DB.QueryRowContext(ctx, `SELECT min(id), max(id) FROM killmails`).Scan(&lo, &hi); err != nil {
+			return nil, err
+		}
+		// Batches by ID range keep each transaction small.
+		for from := lo.Int64; lo.Valid && from <= hi.Int64 && err == nil; from += reprocessBatch {
+			_, err = s.DB.ExecContext(ctx, `UPDATE killmails SET processed = $1 WHERE processed > 0 AND id >= $2 AND id < $3`, ProcKMReprocess, from, from+reprocessBatch)
+		}
+	} else {
+		return nil, badRequest("killmail or all=1 required")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return AdminResult{Action: "Reprocess", Seconds: time.Since(start).Seconds()}, nil
+}
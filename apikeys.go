@@ -0,0 +1,194 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+// apiKeyTTL is how long looked up API keys are cached, and so how long a
+// revoked key may still be accepted.
+const apiKeyTTL = time.Minute
+
+// apiKeyCacheSize bounds the number of cached API keys, which includes
+// unknown tokens, so a client sending random tokens can't grow the cache.
+const apiKeyCacheSize = 10000
+
+// apiKey is a trusted consumer identified by an Authorization: Bearer token.
+type apiKey struct {
+	ID   int64
+	Name string
+	Tier rateTier
+	// Bulk grants access to bulk requests, such as large Fits limits.
+	Bulk bool
+	// Admin grants access to the admin API.
+	Admin bool
+}
+
+// apiKeyCache caches API keys by token hash, evicting the least recently
+// used beyond apiKeyCacheSize. Unknown tokens are cached as nil.
+type apiKeyCache struct {
+	sync.Mutex
+	keys map[string]*list.Element
+	// lru holds *apiKeyEntry, most recently used first.
+	lru list.List
+}
+
+type apiKeyEntry struct {
+	hash    string
+	key     *apiKey
+	expires time.Time
+}
+
+// get returns the unexpired cached key of hash.
+func (c *apiKeyCache) get(hash string, now time.Time) (key *apiKey, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	el := c.keys[hash]
+	if el == nil {
+		return nil, false
+	}
+	e := el.Value.(*apiKeyEntry)
+	if !now.Before(e.expires) {
+		c.lru.Remove(el)
+		delete(c.keys, hash)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.key, true
+}
+
+// put caches key, which may be nil, as the key of hash until expires.
+func (c *apiKeyCache) put(hash string, key *apiKey, expires time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	if c.keys == nil {
+		c.keys = map[string]*list.Element{}
+	}
+	if el := c.keys[hash]; el != nil {
+		el.Value = &apiKeyEntry{hash, key, expires}
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.keys[hash] = c.lru.PushFront(&apiKeyEntry{hash, key, expires})
+	for c.lru.Len() > apiKeyCacheSize {
+		delete(c.keys, c.lru.Remove(c.lru.Back()).(*apiKeyEntry).hash)
+	}
+}
+
+// flush empties the cache.
+func (c *apiKeyCache) flush() {
+	c.Lock()
+	c.keys = nil
+	c.lru.Init()
+	c.Unlock()
+}
+
+type apiKeyContextKey struct{}
+
+// apiKeyFromContext returns the API key of the request, or nil if the
+// request is anonymous.
+func apiKeyFromContext(ctx context.Context) *apiKey {
+	k, _ := ctx.Value(apiKeyContextKey{}).(*apiKey)
+	return k
+}
+
+// hashToken returns the stored form of token, an API key or session. Only
+// hashes are stored so a database leak doesn't leak usable tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticate returns r with its API key or logged in character, if any,
+// added to its context. Requests with an Authorization header that isn't a
+// valid key are rejected. An unknown or expired session is ignored.
+func (s *EFContext) authenticate(r *http.Request) (*http.Request, error) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		cookie, err := r.Cookie(sessionCookie)
+		if err != nil || s.sso == nil {
+			return r, nil
+		}
+		c, err := s.lookupSession(r.Context(), cookie.Value)
+		if err != nil || c == nil {
+			return r, err
+		}
+		return r.WithContext(context.WithValue(r.Context(), characterContextKey{}, c)), nil
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == auth || token == "" {
+		return nil, &httpError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "authorization must be a Bearer API key"}
+	}
+	key, err := s.lookupAPIKey(r.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, &httpError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "invalid API key"}
+	}
+	return r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)), nil
+}
+
+// cachedAPIKey returns the API key of r's Authorization header if it is
+// cached, without querying the database.
+func (s *EFContext) cachedAPIKey(r *http.Request) *apiKey {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return nil
+	}
+	key, _ := s.apiKeys.get(hashToken(token), time.Now())
+	return key
+}
+
+func (s *EFContext) lookupAPIKey(ctx context.Context, token string) (*apiKey, error) {
+	hash := hashToken(token)
+	now := time.Now()
+	if key, ok := s.apiKeys.get(hash, now); ok {
+		return key, nil
+	}
+
+	key := &apiKey{}
+	var limit float64
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT
+			id, name, rate_limit, burst, bulk, admin
+		FROM
+			api_keys
+		WHERE
+			hash = $1 AND revoked IS NULL
+	`, hash).Scan(&key.ID, &key.Name, &limit, &key.Tier.Burst, &key.Bulk, &key.Admin)
+	if err == sql.ErrNoRows {
+		key = nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "lookup api key")
+	} else {
+		key.Tier.Limit = rate.Limit(limit)
+	}
+
+	s.apiKeys.put(hash, key, now.Add(apiKeyTTL))
+	return key, nil
+}
+
+// CreateAPIKey stores a new API key with the default quotas, granting admin
+// access if admin is set, and returns its token, which is not retrievable
+// later.
+func (s *EFContext) CreateAPIKey(name string, admin bool) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := "ef_" + hex.EncodeToString(b)
+	_, err := s.DB.Exec(`INSERT INTO api_keys (name, hash, admin) VALUES ($1, $2, $3)`, name, hashToken(token), admin)
+	return token, errors.Wrap(err, "create api key")
+}
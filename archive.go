@@ -0,0 +1,130 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cockroachdb/cockroach-go/crdb"
+	"github.com/pkg/errors"
+)
+
+// archiveBatch is how many killmails are written to each archive file.
+const archiveBatch = 1000
+
+// archivedKillmail is a line of an archive file.
+type archivedKillmail struct {
+	ID  int32           `json:"id"`
+	KM  json.RawMessage `json:"km"`
+	Zkb json.RawMessage `json:"zkb"`
+}
+
+// Archive moves processed killmails older than s.retention months to
+// gzipped ndjson files in s.archiveDir and deletes them and their fits from
+// the database. A GCS or S3 bucket can be used by mounting it at the
+// directory. Fits can be derived again from the archived killmails.
+// Aggregates such as reports and popularity, the killmails of fits of the
+// day, and hashes, so backfills skip archived killmails, are kept. It does
+// nothing if s.retention is 0.
+func (s *EFContext) Archive(ctx context.Context) {
+	if s.retention == 0 {
+		return
+	}
+	cutoff := time.Now().AddDate(0, -s.retention, 0)
+	for ctx.Err() == nil {
+		n, err := s.archiveBatch(ctx, cutoff)
+		if err != nil {
+			slog.Error("archive", "err", err)
+			return
+		}
+		if n < archiveBatch {
+			return
+		}
+	}
+}
+
+// archiveBatch archives up to archiveBatch killmails from before cutoff,
+// returning how many were archived.
+func (s *EFContext) archiveBatch(ctx context.Context, cutoff time.Time) (int, error) {
+	var rows []archivedKillmail
+	if err := s.X.SelectContext(ctx, &rows, `
+		SELECT
+			id, km, zkb
+		FROM
+			killmails
+		WHERE
+			killed < $1
+			AND processed > 0
+			AND id NOT IN (SELECT killmail FROM fotd)
+		ORDER BY
+			killed
+		LIMIT
+			$2
+	`, cutoff, archiveBatch); err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	// Name files by their first and last IDs so later runs never overwrite
+	// them.
+	name := fmt.Sprintf("killmails-%d-%d.ndjson.gz", rows[0].ID, rows[len(rows)-1].ID)
+	if err := writeArchive(filepath.Join(s.archiveDir, name), rows); err != nil {
+		return 0, err
+	}
+
+	ids := make([]int64, len(rows))
+	for i, row := range rows {
+		ids[i] = int64(row.ID)
+	}
+	// Only delete once the file is safely written. Tables kept apart from
+	// fits so they survive reprocessing are deleted from too.
+	if err := crdb.ExecuteTx(ctx, s.DB, nil, func(tx *sql.Tx) error {
+		for _, table := range []string{"fits", "dead_letter", "saved_fits", "fit_votes", "fit_scores", "fit_comments", "doctrine_list_fits"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE killmail = ANY($1)`, ids); err != nil {
+				return errors.Wrap(err, table)
+			}
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM killmails WHERE id = ANY($1)`, ids)
+		return errors.Wrap(err, "killmails")
+	}); err != nil {
+		return 0, err
+	}
+	slog.Info("archived", "file", name, "killmails", len(rows))
+	return len(rows), nil
+}
+
+// writeArchive writes rows to path as gzipped ndjson. A temporary file is
+// written first so a partial archive is never left at path.
+func writeArchive(path string, rows []archivedKillmail) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	zw := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(zw)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+const (
+	zkbHistoryURL = "https://zkillboard.com/api/history/%s.json"
+	zkbKillURL    = "https://zkillboard.com/api/killID/%d/"
+	// backfillCheckpointEvery is how many killmails are backfilled between
+	// checkpoints.
+	backfillCheckpointEvery = 100
+)
+
+// zkbLimiter paces zKillboard API requests, which it asks to be kept to
+// about one a second.
+var zkbLimiter = rate.NewLimiter(rate.Every(time.Second), 1)
+
+// Backfill stores and processes the killmails of each day from from to to,
+// inclusive, from zKillboard's history. If ship is set, only losses of that
+// ship type are stored. Progress is checkpointed per range and ship, so
+// rerunning an interrupted backfill resumes where it stopped. Killmails
+// already stored are skipped.
+func (s *EFContext) Backfill(ctx context.Context, from, to time.Time, ship int32) error {
+	source := fmt.Sprintf("backfill:%s:%s:%d", from.Format(time.DateOnly), to.Format(time.DateOnly), ship)
+	day, after := from, int64(0)
+	position, _, ok, err := s.getCheckpoint(ctx, source)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if day, after, err = parseBackfillPosition(position); err != nil {
+			return err
+		}
+		slog.Info("resuming backfill", "day", day.Format(time.DateOnly), "after", after)
+	}
+	for ; !day.After(to); day = day.AddDate(0, 0, 1) {
+		if err := s.backfillDay(ctx, source, day, after, ship); err != nil {
+			return errors.Wrap(err, day.Format(time.DateOnly))
+		}
+		after = 0
+		s.ProcessFits(ctx)
+	}
+	slog.Info("backfill complete", "from", from.Format(time.DateOnly), "to", to.Format(time.DateOnly))
+	return nil
+}
+
+// backfillPosition is a backfill checkpoint: the day being backfilled and
+// the last killmail ID of it done.
+func backfillPosition(day time.Time, after int64) string {
+	return fmt.Sprintf("%s/%d", day.Format(time.DateOnly), after)
+}
+
+func parseBackfillPosition(position string) (time.Time, int64, error) {
+	d, a, _ := strings.Cut(position, "/")
+	day, err := time.Parse(time.DateOnly, d)
+	if err != nil {
+		return time.Time{}, 0, errors.Wrapf(err, "backfill position %q", position)
+	}
+	after, err := strconv.ParseInt(a, 10, 64)
+	return day, after, errors.Wrapf(err, "backfill position %q", position)
+}
+
+// backfillDay backfills the killmails of day with IDs above after.
+func (s *EFContext) backfillDay(ctx context.Context, source string, day time.Time, after int64, ship int32) error {
+	var history map[string]string
+	if err := zkbGet(ctx, fmt.Sprintf(zkbHistoryURL, day.Format("20060102")), &history); err != nil {
+		return err
+	}
+	ids := make([]int64, 0, len(history))
+	for k := range history {
+		id, err := strconv.ParseInt(k, 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "history id %q", k)
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var known []int64
+	if err := s.X.SelectContext(ctx, &known, `SELECT id FROM hashes WHERE id = ANY($1)`, ids); err != nil {
+		return err
+	}
+	skip := map[int64]bool{}
+	for _, id := range known {
+		skip[id] = true
+	}
+
+	stored := 0
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if id <= after || skip[id] {
+			continue
+		}
+		if i%backfillCheckpointEvery == 0 {
+			if err := s.setCheckpoint(ctx, source, backfillPosition(day, after)); err != nil {
+				return err
+			}
+		}
+		after = id
+		hash := history[strconv.FormatInt(id, 10)]
+		rawKM, err := fetchESIKillmail(ctx, int(id), hash)
+		if err != nil {
+			slog.Error("backfill", "killmail", id, "err", err)
+			s.ingest.failed("esi")
+			// Without a ship filter FetchKillmails can fetch it later.
+			if ship == 0 {
+				if err := s.storeHash(ctx, int(id), hash); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if ship != 0 {
+			var km KM
+			if err := json.Unmarshal(rawKM, &km); err != nil {
+				return errors.Wrapf(err, "killmail %d", id)
+			}
+			if km.Victim.ShipTypeId != ship {
+				continue
+			}
+		}
+		rawZKB, err := fetchZKB(ctx, id)
+		if err != nil {
+			// The fit is still worth storing; its zkb data is left empty.
+			slog.Warn("backfill", "killmail", id, "err", err)
+			s.ingest.failed("zkb")
+		}
+		if err := s.storeKillmail(context.Background(), int(id), hash, rawKM, rawZKB); err != nil {
+			return err
+		}
+		stored++
+	}
+	if err := s.setCheckpoint(ctx, source, backfillPosition(day.AddDate(0, 0, 1), 0)); err != nil {
+		return err
+	}
+	slog.Info("backfilled", "day", day.Format(time.DateOnly), "killmails", len(ids), "stored", stored)
+	return nil
+}
+
+// fetchZKB returns the raw zkb object of killmail id.
+func fetchZKB(ctx context.Context, id int64) ([]byte, error) {
+	var kills []struct {
+		Zkb json.RawMessage `json:"zkb"`
+	}
+	if err := zkbGet(ctx, fmt.Sprintf(zkbKillURL, id), &kills); err != nil {
+		return nil, err
+	}
+	if len(kills) == 0 {
+		return nil, errors.Errorf("zkb: killmail %d not found", id)
+	}
+	return kills[0].Zkb, nil
+}
+
+// zkbGet decodes the JSON response of a zKillboard API request into v,
+// pacing requests with zkbLimiter and retrying transient failures.
+func zkbGet(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := retryDo(req, func(req *http.Request) (*http.Response, error) {
+		if err := zkbLimiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+		return httpClient.Do(req)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("zkb: %s", resp.Status)
+	}
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(v), "zkb decode")
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// cachePolicy is how long clients and shared caches may reuse a route's
+// responses.
+type cachePolicy struct {
+	// MaxAge is how long responses are fresh.
+	MaxAge time.Duration
+	// Stale is how long after MaxAge a response may still be served while
+	// it is revalidated in the background.
+	Stale time.Duration
+	// NoStore responses must not be reused at all.
+	NoStore bool
+}
+
+// defaultCache is the cachePolicy of routes that don't set one.
+var defaultCache = cachePolicy{MaxAge: time.Hour}
+
+// header returns the Cache-Control header of p. Private responses, such as
+// those to requests with an API key or session, are kept out of shared
+// caches.
+func (p cachePolicy) header(private bool) string {
+	if p == (cachePolicy{}) {
+		p = defaultCache
+	}
+	if p.NoStore {
+		return "no-store"
+	}
+	h := "public"
+	if private {
+		h = "private"
+	}
+	h += fmt.Sprintf(", max-age=%d", int(p.MaxAge.Seconds()))
+	if p.Stale > 0 {
+		h += fmt.Sprintf(", stale-while-revalidate=%d", int(p.Stale.Seconds()))
+	}
+	return h
+}
+
+// cacheMaxEntries bounds the number of results in a memoryStore. Results of
+// new requests aren't stored while it is full of unexpired ones.
+const cacheMaxEntries = 10000
+
+// responseCache holds handler results of recent anonymous GET requests, so
+// bursts of identical requests reach the database once.
+type responseCache struct {
+	ttl   time.Duration
+	store responseStore
+	group singleflight.Group
+
+	mu           sync.Mutex
+	hits, misses int64
+}
+
+// responseStore is where a responseCache keeps results: in memory, or in
+// Redis to share them between instances.
+type responseStore interface {
+	get(ctx context.Context, key string) (interface{}, bool)
+	set(ctx context.Context, key string, res interface{}, ttl time.Duration)
+	flush(ctx context.Context) error
+	// len returns the number of cached results, or -1 if unknown.
+	len() int
+}
+
+// newResponseCache returns a cache of results for ttl, stored in the Redis
+// server at redisURL if it is set and in memory if not.
+func newResponseCache(ttl time.Duration, redisURL string) (*responseCache, error) {
+	c := &responseCache{ttl: ttl, store: &memoryStore{entries: map[string]cachedResponse{}}}
+	if redisURL != "" {
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "cache redis")
+		}
+		c.store = redisStore{redis.NewClient(opts)}
+	}
+	return c, nil
+}
+
+// cached wraps f to serve its results from c for c.ttl. Requests with an API
+// key are never cached since their limits may differ. Concurrent misses of
+// the same request share one call of f, which runs for up to timeout
+// regardless of the request that started it being canceled.
+func (c *responseCache) cached(f apiHandler, timeout time.Duration) apiHandler {
+	return func(ctx context.Context, r *http.Request, timing *servertiming.Header) (interface{}, error) {
+		if r.Method != http.MethodGet || apiKeyFromContext(r.Context()) != nil {
+			return f(ctx, r, timing)
+		}
+		// Wrap has already normalized the query's order. Accept selects
+		// alternate encodings such as CSV and msgpack.
+		key := r.URL.Path + "?" + r.URL.RawQuery + "\n" + r.Header.Get("Accept")
+		res, ok := c.store.get(ctx, key)
+		c.mu.Lock()
+		if ok {
+			c.hits++
+		} else {
+			c.misses++
+		}
+		c.mu.Unlock()
+		if ok {
+			return res, nil
+		}
+		called := false
+		res, err, _ := c.group.Do(key, func() (interface{}, error) {
+			called = true
+			// The call is shared, so it mustn't end with or report its
+			// timings to only the first request.
+			var sh servertiming.Header
+			fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+			fctx = servertiming.NewContext(fctx, &sh)
+			res, err := f(fctx, r, &sh)
+			// Streams are written after f returns, so can't be shared,
+			// and read from fctx until written.
+			if stream, ok := res.(streamResult); ok {
+				write := stream.write
+				stream.write = func(w io.Writer) error {
+					defer cancel()
+					return write(w)
+				}
+				return stream, err
+			}
+			defer cancel()
+			if err == nil {
+				c.store.set(fctx, key, res, c.ttl)
+			}
+			return res, err
+		})
+		if _, stream := res.(streamResult); stream && !called {
+			// Another request's stream can't be written twice.
+			return f(ctx, r, timing)
+		}
+		return res, err
+	}
+}
+
+func (c *responseCache) vars() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]interface{}{
+		"entries": c.store.len(),
+		"hits":    c.hits,
+		"misses":  c.misses,
+	}
+}
+
+// memoryStore is a responseStore local to the process.
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+	swept   time.Time
+}
+
+type cachedResponse struct {
+	res     interface{}
+	expires time.Time
+}
+
+func (m *memoryStore) get(ctx context.Context, key string) (interface{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.res, true
+}
+
+func (m *memoryStore) set(ctx context.Context, key string, res interface{}, ttl time.Duration) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.swept) > ttl {
+		for k, e := range m.entries {
+			if now.After(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.swept = now
+	}
+	if _, ok := m.entries[key]; !ok && len(m.entries) >= cacheMaxEntries {
+		return
+	}
+	m.entries[key] = cachedResponse{res: res, expires: now.Add(ttl)}
+}
+
+func (m *memoryStore) flush(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = map[string]cachedResponse{}
+	return nil
+}
+
+func (m *memoryStore) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// redisKeyPrefix prefixes the keys of cached responses in Redis.
+const redisKeyPrefix = "ef:response:"
+
+// redisStore is a responseStore shared by all instances using the same Redis
+// server. Results are stored encoded, so a hit returns the JSON of the
+// original result rather than the result itself. Redis errors are logged and
+// treated as misses.
+type redisStore struct {
+	client *redis.Client
+}
+
+// redisResponse is an encoded result in a redisStore.
+type redisResponse struct {
+	// ContentType is set for rawResults.
+	ContentType string          `json:",omitempty"`
+	Data        json.RawMessage `json:",omitempty"`
+	Raw         []byte          `json:",omitempty"`
+	Modified    time.Time
+}
+
+func (rs redisStore) get(ctx context.Context, key string) (interface{}, bool) {
+	b, err := rs.client.Get(ctx, redisKeyPrefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			logger(ctx).Error("cache get", "err", err)
+		}
+		return nil, false
+	}
+	var rr redisResponse
+	if err := json.Unmarshal(b, &rr); err != nil {
+		logger(ctx).Error("cache decode", "err", err)
+		return nil, false
+	}
+	var res interface{} = rr.Data
+	if rr.ContentType != "" {
+		res = rawResult{contentType: rr.ContentType, data: rr.Raw}
+	}
+	if !rr.Modified.IsZero() {
+		res = modifiedResult{modified: rr.Modified, result: res}
+	}
+	return res, true
+}
+
+func (rs redisStore) set(ctx context.Context, key string, res interface{}, ttl time.Duration) {
+	var rr redisResponse
+	if m, ok := res.(modifiedResult); ok {
+		rr.Modified, res = m.modified, m.result
+	}
+	if raw, ok := res.(rawResult); ok {
+		rr.ContentType, rr.Raw = raw.contentType, raw.data
+	} else {
+		data, err := json.Marshal(res)
+		if err != nil {
+			logger(ctx).Error("cache encode", "err", err)
+			return
+		}
+		rr.Data = data
+	}
+	b, err := json.Marshal(rr)
+	if err != nil {
+		logger(ctx).Error("cache encode", "err", err)
+		return
+	}
+	if err := rs.client.Set(ctx, redisKeyPrefix+key, b, ttl).Err(); err != nil {
+		logger(ctx).Error("cache set", "err", err)
+	}
+}
+
+func (rs redisStore) flush(ctx context.Context) error {
+	iter := rs.client.Scan(ctx, 0, redisKeyPrefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		if err := rs.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func (rs redisStore) len() int {
+	return -1
+}
+
+const (
+	// encodingIdle is how long compressed responses are kept unused.
+	encodingIdle = time.Minute * 5
+	// encodingMaxBytes bounds the size of cached compressed responses.
+	encodingMaxBytes = 64 << 20
+	// encodingMaxEntries bounds the number of tracked tags, most of which
+	// are of one-off responses.
+	encodingMaxEntries = 10000
+)
+
+// encodingCache holds the compressed encodings of hot responses by their
+// ETag, so identical bytes aren't compressed again for every request. A
+// response is hot once it is seen twice within encodingIdle; one-off
+// responses only leave their tag. Once encodingMaxEntries tags are tracked,
+// new ones are ignored until idle ones are swept.
+type encodingCache struct {
+	mu      sync.Mutex
+	entries map[string]*encodedResponse
+	bytes   int
+	swept   time.Time
+}
+
+type encodedResponse struct {
+	// encoded holds the encodings, by name, requested since the response
+	// was seen again.
+	encoded map[string][]byte
+	used    time.Time
+}
+
+// compress returns the encoding of data named encoding, br or gzip, from the
+// cache if data is hot.
+func (c *encodingCache) compress(data []byte, encoding string) ([]byte, error) {
+	tag := dataTag(data)
+	now := time.Now()
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = map[string]*encodedResponse{}
+	}
+	if now.Sub(c.swept) > encodingIdle {
+		for k, e := range c.entries {
+			if now.Sub(e.used) > encodingIdle {
+				for _, b := range e.encoded {
+					c.bytes -= len(b)
+				}
+				delete(c.entries, k)
+			}
+		}
+		c.swept = now
+	}
+	e := c.entries[tag]
+	if e == nil {
+		if len(c.entries) < encodingMaxEntries {
+			c.entries[tag] = &encodedResponse{used: now}
+		}
+	} else {
+		e.used = now
+		if b, ok := e.encoded[encoding]; ok {
+			c.mu.Unlock()
+			return b, nil
+		}
+	}
+	c.mu.Unlock()
+
+	b, err := compress(data, encoding)
+	if err != nil || e == nil {
+		return b, err
+	}
+	c.mu.Lock()
+	// e may have been swept meanwhile.
+	if _, ok := e.encoded[encoding]; c.entries[tag] == e && !ok && c.bytes+len(b) <= encodingMaxBytes {
+		if e.encoded == nil {
+			e.encoded = map[string][]byte{}
+		}
+		e.encoded[encoding] = b
+		c.bytes += len(b)
+	}
+	c.mu.Unlock()
+	return b, nil
+}
+
+func (c *encodingCache) vars() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]interface{}{
+		"tags":  len(c.entries),
+		"bytes": c.bytes,
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// cardWidth and cardHeight are the recommended OpenGraph image size.
+	cardWidth  = 1200
+	cardHeight = 630
+	// cardIcon is the size of module icons on a card.
+	cardIcon = 64
+)
+
+var (
+	cardBackground = color.RGBA{0x1b, 0x1c, 0x1d, 0xff}
+	cardSlot       = color.RGBA{0x2c, 0x2d, 0x2f, 0xff}
+	cardText       = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+
+	cardFaceOnce sync.Once
+	cardFace     font.Face
+)
+
+// Card returns a PNG image of a fit, for use as an OpenGraph image. The
+// killmail ID is taken from a request path of the form /card/{id}.png.
+func (s *EFContext) Card(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	name := strings.TrimPrefix(r.URL.Path, "/card/")
+	id, err := strconv.Atoi(strings.TrimSuffix(name, ".png"))
+	if err != nil || !strings.HasSuffix(name, ".png") {
+		return nil, badRequest("invalid card path")
+	}
+	f, err := s.fit(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.card(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, errors.Wrap(err, "png encode")
+	}
+	return rawResult{contentType: "image/png", data: buf.Bytes()}, nil
+}
+
+// card composes the hull render, a row of module icons per rack, and the
+// ship name and fitted value.
+func (s *EFContext) card(ctx context.Context, f *FitDetail) (image.Image, error) {
+	racks := [][8]ItemCharge{f.Hi, f.Med, f.Low, f.Rig, f.Sub, f.Service}
+	ids := map[int32]bool{}
+	for _, rack := range racks {
+		for _, ic := range rack {
+			if ic.ID != 0 {
+				ids[ic.ID] = true
+			}
+		}
+	}
+	icons := s.typeImages(ctx, ids)
+	render, err := s.decodeTypeImage(ctx, "render", f.Ship.ID, 512)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(cardBackground), image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(img, image.Rect(40, 40, 550, 550), render, render.Bounds(), draw.Over, nil)
+
+	y := 40
+	for _, rack := range racks {
+		empty := true
+		for _, ic := range rack {
+			empty = empty && ic.ID == 0
+		}
+		if empty {
+			continue
+		}
+		x := 600
+		for _, ic := range rack {
+			if ic.ID == 0 {
+				continue
+			}
+			r := image.Rect(x, y, x+cardIcon, y+cardIcon)
+			draw.Draw(img, r, image.NewUniform(cardSlot), image.Point{}, draw.Src)
+			if icon := icons[ic.ID]; icon != nil {
+				xdraw.CatmullRom.Scale(img, r, icon, icon.Bounds(), draw.Over, nil)
+			}
+			x += cardIcon + 8
+		}
+		y += cardIcon + 16
+	}
+
+	face, err := cardFont()
+	if err != nil {
+		return nil, err
+	}
+	d := font.Drawer{Dst: img, Src: image.NewUniform(cardText), Face: face}
+	d.Dot = fixed.P(600, 530)
+	d.DrawString(f.Ship.Name)
+	d.Dot = fixed.P(600, 585)
+	d.DrawString(formatISK(f.Zkb.FittedValue))
+	return img, nil
+}
+
+func cardFont() (font.Face, error) {
+	var err error
+	cardFaceOnce.Do(func() {
+		var fnt *opentype.Font
+		fnt, err = opentype.Parse(gobold.TTF)
+		if err != nil {
+			return
+		}
+		cardFace, err = opentype.NewFace(fnt, &opentype.FaceOptions{Size: 40, DPI: 72, Hinting: font.HintingFull})
+	})
+	return cardFace, errors.Wrap(err, "card font")
+}
+
+// typeImages concurrently fetches and decodes icons of types. Icons that
+// fail to fetch are omitted.
+func (s *EFContext) typeImages(ctx context.Context, ids map[int32]bool) map[int32]image.Image {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	imgs := map[int32]image.Image{}
+	for id := range ids {
+		id := id
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			img, err := s.decodeTypeImage(ctx, "icon", id, cardIcon)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			imgs[id] = img
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return imgs
+}
+
+func (s *EFContext) decodeTypeImage(ctx context.Context, typ string, id int32, size int) (image.Image, error) {
+	data, _, err := s.typeImage(ctx, typ, id, size)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, errors.Wrapf(err, "decode %s %d", typ, id)
+}
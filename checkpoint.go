@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	checkpointRedisQ       = "redisq"
+	checkpointZKBWebsocket = "zkb-websocket"
+	// redisqRetention is how long redisq keeps a queue's position. Ingestion
+	// stopped for longer than this has missed killmails.
+	redisqRetention = 3 * time.Hour
+)
+
+// getCheckpoint returns the stored ingestion position of source and when it
+// was stored. ok is false if source has no checkpoint.
+func (s *EFContext) getCheckpoint(ctx context.Context, source string) (position string, updated time.Time, ok bool, err error) {
+	err = s.DB.QueryRowContext(ctx, `SELECT position, updated FROM checkpoints WHERE source = $1`, source).Scan(&position, &updated)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, false, nil
+	}
+	if err != nil {
+		return "", time.Time{}, false, errors.Wrapf(err, "checkpoint %s", source)
+	}
+	return position, updated, true, nil
+}
+
+// setCheckpoint stores the ingestion position of source.
+func (s *EFContext) setCheckpoint(ctx context.Context, source, position string) error {
+	_, err := s.DB.ExecContext(ctx, `UPSERT INTO checkpoints (source, position, updated) VALUES ($1, $2, now())`, source, position)
+	return errors.Wrapf(err, "checkpoint %s", source)
+}
+
+// checkpointKillmail records killmail id as the latest ingested from source,
+// logging failures since ingestion should continue regardless.
+func (s *EFContext) checkpointKillmail(ctx context.Context, source string, id int) {
+	if err := s.setCheckpoint(ctx, source, strconv.Itoa(id)); err != nil {
+		slog.Error("checkpoint", "source", source, "err", err)
+	}
+}
+
+// warnIngestionGap logs a warning if the last killmail ingested from source
+// is older than gap, since killmails since then were likely missed and need
+// a -backfill.
+func (s *EFContext) warnIngestionGap(ctx context.Context, source string, gap time.Duration) {
+	position, updated, ok, err := s.getCheckpoint(ctx, source)
+	if err != nil {
+		slog.Error("checkpoint", "source", source, "err", err)
+		return
+	}
+	if ok && time.Since(updated) > gap {
+		slog.Warn("ingestion gap, run -backfill to fill it",
+			"source", source,
+			"killmail", position,
+			"from", updated.Format(time.DateOnly),
+		)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cockroachdb/cockroach-go/crdb"
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxCommentLength is the most characters a comment may have.
+	maxCommentLength = 500
+	// commentRateCount is how many comments a character may post per
+	// commentRateWindow.
+	commentRateCount  = 5
+	commentRateWindow = time.Minute * 10
+	// commentAuthor is the deleted_by of comments deleted by their author.
+	commentAuthor = "author"
+)
+
+// Comment is a character's comment on a fit.
+type Comment struct {
+	ID        int64
+	Killmail  int
+	Character int32
+	// Name is the character's name.
+	Name    string
+	Body    string
+	Created time.Time
+}
+
+// Comments lists (GET) the comments on a fit, oldest first, or posts
+// (POST) or deletes (DELETE) a comment of the logged in character. Each
+// character may post commentRateCount comments per commentRateWindow.
+func (s *EFContext) Comments(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil || id <= 0 {
+			return nil, badRequest("id required")
+		}
+		return s.fitComments(ctx, id)
+	}
+	c, err := requireCharacter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Method {
+	case http.MethodPost:
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil || id <= 0 {
+			return nil, badRequest("id required")
+		}
+		body := strings.TrimSpace(r.FormValue("body"))
+		if body == "" || !utf8.ValidString(body) {
+			return nil, badRequest("body required")
+		}
+		if n := utf8.RuneCountInString(body); n > maxCommentLength {
+			return nil, badRequest("body is %d characters, at most %d allowed", n, maxCommentLength)
+		}
+		var exists bool
+		if err := s.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM fits WHERE killmail = $1)`, id).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, notFound("unknown fit")
+		}
+		ret := &Comment{Killmail: id, Character: c.ID, Name: c.Name, Body: body}
+		// The count and insert share a transaction so concurrent posts
+		// can't all pass the limit.
+		if err := crdb.ExecuteTx(ctx, s.DB, nil, func(tx *sql.Tx) error {
+			var recent int
+			if err := tx.QueryRowContext(ctx, `
+				SELECT
+					count(*)
+				FROM
+					fit_comments
+				WHERE
+					character = $1 AND created > $2
+			`, c.ID, time.Now().Add(-commentRateWindow).UTC()).Scan(&recent); err != nil {
+				return errors.Wrap(err, "count comments")
+			}
+			if recent >= commentRateCount {
+				return &httpError{Status: http.StatusTooManyRequests, Code: "rate_limited", Message: "too many comments, try again later"}
+			}
+			return errors.Wrap(tx.QueryRowContext(ctx, `
+				INSERT
+				INTO
+					fit_comments (killmail, character, body)
+				VALUES
+					($1, $2, $3)
+				RETURNING
+					id, created
+			`, id, c.ID, body).Scan(&ret.ID, &ret.Created), "post comment")
+		}); err != nil {
+			return nil, err
+		}
+		logger(ctx).Info("comment", "id", ret.ID, "killmail", id, "character", c.ID)
+		return ret, nil
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.FormValue("comment"), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, badRequest("comment required")
+		}
+		res, err := s.DB.ExecContext(ctx, `
+			UPDATE
+				fit_comments
+			SET
+				deleted = now(), deleted_by = $3
+			WHERE
+				id = $1 AND character = $2 AND deleted IS NULL
+		`, id, c.ID, commentAuthor)
+		if err != nil {
+			return nil, errors.Wrap(err, "delete comment")
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, notFound("unknown comment")
+		}
+		return nil, nil
+	}
+	return nil, methodNotAllowed(r.Method)
+}
+
+// fitComments returns the undeleted comments on fit id, oldest first.
+func (s *EFContext) fitComments(ctx context.Context, id int) ([]Comment, error) {
+	ret := []Comment{}
+	err := s.read(ctx).SelectContext(ctx, &ret, `
+		SELECT
+			fc.id, fc.killmail, fc.character, c.name, fc.body, fc.created
+		FROM
+			fit_comments AS fc JOIN characters AS c ON c.id = fc.character
+		WHERE
+			fc.killmail = $1 AND fc.deleted IS NULL
+		ORDER BY
+			fc.created
+	`, id)
+	return ret, errors.Wrap(err, "comments")
+}
+
+// ModerateComment hides (action=delete) or restores (action=restore) the
+// comment given by the comment parameter.
+func (s *EFContext) ModerateComment(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	start := time.Now()
+	id, err := strconv.ParseInt(r.FormValue("comment"), 10, 64)
+	if err != nil || id <= 0 {
+		return nil, badRequest("comment required")
+	}
+	var res sql.Result
+	switch action := r.FormValue("action"); action {
+	case "delete":
+		res, err = s.DB.ExecContext(ctx, `UPDATE fit_comments SET deleted = now(), deleted_by = $2 WHERE id = $1 AND deleted IS NULL`, id, apiKeyFromContext(ctx).Name)
+	case "restore":
+		res, err = s.DB.ExecContext(ctx, `UPDATE fit_comments SET deleted = NULL, deleted_by = NULL WHERE id = $1 AND deleted IS NOT NULL`, id)
+	default:
+		return nil, badRequest("action must be delete or restore, got %q", action)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "moderate comment")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, notFound("no such comment to %s", r.FormValue("action"))
+	}
+	logger(ctx).Info("moderated comment", "comment", id, "action", r.FormValue("action"))
+	return AdminResult{Action: "ModerateComment", Seconds: time.Since(start).Seconds()}, nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is the server configuration. Each field is read from the
+// environment variable of its upper-cased name, such as DB_ADDR, or from the
+// lower-cased key in the optional YAML config file, such as db_addr.
+// Environment variables take precedence over the file.
+type Config struct {
+	Port    string `default:"4001"`
+	DB_Addr string `default:"postgres://root@localhost:26257/ef?sslmode=disable"`
+	// DB_Replica_Addr is a read replica that queries of GET requests are
+	// sent to while it is healthy.
+	DB_Replica_Addr string
+	// DB_Max_Open and DB_Max_Idle bound the open and idle connections of
+	// each database pool, and DB_Conn_Lifetime how long a connection is
+	// reused. 0 means unlimited, except for DB_Max_Idle where database/sql
+	// keeps 2.
+	DB_Max_Open      int
+	DB_Max_Idle      int
+	DB_Conn_Lifetime time.Duration
+	// GRPC_Port enables the gRPC bulk API, which requires an API key, if set.
+	GRPC_Port string
+	// SDE_Dir is the extracted SDE directory or the official sde.zip
+	// archive, read for static data if the database has none and by the
+	// ReloadSDE admin endpoint.
+	SDE_Dir string `default:"sde"`
+	// SDE_Format is the format of SDE_Dir: yaml for CCP's SDE or fuzzwork
+	// for a directory of Fuzzwork's CSV tables.
+	SDE_Format string `default:"yaml"`
+	// RedisQ_Queue is the zKillboard redisq queue ID killmails are read
+	// from.
+	RedisQ_Queue string `default:"fittin.gs"`
+	// ZKB_Channels are the zKillboard websocket channels subscribed to by
+	// -zkb-websocket, such as killstream or ship:29984.
+	ZKB_Channels []string `default:"killstream"`
+	// Ingest_Skip are the ingestion rules of fits not to store, out of
+	// capsule, shuttle, rookie, and empty_hauler. Set it to an empty list
+	// to store all fits.
+	Ingest_Skip []string `default:"capsule,shuttle,rookie,empty_hauler"`
+	// Workers is how many killmails ProcessFits and FetchKillmails work on
+	// concurrently. Each worker waits at least Worker_Pace between
+	// killmails.
+	Workers     int `default:"1"`
+	Worker_Pace time.Duration
+	// Icon_Cache is the directory proxied type images are cached in.
+	Icon_Cache string `default:"icons"`
+	// Retention_Months enables the Archive sync job, which moves killmails
+	// older than this many months to gzipped ndjson files in Archive_Dir.
+	// A GCS or S3 bucket can be mounted at Archive_Dir.
+	Retention_Months int
+	Archive_Dir      string `default:"archive"`
+	// Rate_Limit is the sustained requests per second allowed per client,
+	// with bursts of up to Rate_Burst. A limit of 0 disables rate limiting.
+	Rate_Limit float64 `default:"10"`
+	Rate_Burst int     `default:"40"`
+	// Trusted_Proxies is the number of proxies, such as load balancers, in
+	// front of the server that append to X-Forwarded-For. Clients are
+	// identified by the address that many entries from its end, so ones
+	// they prepend are ignored. 0 ignores X-Forwarded-For.
+	Trusted_Proxies int `default:"1"`
+	// Cache_TTL is how long responses to anonymous GET requests are cached.
+	// 0 disables the cache. Cache_Redis, a redis:// URL, shares the cache
+	// between instances instead of keeping it in memory.
+	Cache_TTL   time.Duration `default:"10s"`
+	Cache_Redis string
+	// Request_Timeout bounds how long API handlers may run before a 504
+	// is returned, unless the route sets its own. Route_Timeouts sets the
+	// timeouts of routes by name, such as Search:5s,Fits:5m.
+	Request_Timeout time.Duration `default:"60s"`
+	Route_Timeouts  map[string]time.Duration
+	// CORS_Origins are the origins browsers may make cross-origin requests
+	// from, or * for any. An empty list disables CORS. Cross-origin requests
+	// may use the CORS_Methods a route supports. CORS_Credentials allows
+	// cookies and Authorization headers from CORS_Origins, which must then
+	// be listed rather than *.
+	CORS_Origins     []string `default:"*"`
+	CORS_Methods     []string `default:"GET,HEAD,POST,DELETE"`
+	CORS_Credentials bool
+	// SSO_Client_ID enables logging in with EVE SSO using the application
+	// with this ID and SSO_Secret, registered with SSO_Callback, the URL of
+	// /sso/callback, and requesting SSO_Scopes. SSO_Secret also keys the
+	// encryption of stored character tokens, so changing it requires
+	// characters to log in again. Cookies are Secure if SSO_Callback is an
+	// https URL.
+	SSO_Client_ID string
+	SSO_Secret    string
+	SSO_Callback  string
+	SSO_Scopes    []string `default:"publicData"`
+	// Admin_Port enables pprof and expvar debug endpoints if set. A port
+	// without a host listens on localhost only.
+	Admin_Port string
+	// Drain_Timeout bounds how long shutdown waits for in-flight requests
+	// and sync jobs.
+	Drain_Timeout time.Duration `default:"20s"`
+	// OTLP_Endpoint enables tracing to an OTLP/HTTP collector at this URL,
+	// such as http://localhost:4318.
+	OTLP_Endpoint string
+	// TLS_Domains enables HTTPS with certificates from Let's Encrypt for
+	// these domains, cached in the TLS_Cache directory.
+	TLS_Domains []string
+	TLS_Cache   string `default:"certs"`
+	// TLS_Cert and TLS_Key enable HTTPS with the certificate and key at these
+	// paths.
+	TLS_Cert string
+	TLS_Key  string
+	// Redirect_Port, if set with TLS enabled, serves redirects from HTTP to
+	// HTTPS.
+	Redirect_Port string
+	// Sync_Secret, if set, is accepted in the X-Sync-Secret header to
+	// authorize /api/Sync, such as from Cloud Scheduler. Admin API keys are
+	// also accepted.
+	Sync_Secret string
+	// Log_Level is the minimum log level: debug, info, warn, or error. Debug
+	// logs DB queries.
+	Log_Level string `default:"info"`
+	// Log_Format is json or text.
+	Log_Format string `default:"json"`
+}
+
+// loadConfig returns the configuration from the environment and, if path is
+// not empty, the YAML file at path. The result is validated and normalized.
+func loadConfig(path string) (*Config, error) {
+	var env Config
+	if err := envconfig.Process("", &env); err != nil {
+		return nil, errors.Wrap(err, "environment")
+	}
+	cfg := env
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+			return nil, errors.Wrap(err, path)
+		}
+		// Restore fields set in the environment, which override the file.
+		ev, cv := reflect.ValueOf(&env).Elem(), reflect.ValueOf(&cfg).Elem()
+		for i := 0; i < ev.NumField(); i++ {
+			if _, ok := os.LookupEnv(strings.ToUpper(ev.Type().Field(i).Name)); ok {
+				cv.Field(i).Set(ev.Field(i))
+			}
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// validate checks c for invalid values and normalizes bare ports to
+// addresses.
+func (c *Config) validate() error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(c.Log_Level)); err != nil {
+		return errors.Errorf("log_level: unknown level %q", c.Log_Level)
+	}
+	switch strings.ToLower(c.Log_Format) {
+	case "json", "text":
+	default:
+		return errors.Errorf("log_format: must be json or text, got %q", c.Log_Format)
+	}
+	if c.RedisQ_Queue == "" {
+		return errors.New("redisq_queue: required")
+	}
+	if len(c.ZKB_Channels) == 0 {
+		return errors.New("zkb_channels: required")
+	}
+	if sdeFormats[c.SDE_Format] == nil {
+		return errors.Errorf("sde_format: must be yaml or fuzzwork, got %q", c.SDE_Format)
+	}
+	for _, name := range c.Ingest_Skip {
+		if ingestRules[name] == nil {
+			return errors.Errorf("ingest_skip: unknown rule %q, must be one of %s", name, ingestRuleNames())
+		}
+	}
+	if c.Port == "" {
+		return errors.New("port: required")
+	}
+	if _, err := url.Parse(c.DB_Addr); err != nil {
+		return errors.Wrap(err, "db_addr")
+	}
+	if _, err := url.Parse(c.DB_Replica_Addr); err != nil {
+		return errors.Wrap(err, "db_replica_addr")
+	}
+	if c.DB_Max_Open < 0 || c.DB_Max_Idle < 0 || c.DB_Conn_Lifetime < 0 {
+		return errors.New("db_max_open, db_max_idle, and db_conn_lifetime: must not be negative")
+	}
+	if c.OTLP_Endpoint != "" {
+		if u, err := url.Parse(c.OTLP_Endpoint); err != nil || u.Host == "" {
+			return errors.Errorf("otlp_endpoint: invalid URL %q", c.OTLP_Endpoint)
+		}
+	}
+	if c.Rate_Limit < 0 {
+		return errors.New("rate_limit: must not be negative")
+	}
+	if c.Rate_Limit > 0 && c.Rate_Burst < 1 {
+		return errors.New("rate_burst: must be at least 1")
+	}
+	if c.Trusted_Proxies < 0 {
+		return errors.New("trusted_proxies: must not be negative")
+	}
+	if c.Cache_TTL < 0 {
+		return errors.New("cache_ttl: must not be negative")
+	}
+	if c.Request_Timeout <= 0 {
+		return errors.New("request_timeout: must be positive")
+	}
+	for name, d := range c.Route_Timeouts {
+		if d <= 0 {
+			return errors.Errorf("route_timeouts: %s: must be positive", name)
+		}
+	}
+	for i, m := range c.CORS_Methods {
+		c.CORS_Methods[i] = strings.ToUpper(m)
+	}
+	for _, o := range c.CORS_Origins {
+		if o == "*" {
+			if c.CORS_Credentials {
+				return errors.New("cors_credentials: cors_origins must be listed, not *")
+			}
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return errors.Errorf("cors_origins: invalid origin %q, such as https://example.com", o)
+		}
+	}
+	if c.SSO_Client_ID != "" {
+		if c.SSO_Secret == "" {
+			return errors.New("sso_secret: required with sso_client_id")
+		}
+		if u, err := url.Parse(c.SSO_Callback); err != nil || u.Host == "" || u.Path != "/sso/callback" {
+			return errors.Errorf("sso_callback: must be the URL of /sso/callback, got %q", c.SSO_Callback)
+		}
+	}
+	if c.Cache_Redis != "" {
+		if u, err := url.Parse(c.Cache_Redis); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") {
+			return errors.Errorf("cache_redis: invalid redis URL %q", c.Cache_Redis)
+		}
+	}
+	if (c.TLS_Cert == "") != (c.TLS_Key == "") {
+		return errors.New("tls_cert and tls_key: must be set together")
+	}
+	if c.TLS_Cert != "" && len(c.TLS_Domains) > 0 {
+		return errors.New("tls_domains: cannot be used with tls_cert")
+	}
+	if c.Redirect_Port != "" && c.TLS_Cert == "" && len(c.TLS_Domains) == 0 {
+		return errors.New("redirect_port: requires TLS")
+	}
+	if c.Retention_Months < 0 {
+		return errors.New("retention_months: must not be negative")
+	}
+	if c.Workers < 1 {
+		return errors.New("workers: must be at least 1")
+	}
+	if c.Worker_Pace < 0 {
+		return errors.New("worker_pace: must not be negative")
+	}
+	if c.Drain_Timeout <= 0 {
+		return errors.New("drain_timeout: must be positive")
+	}
+
+	if !strings.Contains(c.Port, ":") {
+		c.Port = ":" + c.Port
+	}
+	if c.GRPC_Port != "" && !strings.Contains(c.GRPC_Port, ":") {
+		c.GRPC_Port = ":" + c.GRPC_Port
+	}
+	if c.Admin_Port != "" && !strings.Contains(c.Admin_Port, ":") {
+		c.Admin_Port = "localhost:" + c.Admin_Port
+	}
+	if c.Redirect_Port != "" && !strings.Contains(c.Redirect_Port, ":") {
+		c.Redirect_Port = ":" + c.Redirect_Port
+	}
+	return nil
+}
+
+// print writes c as YAML to stdout with passwords and secrets
+// redacted.
+func (c Config) print() error {
+	c.DB_Addr = redactURL(c.DB_Addr)
+	if c.DB_Replica_Addr != "" {
+		c.DB_Replica_Addr = redactURL(c.DB_Replica_Addr)
+	}
+	if c.Cache_Redis != "" {
+		c.Cache_Redis = redactURL(c.Cache_Redis)
+	}
+	if c.Sync_Secret != "" {
+		c.Sync_Secret = "xxxxx"
+	}
+	if c.SSO_Secret != "" {
+		c.SSO_Secret = "xxxxx"
+	}
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Print(string(b))
+	return err
+}
+
+// redactURL returns u with any password replaced by "xxxxx".
+func redactURL(u string) string {
+	p, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return p.Redacted()
+}
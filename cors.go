@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsPolicy decides which cross-origin requests browsers may make.
+type corsPolicy struct {
+	// origins are the allowed origins, or * for any. No origins disables
+	// CORS.
+	origins []string
+	// methods are the methods cross-origin requests may use, of those a
+	// route supports.
+	methods []string
+	// credentials allows cookies and Authorization headers in requests
+	// from origins.
+	credentials bool
+}
+
+const (
+	corsAllowHeaders  = "Content-Type, Authorization, X-Request-Id, X-Share-Token"
+	corsExposeHeaders = "X-RateLimit-Limit, X-RateLimit-Remaining, Retry-After, X-Request-Id, API-Version, Deprecation, Link"
+)
+
+// allowOrigin returns the Access-Control-Allow-Origin of a request from
+// origin, or "" if it isn't allowed.
+func (p *corsPolicy) allowOrigin(origin string) string {
+	for _, o := range p.origins {
+		if o == "*" && !p.credentials {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// apply sets the CORS headers of the response to r. It is called before any
+// response, including errors, is written.
+func (p *corsPolicy) apply(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	allow := p.allowOrigin(r.Header.Get("Origin"))
+	if allow != "*" {
+		// Responses differ between origins.
+		h.Add("Vary", "Origin")
+	}
+	if allow == "" {
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", allow)
+	h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight answers an OPTIONS preflight request to a route supporting
+// methods.
+func (p *corsPolicy) preflight(w http.ResponseWriter, r *http.Request, methods []string) {
+	p.apply(w, r)
+	var allowed []string
+	for _, m := range p.methods {
+		for _, rm := range methods {
+			if m == rm || (m == http.MethodHead && rm == http.MethodGet) {
+				allowed = append(allowed, m)
+				break
+			}
+		}
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "" && len(allowed) > 0 {
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+		w.Header().Set("Access-Control-Max-Age", "3600")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// csvContentType is the content type of CSV results.
+const csvContentType = "text/csv; charset=utf-8"
+
+// wantsCSV reports whether the client requested CSV by format parameter or
+// Accept header.
+func wantsCSV(r *http.Request) bool {
+	return r.FormValue("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv")
+}
+
+// fitsCSVHeader is the header row of fits as CSV.
+var fitsCSVHeader = []string{"killmail", "ship", "cost", "dropped", "points", "high", "medium", "low"}
+
+// fitCSVRecord returns the CSV row of f. Each rack's modules are a single
+// column of semicolon-separated names.
+func fitCSVRecord(f *FitSummary) []string {
+	rack := func(items []Item) string {
+		names := make([]string, len(items))
+		for i, item := range items {
+			names[i] = item.Name
+		}
+		return strings.Join(names, "; ")
+	}
+	return []string{
+		strconv.Itoa(f.Killmail),
+		f.Name,
+		strconv.FormatInt(f.Cost, 10),
+		strconv.FormatInt(f.Dropped, 10),
+		strconv.Itoa(f.Points),
+		rack(f.Hi),
+		rack(f.Med),
+		rack(f.Lo),
+	}
+}
@@ -6,26 +6,41 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
 
-	"github.com/lib/pq"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4/stdlib"
 	servertiming "github.com/mitchellh/go-server-timing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 )
 
+const driverName = "postgres-log"
+
+func init() {
+	sql.Register(driverName, drv{})
+}
+
 func mustInitDB(dataSource string) *sql.DB {
-	const name = "postgres-log"
-	sql.Register(name, drv{})
-	db, err := sql.Open(name, dataSource)
+	db, err := sql.Open(driverName, dataSource)
 	if err != nil {
 		panic(err)
 	}
 	return db
 }
 
+// configurePool applies the pool settings of c to db.
+func (c *Config) configurePool(db *sql.DB) {
+	db.SetMaxOpenConns(c.DB_Max_Open)
+	if c.DB_Max_Idle > 0 {
+		db.SetMaxIdleConns(c.DB_Max_Idle)
+	}
+	db.SetConnMaxLifetime(c.DB_Conn_Lifetime)
+}
+
 func mustExec(db *sql.DB, query string, params ...interface{}) {
 	if _, err := db.Exec(query, params...); err != nil {
 		panic(err)
@@ -35,10 +50,10 @@ func mustExec(db *sql.DB, query string, params ...interface{}) {
 type drv struct{}
 
 func (d drv) Open(name string) (driver.Conn, error) {
-	c, err := pq.Open(name)
+	c, err := stdlib.GetDefaultDriver().Open(name)
 	c = &conn{
 		Conn: c,
-		log:  *flagLog,
+		log:  slog.Default().Enabled(context.Background(), slog.LevelDebug),
 	}
 	return c, err
 }
@@ -57,16 +72,20 @@ func addTiming(ctx context.Context, name string, query string, args []driver.Nam
 	}
 	m := servertiming.FromContext(ctx).NewMetric(name).Start()
 	m.Desc = esc(query)
-	return func() { m.Stop() }
+	_, span := startChildSpan(ctx, name, semconv.DBSystemCockroachdb, semconv.DBStatement(query))
+	return func() {
+		m.Stop()
+		span.End()
+	}
 }
 
-// conn implements a logging driver.Conn that logs queries.
+// conn implements a logging driver.Conn that logs queries at debug level.
 type conn struct {
 	driver.Conn
 	log bool
 }
 
-func (c *conn) logQuery(query string, args interface{}) {
+func (c *conn) logQuery(ctx context.Context, query string, args interface{}) {
 	if !c.log {
 		return
 	}
@@ -74,56 +93,55 @@ func (c *conn) logQuery(query string, args interface{}) {
 	if len(as) > 100 {
 		as = as[:100] + "..."
 	}
-	log.Printf("Query: %v: %s", as, query)
+	logger(ctx).Debug("query", "args", as, "query", query)
 }
 
 func (c *conn) Prepare(query string) (driver.Stmt, error) {
-	c.logQuery(query, "[prepare]")
+	c.logQuery(context.Background(), query, "[prepare]")
 	return c.Conn.Prepare(query)
 }
 
 func (c *conn) Begin() (driver.Tx, error) {
-	c.logQuery("Begin()", nil)
+	c.logQuery(context.Background(), "Begin()", nil)
 	return c.Conn.Begin()
 }
 
 func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
-	c.logQuery("BeginTx()", nil)
+	c.logQuery(ctx, "BeginTx()", nil)
 	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
 }
 
 func (c *conn) QueryContext(
 	ctx context.Context, query string, args []driver.NamedValue,
 ) (driver.Rows, error) {
-	c.ExplainNamed(query, args)
+	c.logQuery(ctx, query, args)
+	c.Explain(ctx, query, args)
 	defer addTiming(ctx, "QUERY", query, args)()
 	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
 }
 
-func (c *conn) Query(query string, args []driver.Value) (driver.Rows, error) {
-	c.Explain(query, args)
-	return c.Conn.(driver.Queryer).Query(query, args)
-}
-
-func (c *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
-	c.logQuery(query, args)
-	return c.Conn.(driver.Execer).Exec(query, args)
-}
-
 func (c *conn) ExecContext(
 	ctx context.Context, query string, args []driver.NamedValue,
-) (sql.Result, error) {
-	c.logQuery(query, args)
+) (driver.Result, error) {
+	c.logQuery(ctx, query, args)
 	defer addTiming(ctx, "EXEC", query, args)()
 	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
 }
 
-func (c *conn) ExplainNamed(query string, args []driver.NamedValue) {
-	values := make([]driver.Value, len(args))
-	for i, v := range args {
-		values[i] = v.Value
-	}
-	c.Explain(query, values)
+// CheckNamedValue lets pgx encode arguments such as slices, which
+// database/sql would otherwise reject.
+func (c *conn) CheckNamedValue(v *driver.NamedValue) error {
+	return c.Conn.(driver.NamedValueChecker).CheckNamedValue(v)
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+// Ping lets db.PingContext check the connection; without it database/sql
+// only opens a connection, which succeeds for pooled ones.
+func (c *conn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
 }
 
 var outputLock sync.Mutex
@@ -136,14 +154,14 @@ func sqlfmt(query string) string {
 	return query
 }
 
-func (c *conn) Explain(query string, args []driver.Value) {
+func (c *conn) Explain(ctx context.Context, query string, args []driver.NamedValue) {
 	if !c.log {
 		return
 	}
 	outputLock.Lock()
-	fmt.Print(sqlfmt("explain "+query), args, "\n")
+	slog.Debug("explain", "query", sqlfmt(query), "args", fmt.Sprint(args))
 	if err := func() error {
-		rows, err := c.Conn.(driver.Queryer).Query("EXPLAIN "+query, args)
+		rows, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, "EXPLAIN "+query, args)
 		if err != nil {
 			return err
 		}
@@ -157,13 +175,13 @@ func (c *conn) Explain(query string, args []driver.Value) {
 			} else if err != nil {
 				return err
 			}
-			fmt.Print("\t", values, "\n")
+			slog.Debug("explain", "row", fmt.Sprint(values))
 		}
 	}(); err != nil {
-		fmt.Println(err)
+		slog.Debug("explain", "err", err)
 	}
 	if err := func() error {
-		rows, err := c.Conn.(driver.Queryer).Query("EXPLAIN ANALYZE (distsql) "+query, args)
+		rows, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, "EXPLAIN ANALYZE (distsql) "+query, args)
 		if err != nil {
 			return err
 		}
@@ -177,10 +195,33 @@ func (c *conn) Explain(query string, args []driver.Value) {
 			} else if err != nil {
 				return err
 			}
-			fmt.Println("EXPLAIN ANALYZE (distsql)", values)
+			slog.Debug("explain analyze", "row", fmt.Sprint(values))
 		}
 	}(); err != nil {
-		fmt.Println(err)
+		slog.Debug("explain", "err", err)
 	}
 	outputLock.Unlock()
 }
+
+// int32Array scans an INT4[] column, which database/sql receives from pgx
+// as text.
+type int32Array []int32
+
+func (a *int32Array) Scan(src interface{}) error {
+	var arr pgtype.Int4Array
+	if err := arr.Scan(src); err != nil {
+		return err
+	}
+	return arr.AssignTo((*[]int32)(a))
+}
+
+// stringArray scans a STRING[] column like int32Array.
+type stringArray []string
+
+func (a *stringArray) Scan(src interface{}) error {
+	var arr pgtype.TextArray
+	if err := arr.Scan(src); err != nil {
+		return err
+	}
+	return arr.AssignTo((*[]string)(a))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/cockroach-go/crdb"
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+)
+
+// deadLetterLimit is the most dead letters DeadLetters returns.
+const deadLetterLimit = 100
+
+const (
+	// deadLetterFetch is the stage of killmails that could not be fetched
+	// from ESI.
+	deadLetterFetch = "fetch"
+	// deadLetterProcess is the stage of killmails that failed to process.
+	deadLetterProcess = "process"
+)
+
+// DeadLetter is a killmail that failed to fetch or process.
+type DeadLetter struct {
+	Killmail int32
+	// Stage is fetch or process.
+	Stage string
+	Error string
+	// Attempts is how many times processing has failed.
+	Attempts int
+	Failed   time.Time
+}
+
+// deadLetter records that stage failed for killmail id with cause and marks
+// it failed, so FetchKillmails or ProcessFits skips it until it is retried.
+func (s *EFContext) deadLetter(ctx context.Context, id int32, stage string, cause error) error {
+	err := crdb.ExecuteTx(ctx, s.DB, nil, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT
+			INTO
+				dead_letter (killmail, stage, error)
+			VALUES
+				($1, $2, $3)
+			ON CONFLICT
+				(killmail)
+			DO
+				UPDATE SET stage = excluded.stage, error = excluded.error, attempts = dead_letter.attempts + 1, failed = now()
+		`, id, stage, cause.Error()); err != nil {
+			return err
+		}
+		if stage == deadLetterFetch {
+			_, err := tx.ExecContext(ctx, `UPDATE hashes SET processed = $2 WHERE id = $1`, id, ProcHashFailed)
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE killmails SET processed = $2 WHERE id = $1`, id, ProcKMFailed)
+		return err
+	})
+	if err != nil {
+		slog.Error("dead letter", "killmail", id, "err", err)
+	}
+	return errors.Wrap(err, "dead letter")
+}
+
+// DeadLetters returns the most recently failed killmails.
+func (s *EFContext) DeadLetters(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	letters := []DeadLetter{}
+	if err := s.X.SelectContext(ctx, &letters, `
+		SELECT
+			killmail, stage, error, attempts, failed
+		FROM
+			dead_letter
+		ORDER BY
+			failed DESC
+		LIMIT
+			$1
+	`, deadLetterLimit); err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
+
+// RetryDeadLetters queues the failed killmail given by the killmail
+// parameter, or all failed killmails if it is unset, to be fetched or
+// processed again by the next FetchKillmails or ProcessFits. Killmails that
+// fail again have their attempts incremented; those that succeed are removed
+// from the dead letters.
+func (s *EFContext) RetryDeadLetters(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	start := time.Now()
+	// where matches all killmails or the one given, which is $2.
+	where := ""
+	args := []interface{}{0}
+	if v := r.FormValue("killmail"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, badRequest("invalid killmail")
+		}
+		where = " AND id = $2"
+		args = append(args, id)
+	}
+	var n int64
+	for _, q := range []struct {
+		query  string
+		failed int
+	}{
+		{`UPDATE killmails SET processed = 0 WHERE processed = $1`, ProcKMFailed},
+		// Fetches get fetchAttemptLimit new attempts.
+		{`UPDATE hashes SET processed = 0, attempts = 0 WHERE processed = $1`, ProcHashFailed},
+	} {
+		args[0] = q.failed
+		res, err := s.DB.ExecContext(ctx, q.query+where, args...)
+		if err != nil {
+			return nil, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		n += affected
+	}
+	logger(ctx).Info("retrying dead letters", "killmails", n)
+	return AdminResult{Action: "RetryDeadLetters", Seconds: time.Since(start).Seconds()}, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// discordNotifier posts a message with an embed of the fit to Discord.
+type discordNotifier struct{}
+
+func (discordNotifier) validURL(u *url.URL) bool {
+	return (u.Host == "discord.com" || u.Host == "discordapp.com") && strings.HasPrefix(u.Path, "/api/webhooks/")
+}
+
+func (discordNotifier) payload(f *FitDetail) interface{} {
+	type field struct {
+		Name   string `json:"name"`
+		Value  string `json:"value"`
+		Inline bool   `json:"inline,omitempty"`
+	}
+	type image struct {
+		URL string `json:"url"`
+	}
+	type embed struct {
+		Title       string  `json:"title"`
+		URL         string  `json:"url"`
+		Description string  `json:"description"`
+		Thumbnail   image   `json:"thumbnail"`
+		Fields      []field `json:"fields"`
+	}
+	eft := f.EFT()
+	// Discord limits descriptions to 4096 characters.
+	eft = truncate(eft, 4000)
+	return struct {
+		Embeds []embed `json:"embeds"`
+	}{
+		Embeds: []embed{{
+			Title:       f.Ship.Name,
+			URL:         fmt.Sprintf("%s/fit/%d", siteURL, f.Killmail),
+			Description: "```\n" + eft + "```",
+			Thumbnail:   image{URL: fmt.Sprintf("https://images.evetech.net/types/%d/render?size=128", f.Ship.ID)},
+			Fields: []field{
+				{Name: "Fitted value", Value: formatISK(f.Zkb.FittedValue), Inline: true},
+				{Name: "Total value", Value: formatISK(f.Zkb.TotalValue), Inline: true},
+				{Name: "zKillboard", Value: fmt.Sprintf("https://zkillboard.com/kill/%d/", f.Killmail)},
+			},
+		}},
+	}
+}
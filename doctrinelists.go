@@ -0,0 +1,539 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxDoctrineLists is how many doctrine lists a character may own.
+	maxDoctrineLists = 50
+	// maxDoctrineListFits is how many fits a doctrine list may have.
+	maxDoctrineListFits = 100
+	// maxDoctrineNameLength and maxDoctrineNoteLength are the most
+	// characters of list names and fit notes.
+	maxDoctrineNameLength = 100
+	maxDoctrineNoteLength = 500
+)
+
+// DoctrineList is a named list of fits kept by a character, such as the
+// fits flown by a fleet doctrine. Members of the owner's corporation may
+// view it, as may members of Alliance if it is set, and anyone with its
+// share token.
+type DoctrineList struct {
+	ID int64
+	// Owner is the ID of the character that created the list.
+	Owner       int32
+	OwnerName   string
+	Name        string
+	Corporation int32
+	Alliance    int32 `json:",omitempty"`
+	// ShareToken, set only for the owner, lets anyone view the list with
+	// the token parameter. It is empty if the list isn't shared.
+	ShareToken string `json:",omitempty"`
+	Created    time.Time
+	Updated    time.Time
+	// Fits is set when a single list is requested.
+	Fits []*DoctrineListFit `json:",omitempty"`
+}
+
+// DoctrineListFit is an entry of a doctrine list: a killmail fit or a
+// theoretical fit, with a note.
+type DoctrineListFit struct {
+	ID          int64
+	Note        string
+	Added       time.Time
+	Fit         *FitSummary     `json:",omitempty"`
+	Theoretical *TheoreticalFit `json:",omitempty"`
+}
+
+// doctrineListRow is a row of the doctrine_lists table.
+type doctrineListRow struct {
+	ID          int64
+	Owner       int32
+	OwnerName   string `db:"owner_name"`
+	Name        string
+	Corporation int32
+	Alliance    sql.NullInt32
+	ShareToken  sql.NullString `db:"share_token"`
+	Created     time.Time
+	Updated     time.Time
+}
+
+const doctrineListSelect = `
+	SELECT
+		dl.id, dl.owner, c.name AS owner_name, dl.name, dl.corporation, dl.alliance, dl.share_token, dl.created, dl.updated
+	FROM
+		doctrine_lists AS dl JOIN characters AS c ON c.id = dl.owner
+`
+
+// list returns row as seen by viewer, hiding the share token from all but
+// the owner.
+func (row doctrineListRow) list(viewer int32) *DoctrineList {
+	ret := &DoctrineList{
+		ID:          row.ID,
+		Owner:       row.Owner,
+		OwnerName:   row.OwnerName,
+		Name:        row.Name,
+		Corporation: row.Corporation,
+		Alliance:    row.Alliance.Int32,
+		Created:     row.Created,
+		Updated:     row.Updated,
+	}
+	if viewer == row.Owner {
+		ret.ShareToken = row.ShareToken.String
+	}
+	return ret
+}
+
+// DoctrineLists lists (GET) the doctrine lists of the logged in character
+// and those shared with its corporation or alliance, or returns the one given
+// by id with its fits. A list given by id may also be viewed without logging
+// in using its share token, given by the token parameter or X-Share-Token
+// header. POST creates a list, or renames, shares, or shares with the
+// owner's alliance the one given by id, and DELETE deletes one. Only a
+// list's owner may change it. Corporation and alliance membership is
+// verified with ESI.
+func (s *EFContext) DoctrineLists(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	var id int64
+	if v := r.FormValue("id"); v != "" {
+		var err error
+		id, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, badRequest("invalid id")
+		}
+	}
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && id != 0 {
+		token := r.Header.Get("X-Share-Token")
+		if token == "" {
+			token = r.FormValue("token")
+		}
+		return s.doctrineList(ctx, id, token)
+	}
+	c, err := requireCharacter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		corp, alliance, err := s.characterAffiliation(ctx, c.ID)
+		if err != nil {
+			return nil, err
+		}
+		var rows []doctrineListRow
+		// Lists are read from the primary so a list just created is listed.
+		// Characters without an alliance have alliance 0, which no list has.
+		if err := s.X.SelectContext(ctx, &rows, doctrineListSelect+`
+			WHERE
+				dl.owner = $1 OR dl.corporation = $2 OR dl.alliance = $3
+			ORDER BY
+				dl.name, dl.id
+		`, c.ID, corp, alliance); err != nil {
+			return nil, errors.Wrap(err, "doctrine lists")
+		}
+		ret := make([]*DoctrineList, len(rows))
+		for i, row := range rows {
+			ret[i] = row.list(c.ID)
+		}
+		return ret, nil
+	case http.MethodPost:
+		if id == 0 {
+			return s.createDoctrineList(ctx, r, c)
+		}
+		return s.updateDoctrineList(ctx, r, c, id)
+	case http.MethodDelete:
+		if id == 0 {
+			return nil, badRequest("id required")
+		}
+		res, err := s.DB.ExecContext(ctx, `DELETE FROM doctrine_lists WHERE id = $1 AND owner = $2`, id, c.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "delete doctrine list")
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, notFound("unknown doctrine list")
+		}
+		return nil, nil
+	}
+	return nil, methodNotAllowed(r.Method)
+}
+
+// doctrineListName returns the trimmed name parameter of r.
+func doctrineListName(r *http.Request) (string, error) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || !utf8.ValidString(name) {
+		return "", badRequest("name required")
+	}
+	if n := utf8.RuneCountInString(name); n > maxDoctrineNameLength {
+		return "", badRequest("name is %d characters, at most %d allowed", n, maxDoctrineNameLength)
+	}
+	return name, nil
+}
+
+// shareToken returns a new share token if the share parameter of r is true,
+// or NULL if it is false.
+func shareToken(r *http.Request) (interface{}, error) {
+	share, err := strconv.ParseBool(r.FormValue("share"))
+	if err != nil {
+		return nil, badRequest("share must be true or false")
+	}
+	if !share {
+		return nil, nil
+	}
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// listAlliance returns the alliance of c if the alliance parameter of r is
+// true, or NULL if it is false.
+func (s *EFContext) listAlliance(ctx context.Context, r *http.Request, c *Character) (interface{}, error) {
+	share, err := strconv.ParseBool(r.FormValue("alliance"))
+	if err != nil {
+		return nil, badRequest("alliance must be true or false")
+	}
+	if !share {
+		return nil, nil
+	}
+	_, alliance, err := s.characterAffiliation(ctx, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	if alliance == 0 {
+		return nil, badRequest("character is not in an alliance")
+	}
+	return alliance, nil
+}
+
+func (s *EFContext) createDoctrineList(ctx context.Context, r *http.Request, c *Character) (*DoctrineList, error) {
+	name, err := doctrineListName(r)
+	if err != nil {
+		return nil, err
+	}
+	var token, alliance interface{}
+	if r.FormValue("share") != "" {
+		if token, err = shareToken(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.FormValue("alliance") != "" {
+		if alliance, err = s.listAlliance(ctx, r, c); err != nil {
+			return nil, err
+		}
+	}
+	var n int
+	if err := s.DB.QueryRowContext(ctx, `SELECT count(*) FROM doctrine_lists WHERE owner = $1`, c.ID).Scan(&n); err != nil {
+		return nil, errors.Wrap(err, "count doctrine lists")
+	}
+	if n >= maxDoctrineLists {
+		return nil, badRequest("at most %d doctrine lists may be created", maxDoctrineLists)
+	}
+	corp, _, err := s.characterAffiliation(ctx, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	var row doctrineListRow
+	if err := s.X.GetContext(ctx, &row, `
+		INSERT
+		INTO
+			doctrine_lists (owner, name, corporation, alliance, share_token)
+		VALUES
+			($1, $2, $3, $4, $5)
+		RETURNING
+			id, owner, name, corporation, alliance, share_token, created, updated
+	`, c.ID, name, corp, alliance, token); err != nil {
+		return nil, errors.Wrap(err, "create doctrine list")
+	}
+	row.OwnerName = c.Name
+	logger(ctx).Info("doctrine list", "id", row.ID, "character", c.ID, "corporation", corp)
+	return row.list(c.ID), nil
+}
+
+func (s *EFContext) updateDoctrineList(ctx context.Context, r *http.Request, c *Character, id int64) (*DoctrineList, error) {
+	var sets []string
+	var args []interface{}
+	if r.FormValue("name") != "" {
+		name, err := doctrineListName(r)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, name)
+		sets = append(sets, "name = $"+strconv.Itoa(len(args)+2))
+	}
+	if r.FormValue("share") != "" {
+		token, err := shareToken(r)
+		if err != nil {
+			return nil, err
+		}
+		if token == nil {
+			sets = append(sets, "share_token = NULL")
+		} else {
+			// Sharing an already shared list keeps its token so existing
+			// links keep working.
+			args = append(args, token)
+			sets = append(sets, "share_token = COALESCE(share_token, $"+strconv.Itoa(len(args)+2)+")")
+		}
+	}
+	if r.FormValue("alliance") != "" {
+		alliance, err := s.listAlliance(ctx, r, c)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, alliance)
+		sets = append(sets, "alliance = $"+strconv.Itoa(len(args)+2))
+	}
+	if len(sets) == 0 {
+		return nil, badRequest("name, share, or alliance required")
+	}
+	var row doctrineListRow
+	err := s.X.GetContext(ctx, &row, `
+		UPDATE
+			doctrine_lists
+		SET
+			`+strings.Join(sets, ", ")+`, updated = now()
+		WHERE
+			id = $1 AND owner = $2
+		RETURNING
+			id, owner, name, corporation, alliance, share_token, created, updated
+	`, append([]interface{}{id, c.ID}, args...)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("unknown doctrine list")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "update doctrine list")
+	}
+	row.OwnerName = c.Name
+	return row.list(c.ID), nil
+}
+
+// viewDoctrineList returns list id if the logged in character may view it,
+// or token is its share token.
+func (s *EFContext) viewDoctrineList(ctx context.Context, id int64, token string) (*doctrineListRow, error) {
+	var row doctrineListRow
+	err := s.X.GetContext(ctx, &row, doctrineListSelect+` WHERE dl.id = $1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("unknown doctrine list")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "doctrine list")
+	}
+	if token != "" && row.ShareToken.Valid && subtle.ConstantTimeCompare([]byte(token), []byte(row.ShareToken.String)) == 1 {
+		return &row, nil
+	}
+	c := characterFromContext(ctx)
+	if c == nil {
+		// Lists not viewable aren't distinguished from missing ones.
+		return nil, notFound("unknown doctrine list")
+	}
+	if c.ID == row.Owner {
+		return &row, nil
+	}
+	corp, alliance, err := s.characterAffiliation(ctx, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	if corp != row.Corporation && (!row.Alliance.Valid || alliance != row.Alliance.Int32) {
+		return nil, notFound("unknown doctrine list")
+	}
+	return &row, nil
+}
+
+// doctrineList returns list id with its fits in the order they were added.
+// Fits that are being reprocessed are left out.
+func (s *EFContext) doctrineList(ctx context.Context, id int64, token string) (*DoctrineList, error) {
+	row, err := s.viewDoctrineList(ctx, id, token)
+	if err != nil {
+		return nil, err
+	}
+	var viewer int32
+	if c := characterFromContext(ctx); c != nil {
+		viewer = c.ID
+	}
+	ret := row.list(viewer)
+
+	var entries []struct {
+		ID          int64
+		Killmail    sql.NullInt32
+		Theoretical sql.NullInt64
+		Note        string
+		Added       time.Time
+	}
+	if err := s.X.SelectContext(ctx, &entries, `
+		SELECT
+			id, killmail, theoretical, note, added
+		FROM
+			doctrine_list_fits
+		WHERE
+			list = $1
+		ORDER BY
+			added, id
+	`, id); err != nil {
+		return nil, errors.Wrap(err, "doctrine list fits")
+	}
+	var killmails []int32
+	var theoreticals []int64
+	for _, e := range entries {
+		if e.Killmail.Valid {
+			killmails = append(killmails, e.Killmail.Int32)
+		} else {
+			theoreticals = append(theoreticals, e.Theoretical.Int64)
+		}
+	}
+	g := s.Global()
+	fits := map[int32]*FitSummary{}
+	if len(killmails) > 0 {
+		var rows []fitRow
+		if err := s.read(ctx).SelectContext(ctx, &rows, `
+			SELECT
+				f.killmail, f.ship, f.cost, f.dropped, f.points, COALESCE(fs.score, 0) AS score, f.hi, f.med, f.low
+			FROM
+				fits AS f LEFT JOIN fit_scores AS fs ON fs.killmail = f.killmail
+			WHERE
+				f.killmail = ANY ($1)
+		`, killmails); err != nil {
+			return nil, errors.Wrap(err, "doctrine list fits")
+		}
+		for _, row := range rows {
+			fits[int32(row.Killmail)] = g.fitSummary(row)
+		}
+	}
+	theoretical := map[int64]*TheoreticalFit{}
+	if len(theoreticals) > 0 {
+		var rows []theoreticalRow
+		if err := s.read(ctx).SelectContext(ctx, &rows, theoreticalSelect+` WHERE tf.id = ANY ($1)`, theoreticals); err != nil {
+			return nil, errors.Wrap(err, "doctrine list theoretical fits")
+		}
+		for _, row := range rows {
+			theoretical[row.ID] = g.theoreticalFit(row)
+		}
+	}
+	ret.Fits = []*DoctrineListFit{}
+	for _, e := range entries {
+		f := &DoctrineListFit{ID: e.ID, Note: e.Note, Added: e.Added}
+		if e.Killmail.Valid {
+			if f.Fit = fits[e.Killmail.Int32]; f.Fit == nil {
+				continue
+			}
+		} else {
+			f.Theoretical = theoretical[e.Theoretical.Int64]
+		}
+		ret.Fits = append(ret.Fits, f)
+	}
+	return ret, nil
+}
+
+// DoctrineListFits adds (POST) a killmail or theoretical fit to the
+// doctrine list given by list, or removes (DELETE) the entry given by id.
+// Only the list's owner may change it.
+func (s *EFContext) DoctrineListFits(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	c, err := requireCharacter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Method {
+	case http.MethodPost:
+		list, err := strconv.ParseInt(r.FormValue("list"), 10, 64)
+		if err != nil || list <= 0 {
+			return nil, badRequest("list required")
+		}
+		note := strings.TrimSpace(r.FormValue("note"))
+		if !utf8.ValidString(note) {
+			return nil, badRequest("invalid note")
+		}
+		if n := utf8.RuneCountInString(note); n > maxDoctrineNoteLength {
+			return nil, badRequest("note is %d characters, at most %d allowed", n, maxDoctrineNoteLength)
+		}
+		var killmail, theoretical interface{}
+		var exists bool
+		switch km, tf := r.FormValue("killmail"), r.FormValue("theoretical"); {
+		case km != "" && tf == "":
+			id, err := strconv.Atoi(km)
+			if err != nil || id <= 0 {
+				return nil, badRequest("invalid killmail")
+			}
+			killmail = id
+			err = s.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM fits WHERE killmail = $1)`, id).Scan(&exists)
+			if err != nil {
+				return nil, err
+			}
+		case tf != "" && km == "":
+			id, err := strconv.ParseInt(tf, 10, 64)
+			if err != nil || id <= 0 {
+				return nil, badRequest("invalid theoretical")
+			}
+			theoretical = id
+			err = s.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM theoretical_fits WHERE id = $1)`, id).Scan(&exists)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, badRequest("one of killmail or theoretical required")
+		}
+		if !exists {
+			return nil, notFound("unknown fit")
+		}
+		var owned bool
+		var n int
+		if err := s.DB.QueryRowContext(ctx, `
+			SELECT
+				EXISTS (SELECT 1 FROM doctrine_lists WHERE id = $1 AND owner = $2),
+				(SELECT count(*) FROM doctrine_list_fits WHERE list = $1)
+		`, list, c.ID).Scan(&owned, &n); err != nil {
+			return nil, errors.Wrap(err, "doctrine list")
+		}
+		if !owned {
+			return nil, notFound("unknown doctrine list")
+		}
+		if n >= maxDoctrineListFits {
+			return nil, badRequest("at most %d fits may be added to a doctrine list", maxDoctrineListFits)
+		}
+		ret := &DoctrineListFit{Note: note}
+		if err := s.DB.QueryRowContext(ctx, `
+			INSERT
+			INTO
+				doctrine_list_fits (list, killmail, theoretical, note)
+			VALUES
+				($1, $2, $3, $4)
+			RETURNING
+				id, added
+		`, list, killmail, theoretical, note).Scan(&ret.ID, &ret.Added); err != nil {
+			return nil, errors.Wrap(err, "add doctrine list fit")
+		}
+		if _, err := s.DB.ExecContext(ctx, `UPDATE doctrine_lists SET updated = now() WHERE id = $1`, list); err != nil {
+			return nil, errors.Wrap(err, "update doctrine list")
+		}
+		return ret, nil
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, badRequest("id required")
+		}
+		res, err := s.DB.ExecContext(ctx, `
+			DELETE FROM
+				doctrine_list_fits
+			WHERE
+				id = $1
+				AND list IN (SELECT id FROM doctrine_lists WHERE owner = $2)
+		`, id, c.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "remove doctrine list fit")
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, notFound("unknown doctrine list fit")
+		}
+		return nil, nil
+	}
+	return nil, methodNotAllowed(r.Method)
+}
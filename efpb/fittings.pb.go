@@ -0,0 +1,716 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.32.0
+// 	protoc        (unknown)
+// source: fittings.proto
+
+package efpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Item struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id    int32  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name  string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Group int32  `protobuf:"varint,3,opt,name=group,proto3" json:"group,omitempty"`
+}
+
+func (x *Item) Reset() {
+	*x = Item{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fittings_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Item) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Item) ProtoMessage() {}
+
+func (x *Item) ProtoReflect() protoreflect.Message {
+	mi := &file_fittings_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Item.ProtoReflect.Descriptor instead.
+func (*Item) Descriptor() ([]byte, []int) {
+	return file_fittings_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Item) GetId() int32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Item) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Item) GetGroup() int32 {
+	if x != nil {
+		return x.Group
+	}
+	return 0
+}
+
+type ItemCharge struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Item   *Item `protobuf:"bytes,1,opt,name=item,proto3" json:"item,omitempty"`
+	Charge *Item `protobuf:"bytes,2,opt,name=charge,proto3" json:"charge,omitempty"`
+}
+
+func (x *ItemCharge) Reset() {
+	*x = ItemCharge{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fittings_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ItemCharge) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ItemCharge) ProtoMessage() {}
+
+func (x *ItemCharge) ProtoReflect() protoreflect.Message {
+	mi := &file_fittings_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ItemCharge.ProtoReflect.Descriptor instead.
+func (*ItemCharge) Descriptor() ([]byte, []int) {
+	return file_fittings_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ItemCharge) GetItem() *Item {
+	if x != nil {
+		return x.Item
+	}
+	return nil
+}
+
+func (x *ItemCharge) GetCharge() *Item {
+	if x != nil {
+		return x.Charge
+	}
+	return nil
+}
+
+type Fit struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Killmail int32         `protobuf:"varint,1,opt,name=killmail,proto3" json:"killmail,omitempty"`
+	Ship     *Item         `protobuf:"bytes,2,opt,name=ship,proto3" json:"ship,omitempty"`
+	Cost     int64         `protobuf:"varint,3,opt,name=cost,proto3" json:"cost,omitempty"`
+	Hi       []*ItemCharge `protobuf:"bytes,4,rep,name=hi,proto3" json:"hi,omitempty"`
+	Med      []*ItemCharge `protobuf:"bytes,5,rep,name=med,proto3" json:"med,omitempty"`
+	Low      []*ItemCharge `protobuf:"bytes,6,rep,name=low,proto3" json:"low,omitempty"`
+	Rig      []*ItemCharge `protobuf:"bytes,7,rep,name=rig,proto3" json:"rig,omitempty"`
+	Sub      []*ItemCharge `protobuf:"bytes,8,rep,name=sub,proto3" json:"sub,omitempty"`
+}
+
+func (x *Fit) Reset() {
+	*x = Fit{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fittings_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Fit) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Fit) ProtoMessage() {}
+
+func (x *Fit) ProtoReflect() protoreflect.Message {
+	mi := &file_fittings_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Fit.ProtoReflect.Descriptor instead.
+func (*Fit) Descriptor() ([]byte, []int) {
+	return file_fittings_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Fit) GetKillmail() int32 {
+	if x != nil {
+		return x.Killmail
+	}
+	return 0
+}
+
+func (x *Fit) GetShip() *Item {
+	if x != nil {
+		return x.Ship
+	}
+	return nil
+}
+
+func (x *Fit) GetCost() int64 {
+	if x != nil {
+		return x.Cost
+	}
+	return 0
+}
+
+func (x *Fit) GetHi() []*ItemCharge {
+	if x != nil {
+		return x.Hi
+	}
+	return nil
+}
+
+func (x *Fit) GetMed() []*ItemCharge {
+	if x != nil {
+		return x.Med
+	}
+	return nil
+}
+
+func (x *Fit) GetLow() []*ItemCharge {
+	if x != nil {
+		return x.Low
+	}
+	return nil
+}
+
+func (x *Fit) GetRig() []*ItemCharge {
+	if x != nil {
+		return x.Rig
+	}
+	return nil
+}
+
+func (x *Fit) GetSub() []*ItemCharge {
+	if x != nil {
+		return x.Sub
+	}
+	return nil
+}
+
+type Killmail struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id int32 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// ESI killmail JSON.
+	Km []byte `protobuf:"bytes,2,opt,name=km,proto3" json:"km,omitempty"`
+	// zKillboard metadata JSON.
+	Zkb []byte `protobuf:"bytes,3,opt,name=zkb,proto3" json:"zkb,omitempty"`
+}
+
+func (x *Killmail) Reset() {
+	*x = Killmail{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fittings_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Killmail) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Killmail) ProtoMessage() {}
+
+func (x *Killmail) ProtoReflect() protoreflect.Message {
+	mi := &file_fittings_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Killmail.ProtoReflect.Descriptor instead.
+func (*Killmail) Descriptor() ([]byte, []int) {
+	return file_fittings_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Killmail) GetId() int32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Killmail) GetKm() []byte {
+	if x != nil {
+		return x.Km
+	}
+	return nil
+}
+
+func (x *Killmail) GetZkb() []byte {
+	if x != nil {
+		return x.Zkb
+	}
+	return nil
+}
+
+type GetFitRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Killmail int32 `protobuf:"varint,1,opt,name=killmail,proto3" json:"killmail,omitempty"`
+}
+
+func (x *GetFitRequest) Reset() {
+	*x = GetFitRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fittings_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetFitRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetFitRequest) ProtoMessage() {}
+
+func (x *GetFitRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fittings_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetFitRequest.ProtoReflect.Descriptor instead.
+func (*GetFitRequest) Descriptor() ([]byte, []int) {
+	return file_fittings_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetFitRequest) GetKillmail() int32 {
+	if x != nil {
+		return x.Killmail
+	}
+	return 0
+}
+
+type ListFitsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Ship   int32   `protobuf:"varint,1,opt,name=ship,proto3" json:"ship,omitempty"`
+	Items  []int32 `protobuf:"varint,2,rep,packed,name=items,proto3" json:"items,omitempty"`
+	Groups []int32 `protobuf:"varint,3,rep,packed,name=groups,proto3" json:"groups,omitempty"`
+	// Only return fits with killmail IDs less than before, if set.
+	Before int32 `protobuf:"varint,4,opt,name=before,proto3" json:"before,omitempty"`
+	// Maximum number of fits to return, or all if 0.
+	Limit int32 `protobuf:"varint,5,opt,name=limit,proto3" json:"limit,omitempty"`
+}
+
+func (x *ListFitsRequest) Reset() {
+	*x = ListFitsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fittings_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListFitsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListFitsRequest) ProtoMessage() {}
+
+func (x *ListFitsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fittings_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListFitsRequest.ProtoReflect.Descriptor instead.
+func (*ListFitsRequest) Descriptor() ([]byte, []int) {
+	return file_fittings_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListFitsRequest) GetShip() int32 {
+	if x != nil {
+		return x.Ship
+	}
+	return 0
+}
+
+func (x *ListFitsRequest) GetItems() []int32 {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+func (x *ListFitsRequest) GetGroups() []int32 {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+func (x *ListFitsRequest) GetBefore() int32 {
+	if x != nil {
+		return x.Before
+	}
+	return 0
+}
+
+func (x *ListFitsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ListKillmailsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Only return killmails with IDs less than before, if set.
+	Before int32 `protobuf:"varint,1,opt,name=before,proto3" json:"before,omitempty"`
+	// Maximum number of killmails to return, or all if 0.
+	Limit int32 `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+}
+
+func (x *ListKillmailsRequest) Reset() {
+	*x = ListKillmailsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fittings_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListKillmailsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListKillmailsRequest) ProtoMessage() {}
+
+func (x *ListKillmailsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fittings_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListKillmailsRequest.ProtoReflect.Descriptor instead.
+func (*ListKillmailsRequest) Descriptor() ([]byte, []int) {
+	return file_fittings_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListKillmailsRequest) GetBefore() int32 {
+	if x != nil {
+		return x.Before
+	}
+	return 0
+}
+
+func (x *ListKillmailsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+var File_fittings_proto protoreflect.FileDescriptor
+
+var file_fittings_proto_rawDesc = []byte{
+	0x0a, 0x0e, 0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x12, 0x08, 0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x22, 0x40, 0x0a, 0x04, 0x49, 0x74,
+	0x65, 0x6d, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x02,
+	0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x22, 0x58, 0x0a, 0x0a,
+	0x49, 0x74, 0x65, 0x6d, 0x43, 0x68, 0x61, 0x72, 0x67, 0x65, 0x12, 0x22, 0x0a, 0x04, 0x69, 0x74,
+	0x65, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x66, 0x69, 0x74, 0x74, 0x69,
+	0x6e, 0x67, 0x73, 0x2e, 0x49, 0x74, 0x65, 0x6d, 0x52, 0x04, 0x69, 0x74, 0x65, 0x6d, 0x12, 0x26,
+	0x0a, 0x06, 0x63, 0x68, 0x61, 0x72, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e,
+	0x2e, 0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x2e, 0x49, 0x74, 0x65, 0x6d, 0x52, 0x06,
+	0x63, 0x68, 0x61, 0x72, 0x67, 0x65, 0x22, 0x9f, 0x02, 0x0a, 0x03, 0x46, 0x69, 0x74, 0x12, 0x1a,
+	0x0a, 0x08, 0x6b, 0x69, 0x6c, 0x6c, 0x6d, 0x61, 0x69, 0x6c, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x08, 0x6b, 0x69, 0x6c, 0x6c, 0x6d, 0x61, 0x69, 0x6c, 0x12, 0x22, 0x0a, 0x04, 0x73, 0x68,
+	0x69, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x66, 0x69, 0x74, 0x74, 0x69,
+	0x6e, 0x67, 0x73, 0x2e, 0x49, 0x74, 0x65, 0x6d, 0x52, 0x04, 0x73, 0x68, 0x69, 0x70, 0x12, 0x12,
+	0x0a, 0x04, 0x63, 0x6f, 0x73, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x63, 0x6f,
+	0x73, 0x74, 0x12, 0x24, 0x0a, 0x02, 0x68, 0x69, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14,
+	0x2e, 0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x2e, 0x49, 0x74, 0x65, 0x6d, 0x43, 0x68,
+	0x61, 0x72, 0x67, 0x65, 0x52, 0x02, 0x68, 0x69, 0x12, 0x26, 0x0a, 0x03, 0x6d, 0x65, 0x64, 0x18,
+	0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73,
+	0x2e, 0x49, 0x74, 0x65, 0x6d, 0x43, 0x68, 0x61, 0x72, 0x67, 0x65, 0x52, 0x03, 0x6d, 0x65, 0x64,
+	0x12, 0x26, 0x0a, 0x03, 0x6c, 0x6f, 0x77, 0x18, 0x06, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e,
+	0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x2e, 0x49, 0x74, 0x65, 0x6d, 0x43, 0x68, 0x61,
+	0x72, 0x67, 0x65, 0x52, 0x03, 0x6c, 0x6f, 0x77, 0x12, 0x26, 0x0a, 0x03, 0x72, 0x69, 0x67, 0x18,
+	0x07, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73,
+	0x2e, 0x49, 0x74, 0x65, 0x6d, 0x43, 0x68, 0x61, 0x72, 0x67, 0x65, 0x52, 0x03, 0x72, 0x69, 0x67,
+	0x12, 0x26, 0x0a, 0x03, 0x73, 0x75, 0x62, 0x18, 0x08, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e,
+	0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x2e, 0x49, 0x74, 0x65, 0x6d, 0x43, 0x68, 0x61,
+	0x72, 0x67, 0x65, 0x52, 0x03, 0x73, 0x75, 0x62, 0x22, 0x3c, 0x0a, 0x08, 0x4b, 0x69, 0x6c, 0x6c,
+	0x6d, 0x61, 0x69, 0x6c, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x02, 0x69, 0x64, 0x12, 0x0e, 0x0a, 0x02, 0x6b, 0x6d, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x02, 0x6b, 0x6d, 0x12, 0x10, 0x0a, 0x03, 0x7a, 0x6b, 0x62, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x03, 0x7a, 0x6b, 0x62, 0x22, 0x2b, 0x0a, 0x0d, 0x47, 0x65, 0x74, 0x46, 0x69, 0x74,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x6b, 0x69, 0x6c, 0x6c, 0x6d,
+	0x61, 0x69, 0x6c, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x6b, 0x69, 0x6c, 0x6c, 0x6d,
+	0x61, 0x69, 0x6c, 0x22, 0x81, 0x01, 0x0a, 0x0f, 0x4c, 0x69, 0x73, 0x74, 0x46, 0x69, 0x74, 0x73,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x68, 0x69, 0x70, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x73, 0x68, 0x69, 0x70, 0x12, 0x14, 0x0a, 0x05, 0x69,
+	0x74, 0x65, 0x6d, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x05, 0x52, 0x05, 0x69, 0x74, 0x65, 0x6d,
+	0x73, 0x12, 0x16, 0x0a, 0x06, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28,
+	0x05, 0x52, 0x06, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x62, 0x65, 0x66,
+	0x6f, 0x72, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x62, 0x65, 0x66, 0x6f, 0x72,
+	0x65, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x22, 0x44, 0x0a, 0x14, 0x4c, 0x69, 0x73, 0x74, 0x4b,
+	0x69, 0x6c, 0x6c, 0x6d, 0x61, 0x69, 0x6c, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x16, 0x0a, 0x06, 0x62, 0x65, 0x66, 0x6f, 0x72, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x06, 0x62, 0x65, 0x66, 0x6f, 0x72, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x32, 0xbb, 0x01,
+	0x0a, 0x08, 0x46, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x12, 0x30, 0x0a, 0x06, 0x47, 0x65,
+	0x74, 0x46, 0x69, 0x74, 0x12, 0x17, 0x2e, 0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x2e,
+	0x47, 0x65, 0x74, 0x46, 0x69, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0d, 0x2e,
+	0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x2e, 0x46, 0x69, 0x74, 0x12, 0x36, 0x0a, 0x08,
+	0x4c, 0x69, 0x73, 0x74, 0x46, 0x69, 0x74, 0x73, 0x12, 0x19, 0x2e, 0x66, 0x69, 0x74, 0x74, 0x69,
+	0x6e, 0x67, 0x73, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x46, 0x69, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x0d, 0x2e, 0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x2e, 0x46,
+	0x69, 0x74, 0x30, 0x01, 0x12, 0x45, 0x0a, 0x0d, 0x4c, 0x69, 0x73, 0x74, 0x4b, 0x69, 0x6c, 0x6c,
+	0x6d, 0x61, 0x69, 0x6c, 0x73, 0x12, 0x1e, 0x2e, 0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73,
+	0x2e, 0x4c, 0x69, 0x73, 0x74, 0x4b, 0x69, 0x6c, 0x6c, 0x6d, 0x61, 0x69, 0x6c, 0x73, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x12, 0x2e, 0x66, 0x69, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73,
+	0x2e, 0x4b, 0x69, 0x6c, 0x6c, 0x6d, 0x61, 0x69, 0x6c, 0x30, 0x01, 0x42, 0x1c, 0x5a, 0x1a, 0x67,
+	0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6d, 0x6a, 0x69, 0x62, 0x73, 0x6f,
+	0x6e, 0x2f, 0x65, 0x66, 0x2f, 0x65, 0x66, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x33,
+}
+
+var (
+	file_fittings_proto_rawDescOnce sync.Once
+	file_fittings_proto_rawDescData = file_fittings_proto_rawDesc
+)
+
+func file_fittings_proto_rawDescGZIP() []byte {
+	file_fittings_proto_rawDescOnce.Do(func() {
+		file_fittings_proto_rawDescData = protoimpl.X.CompressGZIP(file_fittings_proto_rawDescData)
+	})
+	return file_fittings_proto_rawDescData
+}
+
+var file_fittings_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_fittings_proto_goTypes = []interface{}{
+	(*Item)(nil),                 // 0: fittings.Item
+	(*ItemCharge)(nil),           // 1: fittings.ItemCharge
+	(*Fit)(nil),                  // 2: fittings.Fit
+	(*Killmail)(nil),             // 3: fittings.Killmail
+	(*GetFitRequest)(nil),        // 4: fittings.GetFitRequest
+	(*ListFitsRequest)(nil),      // 5: fittings.ListFitsRequest
+	(*ListKillmailsRequest)(nil), // 6: fittings.ListKillmailsRequest
+}
+var file_fittings_proto_depIdxs = []int32{
+	0,  // 0: fittings.ItemCharge.item:type_name -> fittings.Item
+	0,  // 1: fittings.ItemCharge.charge:type_name -> fittings.Item
+	0,  // 2: fittings.Fit.ship:type_name -> fittings.Item
+	1,  // 3: fittings.Fit.hi:type_name -> fittings.ItemCharge
+	1,  // 4: fittings.Fit.med:type_name -> fittings.ItemCharge
+	1,  // 5: fittings.Fit.low:type_name -> fittings.ItemCharge
+	1,  // 6: fittings.Fit.rig:type_name -> fittings.ItemCharge
+	1,  // 7: fittings.Fit.sub:type_name -> fittings.ItemCharge
+	4,  // 8: fittings.Fittings.GetFit:input_type -> fittings.GetFitRequest
+	5,  // 9: fittings.Fittings.ListFits:input_type -> fittings.ListFitsRequest
+	6,  // 10: fittings.Fittings.ListKillmails:input_type -> fittings.ListKillmailsRequest
+	2,  // 11: fittings.Fittings.GetFit:output_type -> fittings.Fit
+	2,  // 12: fittings.Fittings.ListFits:output_type -> fittings.Fit
+	3,  // 13: fittings.Fittings.ListKillmails:output_type -> fittings.Killmail
+	11, // [11:14] is the sub-list for method output_type
+	8,  // [8:11] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_fittings_proto_init() }
+func file_fittings_proto_init() {
+	if File_fittings_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_fittings_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Item); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fittings_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ItemCharge); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fittings_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Fit); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fittings_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Killmail); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fittings_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetFitRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fittings_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListFitsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fittings_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListKillmailsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_fittings_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_fittings_proto_goTypes,
+		DependencyIndexes: file_fittings_proto_depIdxs,
+		MessageInfos:      file_fittings_proto_msgTypes,
+	}.Build()
+	File_fittings_proto = out.File
+	file_fittings_proto_rawDesc = nil
+	file_fittings_proto_goTypes = nil
+	file_fittings_proto_depIdxs = nil
+}
@@ -0,0 +1,238 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: fittings.proto
+
+package efpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Fittings_GetFit_FullMethodName        = "/fittings.Fittings/GetFit"
+	Fittings_ListFits_FullMethodName      = "/fittings.Fittings/ListFits"
+	Fittings_ListKillmails_FullMethodName = "/fittings.Fittings/ListKillmails"
+)
+
+// FittingsClient is the client API for Fittings service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FittingsClient interface {
+	GetFit(ctx context.Context, in *GetFitRequest, opts ...grpc.CallOption) (*Fit, error)
+	ListFits(ctx context.Context, in *ListFitsRequest, opts ...grpc.CallOption) (Fittings_ListFitsClient, error)
+	ListKillmails(ctx context.Context, in *ListKillmailsRequest, opts ...grpc.CallOption) (Fittings_ListKillmailsClient, error)
+}
+
+type fittingsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFittingsClient(cc grpc.ClientConnInterface) FittingsClient {
+	return &fittingsClient{cc}
+}
+
+func (c *fittingsClient) GetFit(ctx context.Context, in *GetFitRequest, opts ...grpc.CallOption) (*Fit, error) {
+	out := new(Fit)
+	err := c.cc.Invoke(ctx, Fittings_GetFit_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fittingsClient) ListFits(ctx context.Context, in *ListFitsRequest, opts ...grpc.CallOption) (Fittings_ListFitsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Fittings_ServiceDesc.Streams[0], Fittings_ListFits_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fittingsListFitsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Fittings_ListFitsClient interface {
+	Recv() (*Fit, error)
+	grpc.ClientStream
+}
+
+type fittingsListFitsClient struct {
+	grpc.ClientStream
+}
+
+func (x *fittingsListFitsClient) Recv() (*Fit, error) {
+	m := new(Fit)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fittingsClient) ListKillmails(ctx context.Context, in *ListKillmailsRequest, opts ...grpc.CallOption) (Fittings_ListKillmailsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Fittings_ServiceDesc.Streams[1], Fittings_ListKillmails_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fittingsListKillmailsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Fittings_ListKillmailsClient interface {
+	Recv() (*Killmail, error)
+	grpc.ClientStream
+}
+
+type fittingsListKillmailsClient struct {
+	grpc.ClientStream
+}
+
+func (x *fittingsListKillmailsClient) Recv() (*Killmail, error) {
+	m := new(Killmail)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FittingsServer is the server API for Fittings service.
+// All implementations must embed UnimplementedFittingsServer
+// for forward compatibility
+type FittingsServer interface {
+	GetFit(context.Context, *GetFitRequest) (*Fit, error)
+	ListFits(*ListFitsRequest, Fittings_ListFitsServer) error
+	ListKillmails(*ListKillmailsRequest, Fittings_ListKillmailsServer) error
+	mustEmbedUnimplementedFittingsServer()
+}
+
+// UnimplementedFittingsServer must be embedded to have forward compatible implementations.
+type UnimplementedFittingsServer struct {
+}
+
+func (UnimplementedFittingsServer) GetFit(context.Context, *GetFitRequest) (*Fit, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFit not implemented")
+}
+func (UnimplementedFittingsServer) ListFits(*ListFitsRequest, Fittings_ListFitsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListFits not implemented")
+}
+func (UnimplementedFittingsServer) ListKillmails(*ListKillmailsRequest, Fittings_ListKillmailsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListKillmails not implemented")
+}
+func (UnimplementedFittingsServer) mustEmbedUnimplementedFittingsServer() {}
+
+// UnsafeFittingsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FittingsServer will
+// result in compilation errors.
+type UnsafeFittingsServer interface {
+	mustEmbedUnimplementedFittingsServer()
+}
+
+func RegisterFittingsServer(s grpc.ServiceRegistrar, srv FittingsServer) {
+	s.RegisterService(&Fittings_ServiceDesc, srv)
+}
+
+func _Fittings_GetFit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FittingsServer).GetFit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fittings_GetFit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FittingsServer).GetFit(ctx, req.(*GetFitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fittings_ListFits_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListFitsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FittingsServer).ListFits(m, &fittingsListFitsServer{stream})
+}
+
+type Fittings_ListFitsServer interface {
+	Send(*Fit) error
+	grpc.ServerStream
+}
+
+type fittingsListFitsServer struct {
+	grpc.ServerStream
+}
+
+func (x *fittingsListFitsServer) Send(m *Fit) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Fittings_ListKillmails_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListKillmailsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FittingsServer).ListKillmails(m, &fittingsListKillmailsServer{stream})
+}
+
+type Fittings_ListKillmailsServer interface {
+	Send(*Killmail) error
+	grpc.ServerStream
+}
+
+type fittingsListKillmailsServer struct {
+	grpc.ServerStream
+}
+
+func (x *fittingsListKillmailsServer) Send(m *Killmail) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Fittings_ServiceDesc is the grpc.ServiceDesc for Fittings service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Fittings_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fittings.Fittings",
+	HandlerType: (*FittingsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetFit",
+			Handler:    _Fittings_GetFit_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListFits",
+			Handler:       _Fittings_ListFits_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListKillmails",
+			Handler:       _Fittings_ListKillmails_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "fittings.proto",
+}
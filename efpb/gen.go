@@ -0,0 +1,5 @@
+// Package efpb contains the protobuf messages and gRPC service definitions
+// for the bulk fits API.
+package efpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative fittings.proto
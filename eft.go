@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// EFT returns the fit in EFT format: a header with the ship name, then
+// sections of low, medium, high, rig, subsystem, and (for structures)
+// service modules, each followed by its charge if loaded. Empty slots are
+// omitted.
+func (f *FitDetail) EFT() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[%s, fittin.gs %d]\n", f.Ship.Name, f.Killmail)
+	for _, slots := range [][8]ItemCharge{f.Low, f.Med, f.Hi, f.Rig, f.Sub, f.Service} {
+		sb.WriteString("\n")
+		for _, ic := range slots {
+			if ic.ID == 0 {
+				continue
+			}
+			sb.WriteString(ic.Name)
+			if ic.Charge != nil {
+				fmt.Fprintf(&sb, ", %s", ic.Charge.Name)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// eftEmptySlots are EFT's empty slot markers and the racks they are in.
+var eftEmptySlots = map[string]string{
+	"[empty low slot]":       "low",
+	"[empty med slot]":       "med",
+	"[empty high slot]":      "hi",
+	"[empty rig slot]":       "rig",
+	"[empty subsystem slot]": "sub",
+}
+
+// eftQuantity matches the quantity suffix of drone and cargo lines.
+var eftQuantity = regexp.MustCompile(` x\d+$`)
+
+// parseEFT parses a ship fit in EFT format, as written by EFT: a [ship,
+// name] header, then sections of low, medium, high, rig, and subsystem
+// modules separated by blank lines, each module optionally followed by a
+// comma and its charge. Rigs and subsystems are recognized by their groups
+// and empty slot markers by name, so sections may be omitted. Offline
+// suffixes are ignored, as are drone and cargo sections, whose lines have
+// quantities.
+func (g *staticData) parseEFT(text string) (ship Item, name string, racks Racks, err error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return ship, "", racks, badRequest("empty fit")
+	}
+	header := strings.TrimSpace(lines[0])
+	if !strings.HasPrefix(header, "[") || !strings.HasSuffix(header, "]") {
+		return ship, "", racks, badRequest("line 1: expected [ship, name]")
+	}
+	shipName, name, _ := strings.Cut(header[1:len(header)-1], ",")
+	name = strings.TrimSpace(name)
+	ship = g.Items[g.itemNames[strings.ToLower(strings.TrimSpace(shipName))]]
+	if ship.ID == 0 || !g.Groups[ship.Group].IsShip() {
+		return ship, "", racks, badRequest("line 1: unknown ship %q", strings.TrimSpace(shipName))
+	}
+
+	type entry struct {
+		line   int
+		module Item
+		charge *Item
+	}
+	var sections [][]entry
+	var section []entry
+	// explicit are the racks of sections given by empty slot markers.
+	explicit := map[int]string{}
+	skip := false
+	for i, line := range lines[1:] {
+		lineNo := i + 2
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if len(section) > 0 || explicit[len(sections)] != "" {
+				sections = append(sections, section)
+			}
+			section, skip = nil, false
+			continue
+		}
+		if skip {
+			continue
+		}
+		if eftQuantity.MatchString(line) {
+			// Drones and cargo aren't fitted.
+			section, skip = nil, true
+			delete(explicit, len(sections))
+			continue
+		}
+		if rack, ok := eftEmptySlots[strings.ToLower(line)]; ok {
+			explicit[len(sections)] = rack
+			continue
+		}
+		line = strings.TrimSuffix(line, " /OFFLINE")
+		moduleName, chargeName, _ := strings.Cut(line, ",")
+		e := entry{line: lineNo, module: g.Items[g.itemNames[strings.ToLower(strings.TrimSpace(moduleName))]]}
+		if grp := g.Groups[e.module.Group]; e.module.ID == 0 || !(grp.IsModule() || grp.IsSubsystem()) {
+			return ship, "", racks, badRequest("line %d: unknown module %q", lineNo, strings.TrimSpace(moduleName))
+		}
+		if chargeName = strings.TrimSpace(chargeName); chargeName != "" {
+			charge := g.Items[g.itemNames[strings.ToLower(chargeName)]]
+			if charge.ID == 0 || !g.Groups[charge.Group].IsCharge() {
+				return ship, "", racks, badRequest("line %d: unknown charge %q", lineNo, chargeName)
+			}
+			e.charge = &charge
+		}
+		section = append(section, e)
+	}
+	if len(section) > 0 {
+		sections = append(sections, section)
+	}
+
+	// Sections without markers, rigs, or subsystems are low, medium, and
+	// high slots in turn.
+	order := []string{"low", "med", "hi"}
+	named := map[string]*[8]ItemCharge{"low": &racks.Low, "med": &racks.Med, "hi": &racks.Hi, "rig": &racks.Rig, "sub": &racks.Sub}
+	for i, section := range sections {
+		rack := explicit[i]
+		if rack == "" && len(section) > 0 {
+			switch grp := g.Groups[section[0].module.Group]; {
+			case grp.IsSubsystem():
+				rack = "sub"
+			case strings.HasPrefix(grp.Name, "Rig "):
+				rack = "rig"
+			}
+		}
+		if rack == "" {
+			if len(order) == 0 {
+				return ship, "", racks, badRequest("line %d: more module sections than racks", section[0].line)
+			}
+			rack = order[0]
+		}
+		for j, o := range order {
+			if o == rack {
+				order = order[j+1:]
+				break
+			}
+		}
+		slots := named[rack]
+		n := 0
+		for n < len(slots) && slots[n].ID != 0 {
+			n++
+		}
+		for _, e := range section {
+			if n == len(slots) {
+				return ship, "", racks, badRequest("line %d: more than %d modules in a rack", e.line, len(slots))
+			}
+			slots[n] = ItemCharge{Item: e.module, Charge: e.charge}
+			n++
+		}
+	}
+	return ship, name, racks, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+)
+
+const (
+	// entityLossesWindow is the period over which the hulls and ISK lost by
+	// a corporation or alliance are totaled.
+	entityLossesWindow = time.Hour * 24 * 30
+	// entityHullsLimit is the number of most lost hulls listed.
+	entityHullsLimit = 10
+)
+
+// EntityLosses are the losses of members of a corporation or alliance.
+type EntityLosses struct {
+	// Entity is the corporation's or alliance's ID and name.
+	Entity Item
+	// Days is the period ISKLost and Hulls are totaled over.
+	Days int
+	// ISKLost is the total cost of the fits lost.
+	ISKLost int64
+	// Hulls are the most lost hulls, most losses first.
+	Hulls []HullLosses
+	// Losses are the fits of the latest losses, newest first.
+	Losses []*FitSummary
+}
+
+// HullLosses is the number and cost of losses of a hull.
+type HullLosses struct {
+	Ship    Item
+	Losses  int
+	ISKLost int64
+}
+
+// Corporation returns the latest losses of members of the corporation given
+// by id, with the hulls and ISK lost over the last 30 days.
+func (s *EFContext) Corporation(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	return s.entityLosses(ctx, r, "corporation", timing)
+}
+
+// Alliance returns the latest losses of members of the alliance given by
+// id, with the hulls and ISK lost over the last 30 days.
+func (s *EFContext) Alliance(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	return s.entityLosses(ctx, r, "alliance", timing)
+}
+
+// entityLosses returns the losses of the entity of category, a column of
+// fits, given by the id parameter of r.
+func (s *EFContext) entityLosses(ctx context.Context, r *http.Request, category string, timing *servertiming.Header) (*EntityLosses, error) {
+	r.ParseForm()
+	v := &validator{form: r.Form}
+	v.maxCount("id", 1)
+	ids := v.id("id", nil)
+	limit := fitsLimit
+	if r.Form.Get("limit") != "" {
+		limit = v.intRange("limit", 1, fitsLimit)
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, badRequest("id required")
+	}
+	id := ids[0]
+	res, err := s.fits(ctx, url.Values{category: {strconv.Itoa(int(id))}}, limit, nil, timing)
+	if err != nil {
+		return nil, err
+	}
+	ret := &EntityLosses{
+		Entity: Item{ID: id},
+		Days:   int(entityLossesWindow.Hours() / 24),
+		Hulls:  []HullLosses{},
+		Losses: res.Fits,
+	}
+	if e := res.Filter[category]; len(e) == 1 {
+		ret.Entity = e[0]
+	}
+
+	hullsT := timing.NewMetric("hulls").Start()
+	var rows []struct {
+		Ship   int32
+		Losses int
+		Cost   int64
+	}
+	// category is a fixed column name, never user input.
+	err = s.read(ctx).SelectContext(ctx, &rows, `
+		SELECT
+			f.ship, count(*) AS losses, COALESCE(sum(f.cost), 0) AS cost
+		FROM
+			fits AS f JOIN killmails AS k ON k.id = f.killmail
+		WHERE
+			f.`+category+` = $1 AND k.killed > $2
+		GROUP BY
+			f.ship
+		ORDER BY
+			losses DESC, cost DESC
+	`, id, time.Now().Add(-entityLossesWindow).UTC())
+	hullsT.Stop()
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s hulls", category)
+	}
+	g := s.Global()
+	for i, row := range rows {
+		ret.ISKLost += row.Cost
+		if i < entityHullsLimit {
+			ret.Hulls = append(ret.Hulls, HullLosses{
+				Ship:    g.Items[row.Ship],
+				Losses:  row.Losses,
+				ISKLost: row.Cost,
+			})
+		}
+	}
+	return ret, nil
+}
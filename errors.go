@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// httpError is a handler error with the HTTP status and machine-readable
+// code to respond with. Its message is shown to clients.
+type httpError struct {
+	Status  int
+	Code    string
+	Message string
+	// Fields lists the invalid parameters of a bad request, if known.
+	Fields []FieldError
+}
+
+func (e *httpError) Error() string { return e.Message }
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{Status: http.StatusBadRequest, Code: "bad_request", Message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &httpError{Status: http.StatusNotFound, Code: "not_found", Message: fmt.Sprintf(format, args...)}
+}
+
+func methodNotAllowed(method string) error {
+	return &httpError{Status: http.StatusMethodNotAllowed, Code: "method_not_allowed", Message: fmt.Sprintf("unsupported method %s", method)}
+}
+
+// bodyError returns the error for a request body that failed to read or
+// decode with err.
+func bodyError(err error) error {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return &httpError{Status: http.StatusRequestEntityTooLarge, Code: "too_large", Message: fmt.Sprintf("request body larger than %d bytes", mbe.Limit)}
+	}
+	return badRequest("invalid request body: %v", err)
+}
+
+// timeoutError returns the error of a request whose ctx ended before its
+// handler finished: a timeout if its deadline passed, and unavailable if it
+// was canceled, such as by shutdown.
+func timeoutError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &httpError{Status: http.StatusGatewayTimeout, Code: "timeout", Message: "request timed out"}
+	}
+	return &httpError{Status: http.StatusServiceUnavailable, Code: "unavailable", Message: "request canceled"}
+}
+
+// errorResponse maps err to a response status and client-visible error.
+// Unrecognized errors are reported as internal without their message, which
+// may contain implementation details.
+func errorResponse(err error) (int, *APIError) {
+	var he *httpError
+	switch {
+	case errors.As(err, &he):
+		return he.Status, &APIError{Code: he.Code, Message: he.Message, Fields: he.Fields}
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound, &APIError{Code: "not_found", Message: "not found"}
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, &APIError{Code: "timeout", Message: "request timed out"}
+	}
+	return http.StatusInternalServerError, &APIError{Code: "internal", Message: "internal error"}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	esiBase = "https://esi.evetech.net/latest"
+	// esiErrorLimitMin is the remaining ESI error budget at which requests
+	// wait for the budget to reset. ESI blocks clients that exhaust it.
+	esiErrorLimitMin = 10
+	// statusErrorLimited is ESI's response status once the error budget is
+	// exhausted.
+	statusErrorLimited = 420
+)
+
+// esiErrorBudget tracks ESI's error limit as reported by the
+// X-ESI-Error-Limit headers of its responses.
+type esiErrorBudget struct {
+	sync.Mutex
+	remain int
+	reset  time.Time
+	// waits counts the times requests waited for the budget to reset.
+	waits int64
+}
+
+var esiErrors = esiErrorBudget{remain: -1}
+
+// wait blocks until the error budget is above esiErrorLimitMin or has
+// reset.
+func (b *esiErrorBudget) wait(ctx context.Context) error {
+	b.Lock()
+	remain, reset := b.remain, b.reset
+	b.Unlock()
+	if remain < 0 || remain > esiErrorLimitMin || time.Now().After(reset) {
+		return nil
+	}
+	b.Lock()
+	b.waits++
+	b.Unlock()
+	d := time.Until(reset)
+	slog.Warn("esi error limit low, waiting", "remain", remain, "wait", d)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// update records the error limit headers of resp.
+func (b *esiErrorBudget) update(resp *http.Response) {
+	remain, err := strconv.Atoi(resp.Header.Get("X-ESI-Error-Limit-Remain"))
+	if err != nil {
+		return
+	}
+	reset, _ := strconv.Atoi(resp.Header.Get("X-ESI-Error-Limit-Reset"))
+	b.Lock()
+	b.remain = remain
+	b.reset = time.Now().Add(time.Duration(reset) * time.Second)
+	b.Unlock()
+}
+
+// vars returns the state of the budget for debugVars. remain is -1 until an
+// ESI response has been seen.
+func (b *esiErrorBudget) vars() map[string]interface{} {
+	b.Lock()
+	defer b.Unlock()
+	reset := time.Until(b.reset).Seconds()
+	return map[string]interface{}{
+		"remain":        b.remain,
+		"reset_seconds": max(reset, 0),
+		"waits":         b.waits,
+	}
+}
+
+// esiDo sends req to ESI with retries, waiting before each attempt if the
+// error budget is nearly exhausted.
+func esiDo(req *http.Request) (*http.Response, error) {
+	return retryDo(req, func(req *http.Request) (*http.Response, error) {
+		if err := esiErrors.wait(req.Context()); err != nil {
+			return nil, err
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		esiErrors.update(resp)
+		return resp, nil
+	})
+}
+
+// esiStatusError is an unsuccessful ESI response.
+type esiStatusError struct {
+	what   string
+	status int
+	text   string
+}
+
+func (e *esiStatusError) Error() string { return e.what + ": " + e.text }
+
+// permanentESIError reports whether err is an ESI response that retrying
+// won't change, such as 404 for an unknown killmail or 422 for a wrong hash.
+func permanentESIError(err error) bool {
+	var se *esiStatusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	return se.status >= 400 && se.status < 500 && !throttledStatus(se.status)
+}
+
+// fetchESIKillmail returns the ESI JSON of killmail id with hash.
+func fetchESIKillmail(ctx context.Context, id int, hash string) ([]byte, error) {
+	u := fmt.Sprintf("%s/killmails/%d/%s/", esiBase, id, hash)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := esiDo(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &esiStatusError{what: fmt.Sprintf("killmail %d", id), status: resp.StatusCode, text: resp.Status}
+	}
+	b, err := io.ReadAll(resp.Body)
+	return b, errors.Wrapf(err, "killmail %d", id)
+}
+
+// esiAffiliation is a character's corporation and alliance.
+type esiAffiliation struct {
+	CharacterID   int32 `json:"character_id"`
+	CorporationID int32 `json:"corporation_id"`
+	AllianceID    int32 `json:"alliance_id"`
+}
+
+// fetchAffiliations returns the current corporations and alliances of
+// characters using ESI's /characters/affiliation/ endpoint.
+func fetchAffiliations(ctx context.Context, characters []int32) ([]esiAffiliation, error) {
+	body, err := json.Marshal(characters)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, esiBase+"/characters/affiliation/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := esiDo(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("character affiliation: %s", resp.Status)
+	}
+	var affs []esiAffiliation
+	if err := json.NewDecoder(resp.Body).Decode(&affs); err != nil {
+		return nil, errors.Wrap(err, "character affiliation")
+	}
+	return affs, nil
+}
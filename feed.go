@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+)
+
+const (
+	// siteURL is the frontend's URL, used for links to fits.
+	siteURL = "https://fittin.gs"
+	// apiURL is the API's URL, used for links to its proxied images.
+	apiURL = "https://fittings-5anqu7dwna-uc.a.run.app"
+	// feedEntries is the number of fits in a feed.
+	feedEntries = 25
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// Feed returns an Atom feed of the most recent fits matching the same
+// filters as Fits. Entry content is the fit in EFT format.
+func (s *EFContext) Feed(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	r.ParseForm()
+	if err := s.validateFitsForm(r.Form); err != nil {
+		return nil, err
+	}
+	fits, err := s.fits(ctx, r.Form, feedEntries, nil, timing)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(fits.Fits))
+	for i, f := range fits.Fits {
+		ids[i] = f.Killmail
+	}
+	var rows []struct {
+		ID      int32
+		KM, Zkb []byte
+	}
+	if err := s.read(ctx).SelectContext(ctx, &rows, `
+		SELECT
+			id, km, zkb
+		FROM
+			killmails
+		WHERE
+			id = ANY ($1)
+		ORDER BY
+			id DESC
+	`, ids); err != nil {
+		return nil, err
+	}
+
+	feed := atomFeed{
+		ID:      siteURL + "/?" + r.Form.Encode(),
+		Title:   "fittin.gs",
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Link:    atomLink{Href: siteURL + "/?" + r.Form.Encode()},
+	}
+	if ship := fits.Filter["ship"]; len(ship) > 0 {
+		feed.Title = ship[0].Name + " - fittin.gs"
+	}
+	for _, row := range rows {
+		f, err := s.fitDetail(row.ID, row.KM, row.Zkb)
+		if err != nil {
+			return nil, err
+		}
+		var km KM
+		json.Unmarshal(row.KM, &km)
+		link := fmt.Sprintf("%s/fit/%d", siteURL, f.Killmail)
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      link,
+			Title:   f.Ship.Name,
+			Updated: km.KillmailTime.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: link},
+			Content: atomContent{Type: "text", Body: f.EFT()},
+		})
+	}
+	data, err := xml.MarshalIndent(feed, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return rawResult{
+		contentType: "application/atom+xml",
+		data:        append([]byte(xml.Header), data...),
+	}, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// requestFields returns the field names in the comma-separated fields
+// parameter of r, or nil if unset.
+func requestFields(r *http.Request) []string {
+	v := r.FormValue("fields")
+	if v == "" {
+		return nil
+	}
+	var fields []string
+	for _, f := range strings.Split(v, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// selectFields returns a map of the named fields of v, a struct or pointer
+// to one. Fields must be exported and not hidden from JSON.
+func selectFields(v interface{}, fields []string) (map[string]interface{}, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.Errorf("cannot select fields of %s", rv.Type())
+	}
+	m := make(map[string]interface{}, len(fields))
+	for _, name := range fields {
+		sf, ok := rv.Type().FieldByName(name)
+		if !ok || sf.PkgPath != "" || sf.Tag.Get("json") == "-" {
+			return nil, badRequest("unknown field %q", name)
+		}
+		m[name] = rv.FieldByIndex(sf.Index).Interface()
+	}
+	return m, nil
+}
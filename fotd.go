@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+)
+
+// fotdCandidates is the number of most recent fits considered when picking
+// a new fit of the day.
+const fotdCandidates = 5000
+
+type fotdCache struct {
+	sync.Mutex
+	day string
+	fit interface{}
+}
+
+// FOTD returns the fit of the day. The first request of each (UTC) day picks
+// the most expensive recent fit that hasn't been a fit of the day before and
+// records it, so all instances agree on the day's fit.
+func (s *EFContext) FOTD(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	day := time.Now().UTC().Format("2006-01-02")
+
+	s.fotd.Lock()
+	defer s.fotd.Unlock()
+	if s.fotd.day == day {
+		return s.fotd.fit, nil
+	}
+
+	if _, err := s.DB.ExecContext(ctx, `
+		INSERT
+		INTO
+			fotd (day, killmail)
+		SELECT
+			$1, killmail
+		FROM
+			(
+				SELECT
+					killmail, cost
+				FROM
+					fits
+				ORDER BY
+					killmail DESC
+				LIMIT
+					$2
+			)
+		WHERE
+			killmail NOT IN (SELECT killmail FROM fotd)
+		ORDER BY
+			cost DESC NULLS LAST, killmail DESC
+		LIMIT
+			1
+		ON CONFLICT
+			(day)
+		DO
+			NOTHING
+	`, day, fotdCandidates); err != nil {
+		return nil, err
+	}
+	var id int32
+	if err := s.DB.QueryRowContext(ctx, `SELECT killmail FROM fotd WHERE day = $1`, day).Scan(&id); err != nil {
+		return nil, err
+	}
+	fit, err := s.fit(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.fotd.day = day
+	s.fotd.fit = fit
+	return fit, nil
+}
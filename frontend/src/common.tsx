@@ -17,10 +17,11 @@ function readCookie(name: string) {
 	return localStorage.getItem(name);
 }
 
-const baseURL =
+const apiHost =
 	process.env.NODE_ENV === 'production'
-		? 'https://fittings-5anqu7dwna-uc.a.run.app/api/'
-		: '/api/';
+		? 'https://fittings-5anqu7dwna-uc.a.run.app'
+		: '';
+const baseURL = apiHost + '/api/v1/';
 
 async function Fetch<T>(
 	path: string,
@@ -33,8 +34,11 @@ async function Fetch<T>(
 	}
 	try {
 		const resp = await fetch(url);
-		const data = await resp.json();
-		success(data);
+		const body = await resp.json();
+		if (body.error) {
+			throw new Error(body.error.message);
+		}
+		success(body.data);
 	} catch (error) {
 		onErr(error);
 	}
@@ -89,11 +93,12 @@ function Img(props: ImgProps & { type: string; size: number }) {
 		<img
 			className="v-mid mr2"
 			src={
-				'https://images.evetech.net/types/' +
+				apiHost +
+				'/icon/' +
 				props.id +
-				'/' +
+				'?type=' +
 				props.type +
-				'?size=' +
+				'&size=' +
 				props.size
 			}
 			alt={props.alt}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"compress/bzip2"
+	"encoding/csv"
+	"io"
+	"io/fs"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// readFuzzwork reads static data from the CSV conversion of the SDE
+// published by Fuzzwork (https://www.fuzzwork.co.uk/dump/latest/). The
+// invGroups, invTypes, dgmAttributeTypes, and dgmTypeAttributes tables, and
+// the map tables of readFuzzworkUniverse, are read from fsys as .csv or
+// .csv.bz2 files.
+func readFuzzwork(fsys fs.FS) (*staticData, error) {
+	g := &staticData{
+		Groups:         map[int32]Group{},
+		Items:          map[int32]Item{},
+		Attributes:     map[int32]DogmaAttribute{},
+		TypeAttributes: map[int32]map[int32]float64{},
+		// Fuzzwork doesn't convert dynamicItemAttributes, so abyssal
+		// modules aren't described.
+		Mutations: map[int32]Mutation{},
+	}
+	if err := readFuzzworkCSV(fsys, "invGroups", func(row fuzzworkRow) {
+		grp := Group{
+			ID:       row.int32("groupID"),
+			Name:     row.str("groupName"),
+			Category: row.int32("categoryID"),
+		}
+		if grp.IsKnown() {
+			g.Groups[grp.ID] = grp
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if err := readFuzzworkCSV(fsys, "invTypes", func(row fuzzworkRow) {
+		id, group := row.int32("typeID"), row.int32("groupID")
+		if _, ok := g.Groups[group]; !ok {
+			return
+		}
+		name := row.str("typeName")
+		g.Items[id] = Item{
+			ID:    id,
+			Group: group,
+			Name:  name,
+			Lower: strings.ToLower(name),
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if err := readFuzzworkCSV(fsys, "dgmAttributeTypes", func(row fuzzworkRow) {
+		id := row.int32("attributeID")
+		g.Attributes[id] = DogmaAttribute{
+			ID:          id,
+			Name:        row.str("attributeName"),
+			DisplayName: row.str("displayName"),
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if err := readFuzzworkCSV(fsys, "dgmTypeAttributes", func(row fuzzworkRow) {
+		id := row.int32("typeID")
+		if _, ok := g.Items[id]; !ok {
+			return
+		}
+		// Values are in valueFloat or, for some attributes, valueInt.
+		v, err := strconv.ParseFloat(row.str("valueFloat"), 64)
+		if err != nil {
+			v, _ = strconv.ParseFloat(row.str("valueInt"), 64)
+		}
+		if g.TypeAttributes[id] == nil {
+			g.TypeAttributes[id] = map[int32]float64{}
+		}
+		g.TypeAttributes[id][row.int32("attributeID")] = v
+	}); err != nil {
+		return nil, err
+	}
+	if err := g.readFuzzworkUniverse(fsys); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// fuzzworkRow is a CSV record with its header's column indexes.
+type fuzzworkRow struct {
+	cols   map[string]int
+	record []string
+}
+
+// str returns the value of column name, or "" if absent or None.
+func (r fuzzworkRow) str(name string) string {
+	i, ok := r.cols[name]
+	if !ok || i >= len(r.record) || r.record[i] == "None" {
+		return ""
+	}
+	return r.record[i]
+}
+
+func (r fuzzworkRow) int32(name string) int32 {
+	v, _ := strconv.ParseInt(r.str(name), 10, 32)
+	return int32(v)
+}
+
+func (r fuzzworkRow) float64(name string) float64 {
+	v, _ := strconv.ParseFloat(r.str(name), 64)
+	return v
+}
+
+// readFuzzworkCSV calls f with each row of table, read from table.csv or
+// table.csv.bz2.
+func readFuzzworkCSV(fsys fs.FS, table string, f func(fuzzworkRow)) error {
+	file, err := fsys.Open(table + ".csv")
+	compressed := false
+	if errors.Is(err, fs.ErrNotExist) {
+		file, err = fsys.Open(table + ".csv.bz2")
+		compressed = true
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if compressed {
+		r = bzip2.NewReader(file)
+	}
+	slog.Info("reading SDE", "table", table)
+
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return errors.Wrap(err, table)
+	}
+	row := fuzzworkRow{cols: map[string]int{}}
+	for i, name := range header {
+		row.cols[name] = i
+	}
+	for {
+		row.record, err = cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, table)
+		}
+		f(row)
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+)
+
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+type Query {
+	fit(killmail: Int!): Fit
+	fits(ship: Int, items: [Int!], groups: [Int!]): [FitSummary!]!
+	item(id: Int!): Item
+	group(id: Int!): Group
+	search(term: String!, type: String): [SearchResult!]!
+	# The latest meta report as JSON.
+	report: String
+}
+
+type Item {
+	id: Int!
+	name: String!
+	group: Group
+}
+
+type ItemCharge {
+	item: Item
+	charge: Item
+}
+
+type Group {
+	id: Int!
+	name: String!
+	category: Int!
+	items: [Item!]!
+}
+
+type Killmail {
+	id: Int!
+	hash: String!
+	fittedValue: Float!
+	totalValue: Float!
+	points: Int!
+	npc: Boolean!
+	solo: Boolean!
+	awox: Boolean!
+}
+
+type Fit {
+	killmail: Killmail!
+	ship: Item
+	hi: [ItemCharge!]!
+	med: [ItemCharge!]!
+	low: [ItemCharge!]!
+	rig: [ItemCharge!]!
+	sub: [ItemCharge!]!
+}
+
+type FitSummary {
+	killmail: Int!
+	ship: Item
+	cost: Float!
+	dropped: Float!
+	points: Int!
+	score: Int!
+	hi: [Item!]!
+	med: [Item!]!
+	low: [Item!]!
+}
+
+type SearchResult {
+	type: String!
+	name: String!
+	id: Int!
+}
+`
+
+const (
+	// gqlMaxDepth is the deepest a query may nest selections, such as
+	// group { items { group { items ... } } }.
+	gqlMaxDepth = 8
+	// gqlMaxCost bounds the work of a query. Each field that queries the
+	// database costs gqlQueryCost and each object returned in a list costs
+	// one, so aliasing fields or listing large groups can't multiply work.
+	gqlMaxCost   = 5000
+	gqlQueryCost = 250
+)
+
+// initGraphQL parses the GraphQL schema against the resolvers. It panics if
+// they don't match.
+func (s *EFContext) initGraphQL() {
+	s.graphql = graphql.MustParseSchema(graphqlSchema, &gqlQuery{s}, graphql.MaxDepth(gqlMaxDepth))
+}
+
+type gqlCostContextKey struct{}
+
+// gqlCharge adds n to the cost of the query of ctx, returning an error once
+// it exceeds gqlMaxCost.
+func gqlCharge(ctx context.Context, n int) error {
+	cost, _ := ctx.Value(gqlCostContextKey{}).(*atomic.Int64)
+	if cost == nil {
+		return nil
+	}
+	if cost.Add(int64(n)) > gqlMaxCost {
+		return errors.Errorf("query exceeds the maximum cost of %d", gqlMaxCost)
+	}
+	return nil
+}
+
+// GraphQLRequest is the JSON body of a GraphQL POST request.
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQL executes a GraphQL query from either a GET request's query and
+// variables parameters or a POST request's JSON body.
+func (s *EFContext) GraphQL(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	var params GraphQLRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			return nil, bodyError(err)
+		}
+	} else {
+		params.Query = r.FormValue("query")
+		params.OperationName = r.FormValue("operationName")
+		if v := r.FormValue("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &params.Variables); err != nil {
+				return nil, badRequest("decode graphql variables: %v", err)
+			}
+		}
+	}
+	// Return the response as-is so API versions don't envelope it: GraphQL
+	// clients expect its own data and errors shape.
+	ctx = context.WithValue(ctx, gqlCostContextKey{}, new(atomic.Int64))
+	data, err := json.Marshal(s.graphql.Exec(ctx, params.Query, params.OperationName, params.Variables))
+	if err != nil {
+		return nil, errors.Wrap(err, "encode graphql response")
+	}
+	return rawResult{contentType: "application/json", data: data}, nil
+}
+
+type gqlQuery struct {
+	s *EFContext
+}
+
+func (q *gqlQuery) Fit(ctx context.Context, args struct{ Killmail int32 }) (*gqlFit, error) {
+	if err := gqlCharge(ctx, gqlQueryCost); err != nil {
+		return nil, err
+	}
+	f, err := q.s.fit(ctx, args.Killmail)
+	if err != nil {
+		return nil, err
+	}
+	return &gqlFit{q.s, f}, nil
+}
+
+func (q *gqlQuery) Fits(ctx context.Context, args struct {
+	Ship   *int32
+	Items  *[]int32
+	Groups *[]int32
+}) ([]*gqlFitSummary, error) {
+	if err := gqlCharge(ctx, gqlQueryCost); err != nil {
+		return nil, err
+	}
+	var ship int32
+	var items, groups []int32
+	if args.Ship != nil {
+		ship = *args.Ship
+	}
+	if args.Items != nil {
+		items = *args.Items
+	}
+	if args.Groups != nil {
+		groups = *args.Groups
+	}
+	res, err := q.s.fits(ctx, fitsForm(ship, items, groups), fitsLimit, nil, servertiming.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if err := gqlCharge(ctx, len(res.Fits)); err != nil {
+		return nil, err
+	}
+	ret := make([]*gqlFitSummary, len(res.Fits))
+	for i, f := range res.Fits {
+		ret[i] = &gqlFitSummary{q.s, f}
+	}
+	return ret, nil
+}
+
+func (q *gqlQuery) Item(args struct{ ID int32 }) *gqlItem {
+	return q.s.gqlItem(args.ID)
+}
+
+func (q *gqlQuery) Group(args struct{ ID int32 }) *gqlGroup {
+	return q.s.gqlGroup(args.ID)
+}
+
+func (q *gqlQuery) Search(ctx context.Context, args struct {
+	Term string
+	Type *string
+}) ([]*gqlSearchResult, error) {
+	if err := gqlCharge(ctx, gqlQueryCost); err != nil {
+		return nil, err
+	}
+	var typ string
+	if args.Type != nil {
+		typ = *args.Type
+	}
+	res, err := q.s.find(ctx, args.Term, typ, servertiming.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*gqlSearchResult, len(res))
+	for i := range res {
+		ret[i] = &gqlSearchResult{res[i]}
+	}
+	return ret, nil
+}
+
+func (q *gqlQuery) Report(ctx context.Context) (*string, error) {
+	if err := gqlCharge(ctx, gqlQueryCost); err != nil {
+		return nil, err
+	}
+	res, err := q.s.latestReport(ctx)
+	if err != nil {
+		return nil, err
+	}
+	str := string(res)
+	return &str, nil
+}
+
+func (s *EFContext) gqlItem(id int32) *gqlItem {
+	item, ok := s.Global().Items[id]
+	if !ok {
+		return nil
+	}
+	return &gqlItem{s, item}
+}
+
+func (s *EFContext) gqlGroup(id int32) *gqlGroup {
+	g, ok := s.Global().Groups[id]
+	if !ok {
+		return nil
+	}
+	return &gqlGroup{s, g}
+}
+
+type gqlItem struct {
+	s    *EFContext
+	item Item
+}
+
+func (i *gqlItem) ID() int32        { return i.item.ID }
+func (i *gqlItem) Name() string     { return i.item.Name }
+func (i *gqlItem) Group() *gqlGroup { return i.s.gqlGroup(i.item.Group) }
+
+type gqlItemCharge struct {
+	s  *EFContext
+	ic ItemCharge
+}
+
+func (i *gqlItemCharge) Item() *gqlItem {
+	return i.s.gqlItem(i.ic.ID)
+}
+
+func (i *gqlItemCharge) Charge() *gqlItem {
+	if i.ic.Charge == nil {
+		return nil
+	}
+	return i.s.gqlItem(i.ic.Charge.ID)
+}
+
+type gqlGroup struct {
+	s *EFContext
+	g Group
+}
+
+func (g *gqlGroup) ID() int32       { return g.g.ID }
+func (g *gqlGroup) Name() string    { return g.g.Name }
+func (g *gqlGroup) Category() int32 { return g.g.Category }
+
+func (g *gqlGroup) Items(ctx context.Context) ([]*gqlItem, error) {
+	static := g.s.Global()
+	ids := static.groupItems[g.g.ID]
+	if err := gqlCharge(ctx, len(ids)); err != nil {
+		return nil, err
+	}
+	ret := make([]*gqlItem, len(ids))
+	for i, id := range ids {
+		ret[i] = &gqlItem{g.s, static.Items[id]}
+	}
+	return ret, nil
+}
+
+type gqlKillmail struct {
+	id  int32
+	zkb Zkb
+}
+
+func (k *gqlKillmail) ID() int32            { return k.id }
+func (k *gqlKillmail) Hash() string         { return k.zkb.Hash }
+func (k *gqlKillmail) FittedValue() float64 { return k.zkb.FittedValue }
+func (k *gqlKillmail) TotalValue() float64  { return k.zkb.TotalValue }
+func (k *gqlKillmail) Points() int32        { return int32(k.zkb.Points) }
+func (k *gqlKillmail) Npc() bool            { return k.zkb.Npc }
+func (k *gqlKillmail) Solo() bool           { return k.zkb.Solo }
+func (k *gqlKillmail) Awox() bool           { return k.zkb.Awox }
+
+type gqlFit struct {
+	s *EFContext
+	f *FitDetail
+}
+
+func (f *gqlFit) Killmail() *gqlKillmail { return &gqlKillmail{f.f.Killmail, f.f.Zkb} }
+func (f *gqlFit) Ship() *gqlItem         { return f.s.gqlItem(f.f.Ship.ID) }
+func (f *gqlFit) Hi() []*gqlItemCharge   { return f.slots(f.f.Hi) }
+func (f *gqlFit) Med() []*gqlItemCharge  { return f.slots(f.f.Med) }
+func (f *gqlFit) Low() []*gqlItemCharge  { return f.slots(f.f.Low) }
+func (f *gqlFit) Rig() []*gqlItemCharge  { return f.slots(f.f.Rig) }
+func (f *gqlFit) Sub() []*gqlItemCharge  { return f.slots(f.f.Sub) }
+
+// slots returns the filled slots of ics.
+func (f *gqlFit) slots(ics [8]ItemCharge) []*gqlItemCharge {
+	var ret []*gqlItemCharge
+	for _, ic := range ics {
+		if ic.ID == 0 && ic.Charge == nil {
+			continue
+		}
+		ret = append(ret, &gqlItemCharge{f.s, ic})
+	}
+	return ret
+}
+
+type gqlFitSummary struct {
+	s *EFContext
+	f *FitSummary
+}
+
+func (f *gqlFitSummary) Killmail() int32  { return int32(f.f.Killmail) }
+func (f *gqlFitSummary) Ship() *gqlItem   { return f.s.gqlItem(f.f.Ship) }
+func (f *gqlFitSummary) Cost() float64    { return float64(f.f.Cost) }
+func (f *gqlFitSummary) Dropped() float64 { return float64(f.f.Dropped) }
+func (f *gqlFitSummary) Points() int32    { return int32(f.f.Points) }
+func (f *gqlFitSummary) Score() int32     { return int32(f.f.Score) }
+func (f *gqlFitSummary) Hi() []*gqlItem   { return f.items(f.f.Hi) }
+func (f *gqlFitSummary) Med() []*gqlItem  { return f.items(f.f.Med) }
+func (f *gqlFitSummary) Low() []*gqlItem  { return f.items(f.f.Lo) }
+
+func (f *gqlFitSummary) items(items []Item) []*gqlItem {
+	ret := make([]*gqlItem, len(items))
+	for i, item := range items {
+		ret[i] = &gqlItem{f.s, item}
+	}
+	return ret
+}
+
+type gqlSearchResult struct {
+	r SearchResult
+}
+
+func (r *gqlSearchResult) Type() string { return r.r.Type }
+func (r *gqlSearchResult) Name() string { return r.r.Name }
+func (r *gqlSearchResult) ID() int32    { return r.r.ID }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjibson/ef/efpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// grpcPageSize is the number of rows fetched per query when streaming.
+	grpcPageSize = 500
+	// grpcMaxRecvBytes and grpcMaxSendBytes bound the size of request and
+	// response messages.
+	grpcMaxRecvBytes = 64 << 10
+	grpcMaxSendBytes = 4 << 20
+	// grpcUnaryTimeout and grpcStreamTimeout bound how long calls run,
+	// shortening any longer deadline set by the client.
+	grpcUnaryTimeout  = time.Second * 30
+	grpcStreamTimeout = time.Minute * 30
+)
+
+// serveGRPC starts serving the bulk fits gRPC API on addr. Calls require an
+// API key, given as a Bearer token in the authorization metadata, and count
+// against the key's rate limit.
+func (s *EFContext) serveGRPC(addr string) *grpc.Server {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("gRPC listen", "err", err)
+	}
+	srv := grpc.NewServer(
+		grpc.MaxRecvMsgSize(grpcMaxRecvBytes),
+		grpc.MaxSendMsgSize(grpcMaxSendBytes),
+		grpc.UnaryInterceptor(s.grpcUnary),
+		grpc.StreamInterceptor(s.grpcStream),
+	)
+	efpb.RegisterFittingsServer(srv, &grpcServer{s: s})
+	slog.Info("gRPC listening", "addr", addr)
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			fatal("gRPC serve", "err", err)
+		}
+	}()
+	return srv
+}
+
+// grpcAuthorize returns ctx with the API key of its call, or an error if the
+// call has no valid key or is over the key's rate limit.
+func (s *EFContext) grpcAuthorize(ctx context.Context) (context.Context, error) {
+	var auth string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			auth = v[0]
+		}
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == auth || token == "" {
+		return nil, status.Error(codes.Unauthenticated, "authorization must be a Bearer API key")
+	}
+	key, err := s.lookupAPIKey(ctx, token)
+	if err != nil {
+		slog.Error("gRPC api key", "err", err)
+		return nil, status.Error(codes.Unavailable, "could not check API key")
+	}
+	if key == nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	if s.limiter != nil {
+		if ok, _ := s.limiter.take("key:"+strconv.FormatInt(key.ID, 10), key.Tier); !ok {
+			return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		}
+	}
+	return context.WithValue(ctx, apiKeyContextKey{}, key), nil
+}
+
+func (s *EFContext) grpcUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.grpcAuthorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, grpcUnaryTimeout)
+	defer cancel()
+	return handler(ctx, req)
+}
+
+func (s *EFContext) grpcStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.grpcAuthorize(ss.Context())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, grpcStreamTimeout)
+	defer cancel()
+	return handler(srv, &grpcContextStream{ServerStream: ss, ctx: ctx})
+}
+
+// grpcContextStream is a ServerStream with its context replaced.
+type grpcContextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcContextStream) Context() context.Context { return s.ctx }
+
+type grpcServer struct {
+	efpb.UnimplementedFittingsServer
+	s *EFContext
+}
+
+func (g *grpcServer) GetFit(ctx context.Context, req *efpb.GetFitRequest) (*efpb.Fit, error) {
+	f, err := g.s.fit(withReadOnly(ctx), req.Killmail)
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "fit %d not found", req.Killmail)
+	} else if err != nil {
+		return nil, err
+	}
+	return g.fit(f, 0), nil
+}
+
+func (g *grpcServer) ListFits(req *efpb.ListFitsRequest, stream efpb.Fittings_ListFitsServer) error {
+	ctx := withReadOnly(stream.Context())
+	before, sent := req.Before, int32(0)
+	for {
+		var sb strings.Builder
+		sb.WriteString(`
+			SELECT
+				k.id, k.km, k.zkb, f.cost
+			FROM
+				fits AS f JOIN killmails AS k ON k.id = f.killmail
+			WHERE
+				TRUE
+		`)
+		args := g.s.writeFitsFilter(ctx, &sb, fitsForm(req.Ship, req.Items, req.Groups), map[string][]Item{})
+		if before > 0 {
+			args = append(args, before)
+			fmt.Fprintf(&sb, ` AND f.killmail < $%d`, len(args))
+		}
+		args = append(args, grpcPageSize)
+		fmt.Fprintf(&sb, ` ORDER BY f.killmail DESC LIMIT $%d`, len(args))
+
+		var rows []struct {
+			ID      int32
+			KM, Zkb []byte
+			Cost    sql.NullInt64
+		}
+		if err := g.s.read(ctx).SelectContext(ctx, &rows, sb.String(), args...); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			f, err := g.s.fitDetail(row.ID, row.KM, row.Zkb)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(g.fit(f, row.Cost.Int64)); err != nil {
+				return err
+			}
+			before = row.ID
+			sent++
+			if req.Limit > 0 && sent >= req.Limit {
+				return nil
+			}
+		}
+		if len(rows) < grpcPageSize {
+			return nil
+		}
+	}
+}
+
+func (g *grpcServer) ListKillmails(req *efpb.ListKillmailsRequest, stream efpb.Fittings_ListKillmailsServer) error {
+	ctx := withReadOnly(stream.Context())
+	before, sent := req.Before, int32(0)
+	for {
+		var rows []struct {
+			ID      int32
+			KM, Zkb []byte
+		}
+		if err := g.s.read(ctx).SelectContext(ctx, &rows, `
+			SELECT
+				id, km, zkb
+			FROM
+				killmails
+			WHERE
+				$1 = 0 OR id < $1
+			ORDER BY
+				id DESC
+			LIMIT
+				$2
+		`, before, grpcPageSize); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := stream.Send(&efpb.Killmail{
+				Id:  row.ID,
+				Km:  row.KM,
+				Zkb: row.Zkb,
+			}); err != nil {
+				return err
+			}
+			before = row.ID
+			sent++
+			if req.Limit > 0 && sent >= req.Limit {
+				return nil
+			}
+		}
+		if len(rows) < grpcPageSize {
+			return nil
+		}
+	}
+}
+
+// fit converts f to its protobuf message.
+func (g *grpcServer) fit(f *FitDetail, cost int64) *efpb.Fit {
+	if cost == 0 {
+		cost = int64(f.Zkb.FittedValue)
+	}
+	return &efpb.Fit{
+		Killmail: f.Killmail,
+		Ship:     pbItem(&f.Ship),
+		Cost:     cost,
+		Hi:       pbSlots(f.Hi),
+		Med:      pbSlots(f.Med),
+		Low:      pbSlots(f.Low),
+		Rig:      pbSlots(f.Rig),
+		Sub:      pbSlots(f.Sub),
+	}
+}
+
+func pbItem(item *Item) *efpb.Item {
+	if item == nil || item.ID == 0 {
+		return nil
+	}
+	return &efpb.Item{
+		Id:    item.ID,
+		Name:  item.Name,
+		Group: item.Group,
+	}
+}
+
+// pbSlots returns the filled slots of ics.
+func pbSlots(ics [8]ItemCharge) []*efpb.ItemCharge {
+	var ret []*efpb.ItemCharge
+	for i := range ics {
+		ic := &ics[i]
+		if ic.ID == 0 && ic.Charge == nil {
+			continue
+		}
+		ret = append(ret, &efpb.ItemCharge{
+			Item:   pbItem(&ic.Item),
+			Charge: pbItem(ic.Charge),
+		})
+	}
+	return ret
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Healthz reports that the process is up.
+func (s *EFContext) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz reports whether the instance can serve requests: the database is
+// reachable and static data is loaded into Global. It responds 503 with the
+// failing checks otherwise. Check errors are logged rather than returned.
+func (s *EFContext) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*2)
+	defer cancel()
+	checks := map[string]string{"db": "ok", "sde": "ok"}
+	ready := true
+	if err := s.DB.PingContext(ctx); err != nil {
+		logger(ctx).Error("readyz: ping database", "err", err)
+		checks["db"] = "unreachable"
+		ready = false
+	}
+	if g := s.Global(); g == nil || len(g.Items) == 0 || len(g.Groups) == 0 {
+		checks["sde"] = "static data not loaded"
+		ready = false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(checks)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// iconMaxAge is how long clients may cache proxied images. CCP rarely
+// changes type images.
+const iconMaxAge = time.Hour * 24 * 7
+
+// iconMaxBytes bounds the size of a fetched image.
+const iconMaxBytes = 1 << 20
+
+// iconFetchTimeout bounds a shared fetch of an image.
+const iconFetchTimeout = time.Second * 30
+
+// iconSizes are the image sizes served by the EVE image server.
+var iconSizes = map[int]bool{32: true, 64: true, 128: true, 256: true, 512: true}
+
+// Icon proxies EVE image server type images from a request path of the form
+// /icon/{typeid}, caching them on disk. The type parameter is icon (the
+// default) or render, and size is one of iconSizes, defaulting to 64. Only
+// types in the static data are served.
+func (s *EFContext) Icon(w http.ResponseWriter, r *http.Request) {
+	s.cors.apply(w, r)
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/icon/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid type id", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.Global().Items[int32(id)]; !ok {
+		http.Error(w, "unknown type id", http.StatusNotFound)
+		return
+	}
+	typ := r.FormValue("type")
+	if typ == "" {
+		typ = "icon"
+	}
+	if typ != "icon" && typ != "render" {
+		http.Error(w, "invalid image type", http.StatusBadRequest)
+		return
+	}
+	size := 64
+	if v := r.FormValue("size"); v != "" {
+		size, _ = strconv.Atoi(v)
+	}
+	if !iconSizes[size] {
+		http.Error(w, "invalid size", http.StatusBadRequest)
+		return
+	}
+	data, mod, err := s.typeImage(r.Context(), typ, int32(id), size)
+	if err != nil {
+		logger(r.Context()).Error("type image", "type", typ, "id", id, "err", err)
+		http.Error(w, "could not fetch image", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(iconMaxAge.Seconds())))
+	http.ServeContent(w, r, "", mod, bytes.NewReader(data))
+}
+
+// typeImage returns an EVE image server type image and the time it was
+// fetched, reading from the on-disk cache if present. Concurrent misses of
+// the same image share one fetch.
+func (s *EFContext) typeImage(ctx context.Context, typ string, id int32, size int) ([]byte, time.Time, error) {
+	name := fmt.Sprintf("%d-%s-%d", id, typ, size)
+	path := filepath.Join(s.iconCache, name)
+	if fi, err := os.Stat(path); err == nil {
+		data, err := os.ReadFile(path)
+		return data, fi.ModTime(), err
+	}
+	type image struct {
+		data    []byte
+		fetched time.Time
+	}
+	res, err, _ := s.iconFetches.Do(name, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), iconFetchTimeout)
+		defer cancel()
+		data, err := s.fetchTypeImage(ctx, typ, id, size, path)
+		return image{data, time.Now()}, err
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	img := res.(image)
+	return img.data, img.fetched, nil
+}
+
+// fetchTypeImage fetches an EVE image server type image and stores it at
+// path.
+func (s *EFContext) fetchTypeImage(ctx context.Context, typ string, id int32, size int, path string) ([]byte, error) {
+	u := fmt.Sprintf("https://images.evetech.net/types/%d/%s?size=%d", id, typ, size)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("get %s: %s", u, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, iconMaxBytes+1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", u)
+	}
+	if len(data) > iconMaxBytes {
+		return nil, errors.Errorf("get %s: image larger than %d bytes", u, iconMaxBytes)
+	}
+	// Write to a temporary file first so concurrent readers never see a
+	// partial image.
+	if err := os.MkdirAll(s.iconCache, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(s.iconCache, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Group IDs of hulls matched by ingestion rules.
+const (
+	groupCapsule            = 29
+	groupShuttle            = 31
+	groupCorvette           = 237 // rookie ships
+	groupIndustrial         = 28
+	groupDeepSpaceTransport = 380
+	groupBlockadeRunner     = 1202
+	groupFreighter          = 513
+)
+
+// ingestRules are the named rules that can skip storing a killmail's fit.
+// A rule reports whether the fit of a victim ship of group should be
+// skipped.
+var ingestRules = map[string]func(group int32, r Racks) bool{
+	"capsule": func(group int32, r Racks) bool { return group == groupCapsule },
+	"shuttle": func(group int32, r Racks) bool { return group == groupShuttle },
+	"rookie":  func(group int32, r Racks) bool { return group == groupCorvette },
+	// empty_hauler skips haulers with nothing in their medium and low slots,
+	// whose fits are only cloaks or probe launchers.
+	"empty_hauler": func(group int32, r Racks) bool {
+		switch group {
+		case groupIndustrial, groupDeepSpaceTransport, groupBlockadeRunner, groupFreighter:
+		default:
+			return false
+		}
+		for _, rack := range [][8]ItemCharge{r.Med, r.Low} {
+			for _, ic := range rack {
+				if ic.ID != 0 {
+					return false
+				}
+			}
+		}
+		return true
+	},
+}
+
+// ingestRuleNames returns the names of ingestRules, sorted.
+func ingestRuleNames() string {
+	var names []string
+	for name := range ingestRules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// skipFit returns the name of the first configured ingestion rule matching
+// the fit of km, or "" if it should be stored.
+func (s *EFContext) skipFit(km KM, r Racks) string {
+	g := s.Global()
+	group := g.Items[km.Victim.ShipTypeId].Group
+	for _, name := range s.ingestSkip {
+		if ingestRules[name](group, r) {
+			return name
+		}
+	}
+	return ""
+}
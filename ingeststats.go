@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ingestStats tracks how well ingestion keeps up, for debugVars.
+type ingestStats struct {
+	sync.Mutex
+	// latest and latestProcessed are the times of the newest killmails
+	// stored and processed.
+	latest, latestProcessed time.Time
+	// minute is the start of the current minute, in which count killmails
+	// have been processed so far; lastMinute were processed in the minute
+	// before.
+	minute            time.Time
+	count, lastMinute int64
+	failures          map[string]int64
+}
+
+// stored records a killmail of time t stored for processing.
+func (st *ingestStats) stored(t time.Time) {
+	st.Lock()
+	defer st.Unlock()
+	if t.After(st.latest) {
+		st.latest = t
+	}
+}
+
+// processed records a processed killmail of time t.
+func (st *ingestStats) processed(t time.Time) {
+	st.Lock()
+	defer st.Unlock()
+	if t.After(st.latestProcessed) {
+		st.latestProcessed = t
+	}
+	st.roll(time.Now())
+	st.count++
+}
+
+// roll starts a new minute if now is past the current one.
+func (st *ingestStats) roll(now time.Time) {
+	minute := now.Truncate(time.Minute)
+	switch {
+	case minute.Equal(st.minute):
+		return
+	case minute.Sub(st.minute) == time.Minute:
+		st.lastMinute = st.count
+	default:
+		st.lastMinute = 0
+	}
+	st.minute = minute
+	st.count = 0
+}
+
+// failed records an ingestion failure from source, such as redisq or esi.
+func (st *ingestStats) failed(source string) {
+	st.Lock()
+	defer st.Unlock()
+	if st.failures == nil {
+		st.failures = map[string]int64{}
+	}
+	st.failures[source]++
+}
+
+// vars returns the stats. Lag is how far processing trails the newest
+// stored killmail, and behind how far it trails real time, in seconds.
+func (st *ingestStats) vars() map[string]interface{} {
+	st.Lock()
+	defer st.Unlock()
+	now := time.Now()
+	st.roll(now)
+	vars := map[string]interface{}{
+		"processed_per_minute": st.lastMinute,
+		"failures":             copyCounts(st.failures),
+	}
+	if !st.latestProcessed.IsZero() {
+		vars["latest_processed"] = st.latestProcessed
+		vars["behind_seconds"] = now.Sub(st.latestProcessed).Seconds()
+		if !st.latest.IsZero() {
+			vars["lag_seconds"] = max(st.latest.Sub(st.latestProcessed).Seconds(), 0)
+		}
+	}
+	if !st.latest.IsZero() {
+		vars["latest_stored"] = st.latest
+	}
+	return vars
+}
+
+func copyCounts(m map[string]int64) map[string]int64 {
+	c := map[string]int64{}
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
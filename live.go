@@ -0,0 +1,247 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// liveBuffer is the number of fits buffered per subscriber. Fits sent to
+// a subscriber with a full buffer are dropped.
+const liveBuffer = 16
+
+// LiveFit is a compact description of a newly processed fit.
+type LiveFit struct {
+	Killmail int32
+	Ship     int32
+	Name     string
+	Cost     int64
+	items    []int32
+	// seq is the fit's position in the order fits were stored.
+	seq int64
+}
+
+// liveHub fans out fits stored by ProcessFits to live feed subscribers in
+// this process.
+type liveHub struct {
+	sync.Mutex
+	subs map[chan *LiveFit]bool
+}
+
+func (h *liveHub) subscribe() chan *LiveFit {
+	h.Lock()
+	defer h.Unlock()
+	if h.subs == nil {
+		h.subs = map[chan *LiveFit]bool{}
+	}
+	c := make(chan *LiveFit, liveBuffer)
+	h.subs[c] = true
+	return c
+}
+
+func (h *liveHub) unsubscribe(c chan *LiveFit) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.subs, c)
+}
+
+func (h *liveHub) publish(f *LiveFit) {
+	h.Lock()
+	defer h.Unlock()
+	for c := range h.subs {
+		select {
+		case c <- f:
+		default:
+		}
+	}
+}
+
+// liveFilter returns a function reporting whether a fit matches the ship
+// and group filters in r. A fit matches a group if its ship or any item is
+// in the group.
+func (s *EFContext) liveFilter(r *http.Request) func(*LiveFit) bool {
+	ship, _ := strconv.Atoi(r.FormValue("ship"))
+	var groups []int32
+	for _, g := range r.Form["group"] {
+		if id, _ := strconv.Atoi(g); id > 0 {
+			groups = append(groups, int32(id))
+		}
+	}
+	return func(f *LiveFit) bool {
+		if ship > 0 && f.Ship != int32(ship) {
+			return false
+		}
+		static := s.Global()
+	Groups:
+		for _, g := range groups {
+			for _, id := range f.items {
+				if static.Items[id].Group == g {
+					continue Groups
+				}
+			}
+			return false
+		}
+		return true
+	}
+}
+
+// LiveWS is a WebSocket feed sending a JSON LiveFit message for each fit
+// stored by this instance, optionally filtered by ship and group. Clients
+// that send an Origin, which browsers always do, must be from a CORS origin.
+func (s *EFContext) LiveWS() http.Handler {
+	return websocket.Server{
+		// Unlike the default, a missing Origin is accepted, as non-browser
+		// clients needn't send one.
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			if origin := r.Header.Get("Origin"); origin != "" && s.cors.allowOrigin(origin) == "" {
+				return fmt.Errorf("origin %q not allowed", origin)
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			r := ws.Request()
+			r.ParseForm()
+			match := s.liveFilter(r)
+			c := s.live.subscribe()
+			defer s.live.unsubscribe(c)
+
+			// Detect client disconnects by reading until error.
+			done := make(chan struct{})
+			go func() {
+				var msg []byte
+				for websocket.Message.Receive(ws, &msg) == nil {
+				}
+				close(done)
+			}()
+			for {
+				select {
+				case <-done:
+					return
+				case <-s.stopping.Done():
+					return
+				case f := <-c:
+					if !match(f) {
+						continue
+					}
+					b, err := json.Marshal(f)
+					if err != nil {
+						return
+					}
+					if err := websocket.Message.Send(ws, string(b)); err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+}
+
+// liveReplay is the maximum number of missed fits sent to a reconnecting
+// event stream client.
+const liveReplay = 100
+
+// Events is a Server-Sent Events feed of fits stored by this instance,
+// optionally filtered by ship and group. Event IDs are the order fits were
+// stored in, as killmails aren't stored in ID order; a client reconnecting
+// with Last-Event-ID is first sent fits stored after that event.
+func (s *EFContext) Events(w http.ResponseWriter, r *http.Request) {
+	s.cors.apply(w, r)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	r.ParseForm()
+	match := s.liveFilter(r)
+	c := s.live.subscribe()
+	defer s.live.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, "retry: 5000\n\n")
+
+	send := func(f *LiveFit) bool {
+		b, err := json.Marshal(f)
+		if err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: fit\ndata: %s\n\n", f.seq, b); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	// Replay fits missed since the last received event. The subscription
+	// above was made first so nothing is lost between replay and live.
+	replayed := map[int32]bool{}
+	if last, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); last > 0 {
+		var rows []struct {
+			Seq      int64
+			Killmail int32
+			Ship     int32
+			Cost     sql.NullInt64
+			Items    int32Array
+		}
+		if err := s.X.SelectContext(r.Context(), &rows, `
+			SELECT
+				seq, killmail, ship, cost, items
+			FROM
+				fits
+			WHERE
+				seq > $1
+			ORDER BY
+				seq
+			LIMIT
+				$2
+		`, last, liveReplay); err != nil {
+			logger(r.Context()).Error("events replay", "err", err)
+		}
+		g := s.Global()
+		for _, row := range rows {
+			f := &LiveFit{
+				Killmail: row.Killmail,
+				Ship:     row.Ship,
+				Name:     g.Items[row.Ship].Name,
+				Cost:     row.Cost.Int64,
+				items:    row.Items,
+				seq:      row.Seq,
+			}
+			replayed[f.Killmail] = true
+			if match(f) && !send(f) {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(time.Second * 30)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopping.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case f := <-c:
+			if replayed[f.Killmail] || !match(f) {
+				continue
+			}
+			if !send(f) {
+				return
+			}
+		}
+	}
+}
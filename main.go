@@ -1,178 +1,425 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/cipher"
 	"database/sql"
-	"encoding/gob"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
-	"strings"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	graphql "github.com/graph-gophers/graphql-go"
 	"github.com/jmoiron/sqlx"
-	"github.com/kelseyhightower/envconfig"
-	yaml "gopkg.in/yaml.v2"
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
 )
 
 var (
 	flagProcess      = flag.Bool("process", false, "processed unprocessed killmails")
 	flagCreateTables = flag.Bool("create-tables", false, "create tables")
-	flagLog          = flag.Bool("log", false, "log DB")
+	flagMigrate      = flag.Bool("migrate", false, "apply pending schema migrations and exit")
 	flagSync         = flag.Bool("sync", false, "run data sync")
+	flagRedisQ       = flag.Bool("redisq", false, "continuously ingest killmails from redisq")
+	flagZKBWebsocket = flag.Bool("zkb-websocket", false, "continuously ingest killmails from the zKillboard websocket")
+	flagQueueID      = flag.String("queue-id", "", "override REDISQ_QUEUE")
+	flagBackfill     = flag.Bool("backfill", false, "store and process past killmails from zKillboard's history between -from and -to")
+	flagFrom         = flag.String("from", "", "first day (YYYY-MM-DD) of -backfill")
+	flagTo           = flag.String("to", "", "last day (YYYY-MM-DD) of -backfill; defaults to -from")
+	flagShip         = flag.Int("ship", 0, "only backfill losses of this ship type ID")
+	flagCreateAPIKey = flag.String("create-api-key", "", "create an API key with the given name and print it")
+	flagAdmin        = flag.Bool("admin", false, "grant the key created by -create-api-key admin access")
+	flagLoadSDE      = flag.Bool("load-sde", false, "read static data from SDE_DIR, replacing the stored data")
+	flagSDEFormat    = flag.String("sde-format", "", "override SDE_FORMAT")
+	flagIngestAll    = flag.Bool("ingest-all", false, "store all fits, ignoring INGEST_SKIP")
+	flagWorkers      = flag.Int("workers", 0, "override WORKERS")
+	flagCacheRedis   = flag.String("cache-redis", "", "override CACHE_REDIS")
+	flagDBMaxOpen    = flag.Int("db-max-open", 0, "override DB_MAX_OPEN")
+	flagDBMaxIdle    = flag.Int("db-max-idle", 0, "override DB_MAX_IDLE")
+	flagDBLifetime   = flag.Duration("db-conn-lifetime", 0, "override DB_CONN_LIFETIME")
+	flagConfig       = flag.String("config", "", "YAML config file; environment variables override its values")
+	flagPrintConfig  = flag.Bool("print-config", false, "print the resolved config and exit")
+	flagLogLevel     = flag.String("loglevel", "", "override LOG_LEVEL")
+	flagLogFormat    = flag.String("logformat", "", "override LOG_FORMAT")
 )
 
-type Specification struct {
-	Port    string `default:"4001"`
-	DB_Addr string `default:"postgres://root@localhost:26257/ef?sslmode=disable"`
-}
-
 func main() {
 	flag.Parse()
 
-	var spec Specification
-	err := envconfig.Process("", &spec)
+	spec, err := loadConfig(*flagConfig)
 	if err != nil {
-		log.Fatal(err.Error())
+		fatal("config", "err", err)
+	}
+	if *flagLogLevel != "" {
+		spec.Log_Level = *flagLogLevel
+	}
+	if *flagLogFormat != "" {
+		spec.Log_Format = *flagLogFormat
+	}
+	if *flagQueueID != "" {
+		spec.RedisQ_Queue = *flagQueueID
+	}
+	if *flagIngestAll {
+		spec.Ingest_Skip = nil
+	}
+	if *flagSDEFormat != "" {
+		spec.SDE_Format = *flagSDEFormat
+	}
+	if *flagWorkers > 0 {
+		spec.Workers = *flagWorkers
+	}
+	if *flagCacheRedis != "" {
+		spec.Cache_Redis = *flagCacheRedis
+	}
+	if *flagDBMaxOpen > 0 {
+		spec.DB_Max_Open = *flagDBMaxOpen
 	}
-	if !strings.Contains(spec.Port, ":") {
-		spec.Port = fmt.Sprintf(":%s", spec.Port)
+	if *flagDBMaxIdle > 0 {
+		spec.DB_Max_Idle = *flagDBMaxIdle
+	}
+	if *flagDBLifetime > 0 {
+		spec.DB_Conn_Lifetime = *flagDBLifetime
+	}
+	initLogging(spec.Log_Level, spec.Log_Format)
+	if *flagPrintConfig {
+		if err := spec.print(); err != nil {
+			fatal("print config", "err", err)
+		}
+		return
 	}
 
-	dbURL, err := url.Parse(spec.DB_Addr)
-	if err != nil {
-		log.Fatal(err)
+	if spec.OTLP_Endpoint != "" {
+		shutdown, err := initTracing(context.Background(), spec.OTLP_Endpoint)
+		if err != nil {
+			fatal("init tracing", "err", err)
+		}
+		defer shutdown(context.Background())
 	}
 
-	db := mustInitDB(dbURL.String())
+	db := mustInitDB(spec.DB_Addr)
 	defer db.Close()
+	spec.configurePool(db)
 	if err := db.Ping(); err != nil {
-		log.Fatal(err)
+		fatal("ping db", "err", err)
 	}
-	fmt.Println("inited", dbURL)
+	slog.Info("inited db", "addr", redactURL(spec.DB_Addr))
+
+	// ctx is canceled on SIGTERM or interrupt, starting shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
 	s := &EFContext{
-		DB: db,
-		X:  sqlx.NewDb(db, "postgres"),
+		DB:          db,
+		X:           sqlx.NewDb(db, "postgres"),
+		iconCache:   spec.Icon_Cache,
+		sdeDir:      spec.SDE_Dir,
+		sdeFormat:   spec.SDE_Format,
+		ingestSkip:  spec.Ingest_Skip,
+		redisqQueue: spec.RedisQ_Queue,
+		zkbChannels: spec.ZKB_Channels,
+		workers:     spec.Workers,
+		workerPace:  spec.Worker_Pace,
+		syncSecret:  spec.Sync_Secret,
+		retention:   spec.Retention_Months,
+		archiveDir:  spec.Archive_Dir,
+		stopping:    ctx,
+
+		cors: corsPolicy{
+			origins:     spec.CORS_Origins,
+			methods:     spec.CORS_Methods,
+			credentials: spec.CORS_Credentials,
+		},
+		sso:            newSSOConfig(spec.SSO_Client_ID, spec.SSO_Secret, spec.SSO_Callback, spec.SSO_Scopes),
+		ssoTokens:      newTokenCipher(spec.SSO_Secret),
+		requestTimeout: spec.Request_Timeout,
+		routeTimeouts:  spec.Route_Timeouts,
+	}
+	for name := range spec.Route_Timeouts {
+		if !s.isRoute(name) {
+			fatal("route_timeouts: unknown route", "route", name)
+		}
+	}
+	if spec.Rate_Limit > 0 {
+		s.limiter = newRateLimiter(rateTier{Limit: rate.Limit(spec.Rate_Limit), Burst: spec.Rate_Burst}, spec.Trusted_Proxies, s.cachedAPIKey)
+	}
+	if spec.DB_Replica_Addr != "" {
+		rdb := mustInitDB(spec.DB_Replica_Addr)
+		defer rdb.Close()
+		spec.configurePool(rdb)
+		s.replica = &replica{X: sqlx.NewDb(rdb, "postgres")}
+		go s.replica.watch(ctx)
+		slog.Info("inited read replica", "addr", redactURL(spec.DB_Replica_Addr))
+	}
+	if spec.Cache_TTL > 0 {
+		if s.responses, err = newResponseCache(spec.Cache_TTL, spec.Cache_Redis); err != nil {
+			fatal("response cache", "err", err)
+		}
 	}
 
-	s.Init()
+	if *flagMigrate {
+		if err := s.Migrate(ctx); err != nil {
+			fatal("migrate", "err", err)
+		}
+		return
+	}
 
 	if *flagCreateTables {
 		s.CreateTables()
 	}
+	// Init reads tables, such as synonyms, created by migrations.
+	if err := s.checkSchema(ctx); err != nil {
+		fatal("check schema", "err", err)
+	}
+	s.Init(*flagLoadSDE)
+	if *flagCreateAPIKey != "" {
+		token, err := s.CreateAPIKey(*flagCreateAPIKey, *flagAdmin)
+		if err != nil {
+			fatal("create api key", "err", err)
+		}
+		fmt.Println(token)
+		return
+	}
 
-	ctx := context.Background()
-
+	if *flagBackfill {
+		from, err := time.Parse(time.DateOnly, *flagFrom)
+		if err != nil {
+			fatal("backfill: -from", "err", err)
+		}
+		to := from
+		if *flagTo != "" {
+			if to, err = time.Parse(time.DateOnly, *flagTo); err != nil {
+				fatal("backfill: -to", "err", err)
+			}
+		}
+		if err := s.Backfill(ctx, from, to, int32(*flagShip)); err != nil {
+			fatal("backfill", "err", err)
+		}
+		return
+	}
+	if *flagRedisQ || *flagZKBWebsocket {
+		// Both listeners may run, feeding the same pipeline.
+		var wg sync.WaitGroup
+		for _, l := range []struct {
+			enabled bool
+			listen  func(context.Context)
+		}{
+			{*flagRedisQ, s.ListenRedisQ},
+			{*flagZKBWebsocket, s.ListenZKBWebsocket},
+		} {
+			if !l.enabled {
+				continue
+			}
+			wg.Add(1)
+			go func(listen func(context.Context)) {
+				defer wg.Done()
+				listen(ctx)
+			}(l.listen)
+		}
+		wg.Wait()
+		return
+	}
 	if *flagSync {
-		go s.FetchHashes(ctx)
-		go s.ProcessFits(ctx)
-		fmt.Println("running sync")
-		select {}
+		wg := s.runSync(ctx, s.syncJobs())
+		slog.Info("running sync")
+		<-ctx.Done()
+		slog.Info("stopping sync")
+		waitTimeout(wg, spec.Drain_Timeout)
+		return
 	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/api/Fit", s.Wrap(s.Fit))
-	mux.Handle("/api/Fits", s.Wrap(s.Fits))
-	mux.Handle("/api/Search", s.Wrap(s.Search))
+	for _, v := range apiVersions {
+		for _, route := range s.apiRoutes() {
+			h := route.Handler
+			if s.responses != nil && !route.Uncached {
+				h = s.responses.cached(h, s.timeout(route))
+			}
+			route.Handler = h
+			mux.Handle(v.Prefix+route.Name, s.WrapVersion(v, route))
+		}
+	}
+	for _, route := range s.adminRoutes() {
+		route.Handler = s.requireAdmin(route.Handler)
+		mux.Handle(apiV1.Prefix+"admin/"+route.Name, s.WrapVersion(apiV1, route))
+	}
+	mux.Handle("/openapi.json", s.Wrap(s.OpenAPI))
+	mux.Handle("/oembed", s.Wrap(s.OEmbed))
+	mux.Handle("/version", s.Wrap(s.Version))
+	mux.Handle("/card/", s.Wrap(s.Card))
+	mux.HandleFunc("/icon/", s.Icon)
+	mux.Handle("/ws/live", s.LiveWS())
+	mux.HandleFunc("/events", s.Events)
 	mux.HandleFunc("/api/Sync", s.Sync)
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/sso/login", s.SSOLogin)
+	mux.HandleFunc("/sso/callback", s.SSOCallback)
+	mux.HandleFunc("/sso/logout", s.SSOLogout)
+	mux.HandleFunc("/healthz", s.Healthz)
+	mux.HandleFunc("/readyz", s.Readyz)
+
+	var grpcServer *grpc.Server
+	if spec.GRPC_Port != "" {
+		grpcServer = s.serveGRPC(spec.GRPC_Port)
+	}
+	if spec.Admin_Port != "" {
+		go s.serveAdmin(spec.Admin_Port)
+	}
+
+	srv := &http.Server{Addr: spec.Port, Handler: mux}
+	tls, redirect := configureTLS(spec, srv)
+	go func() {
+		slog.Info("HTTP listening", "addr", spec.Port, "tls", tls)
+		var err error
+		if tls {
+			err = srv.ListenAndServeTLS(spec.TLS_Cert, spec.TLS_Key)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			fatal("HTTP serve", "err", err)
+		}
+	}()
+	if redirect != nil {
+		go func() {
+			slog.Info("HTTP redirect listening", "addr", redirect.Addr)
+			if err := redirect.ListenAndServe(); err != http.ErrServerClosed {
+				fatal("HTTP redirect serve", "err", err)
+			}
+		}()
+	}
 
-	fmt.Println("HTTP listen on addr:", spec.Port)
-	log.Fatal(http.ListenAndServe(spec.Port, mux))
+	<-ctx.Done()
+	slog.Info("shutting down", "drain", spec.Drain_Timeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), spec.Drain_Timeout)
+	defer cancel()
+	if redirect != nil {
+		redirect.Close()
+	}
+	// The servers drain concurrently, sharing the drain timeout.
+	var wg sync.WaitGroup
+	if grpcServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-drainCtx.Done():
+				grpcServer.Stop()
+			}
+		}()
+	}
+	if err := srv.Shutdown(drainCtx); err != nil {
+		slog.Error("HTTP shutdown", "err", err)
+	}
+	wg.Wait()
 }
 
-func (s *EFContext) Init() {
-	const globalKey = "global"
+// waitTimeout waits for wg, giving up after d.
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		slog.Warn("drain timeout exceeded")
+	}
+}
 
+// Init loads the static data, reading it from the SDE and storing it if the
+// database doesn't have it yet or fromSDE is set, and builds the derived
+// indexes.
+func (s *EFContext) Init(fromSDE bool) {
+	ctx := context.Background()
 	if _, err := s.DB.Exec(`CREATE TABLE IF NOT EXISTS config (key string primary key, val bytes)`); err != nil {
 		panic(err)
 	}
 
-	var raw []byte
-	if err := s.DB.QueryRow(`SELECT val FROM config WHERE key = $1`, globalKey).Scan(&raw); err == sql.ErrNoRows {
-		{
-			fmt.Println("reading groupIDs.yaml")
-			r, err := os.Open("sde/fsd/groupIDs.yaml")
-			if err != nil {
-				panic(err)
-			}
-			defer r.Close()
-			var yml map[int32]struct {
-				CategoryID int32 `yaml:"categoryID"`
-				Name       map[string]string
-			}
-			if err := yaml.NewDecoder(r).Decode(&yml); err != nil {
-				panic(err)
-			}
-			s.Global.Groups = map[int32]Group{}
-			for id, m := range yml {
-				g := Group{
-					ID:       id,
-					Name:     m.Name["en"],
-					Category: m.CategoryID,
-				}
-				if !g.IsKnown() {
-					continue
-				}
-				s.Global.Groups[id] = g
-			}
-		}
-		{
-			fmt.Println("reading types.yaml")
-			r, err := os.Open("sde/fsd/typeIDs.yaml")
-			if err != nil {
-				panic(err)
-			}
-			defer r.Close()
-			var yml map[int32]struct {
-				GroupID int32 `yaml:"groupID"`
-				Name    map[string]string
-			}
-			if err := yaml.NewDecoder(r).Decode(&yml); err != nil {
-				panic(err)
-			}
-			s.Global.Items = map[int32]Item{}
-			for id, m := range yml {
-				if _, ok := s.Global.Groups[m.GroupID]; !ok {
-					continue
-				}
-				s.Global.Items[id] = Item{
-					ID:    id,
-					Group: m.GroupID,
-					Name:  m.Name["en"],
-					Lower: strings.ToLower(m.Name["en"]),
-				}
-			}
-		}
-		var b bytes.Buffer
-		if err := gob.NewEncoder(&b).Encode(s.Global); err != nil {
+	var g *staticData
+	var err error
+	if !fromSDE {
+		if g, err = s.loadStaticData(ctx); err != nil {
 			panic(err)
 		}
-		if _, err := s.DB.Exec(`UPSERT INTO config (key, val) VALUES ($1, $2)`, globalKey, b.Bytes()); err != nil {
+	}
+	if g == nil {
+		if g, err = loadSDE(s.sdeDir, s.sdeFormat); err != nil {
 			panic(err)
 		}
-		fmt.Println("config update")
-	} else if err != nil {
-		panic(err)
-	} else {
-		if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&s.Global); err != nil {
+		if err := s.storeStaticData(ctx, g); err != nil {
 			panic(err)
 		}
+		slog.Info("config update")
+	}
+
+	if err := s.setGlobal(ctx, g); err != nil {
+		panic(err)
 	}
+	s.initGraphQL()
 }
 
 type EFContext struct {
 	DB *sql.DB
 	X  *sqlx.DB
+	// replica, if set, serves the reads of read-only requests.
+	replica *replica
 
-	Global struct {
-		Items  map[int32]Item
-		Groups map[int32]Group
-	}
+	fotd       fotdCache
+	search     atomic.Pointer[searchIndex]
+	popularity popularity
+	graphql    *graphql.Schema
+	live       liveHub
+	ingest     ingestStats
+	// stopping is canceled when the server starts shutting down.
+	stopping  context.Context
+	iconCache string
+	// iconFetches shares concurrent fetches of an uncached image.
+	iconFetches singleflight.Group
+	sdeDir      string
+	sdeFormat   string
+	sdeCheck    sdeCheck
+	// ingestSkip are the names of the ingestRules applied by ProcessFits.
+	ingestSkip  []string
+	redisqQueue string
+	zkbChannels []string
+	workers     int
+	workerPace  time.Duration
+	syncSecret  string
+	// retention, in months, and archiveDir configure Archive.
+	retention  int
+	archiveDir string
+	limiter    *rateLimiter
+	responses  *responseCache
+	cors       corsPolicy
+	// sso, if set, logs in characters with EVE SSO. ssoTokens encrypts
+	// characters' tokens at rest, and ssoRefresh shares concurrent
+	// refreshes of a character's token.
+	sso        *oauth2.Config
+	ssoTokens  cipher.AEAD
+	ssoRefresh singleflight.Group
+	// requestTimeout is the default timeout of API handlers, overridden by
+	// routeTimeouts by route name.
+	requestTimeout time.Duration
+	routeTimeouts  map[string]time.Duration
+	encodings      encodingCache
+	apiKeys        apiKeyCache
+
+	// global holds the current static data.
+	global atomic.Pointer[staticData]
 }
 
 type Group struct {
@@ -187,6 +434,9 @@ func (g Group) IsKnown() bool {
 		g.IsModule,
 		g.IsShip,
 		g.IsSubsystem,
+		g.IsStructure,
+		g.IsStructureModule,
+		g.IsFighter,
 	} {
 		if f() {
 			return true
@@ -211,6 +461,18 @@ func (g Group) IsSubsystem() bool {
 	return g.Category == 32
 }
 
+func (g Group) IsStructure() bool {
+	return g.Category == 65
+}
+
+func (g Group) IsStructureModule() bool {
+	return g.Category == 66
+}
+
+func (g Group) IsFighter() bool {
+	return g.Category == 87
+}
+
 type Item struct {
 	ID    int32  `json:",omitempty"`
 	Name  string `json:",omitempty"`
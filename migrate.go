@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/cockroach-go/crdb"
+	"github.com/pkg/errors"
+)
+
+// migrationFS holds the schema migrations, named NNNN_description.sql and
+// applied in order of NNNN. Applied migrations must not be edited; schema
+// changes go in a new file. Each migration runs in one transaction with the
+// recording of its version, so it is applied entirely or not at all. Cockroach
+// cannot use a column added earlier in the same transaction, so adding a
+// column and filling it are separate migrations.
+//
+// A migration starting with a "-- batch: table.column" line is a single
+// statement filling rows whose integer column is in [$1, $2). It is run over
+// the column's whole range in batches of migrationBatchSize, each its own
+// transaction, and so must skip rows an earlier, interrupted run filled.
+//
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// migrationBatchSize is the width of the column range of a batch of a
+// batched migration.
+const migrationBatchSize = 10000
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+	// batchTable and batchColumn are set for batched migrations.
+	batchTable, batchColumn string
+}
+
+// migrations returns the embedded migrations in version order.
+func migrations() ([]migration, error) {
+	files, err := migrationFS.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	var ms []migration
+	for _, f := range files {
+		prefix, _, _ := strings.Cut(f.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, errors.Errorf("migration %s: bad version", f.Name())
+		}
+		b, err := migrationFS.ReadFile(path.Join("migrations", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := migration{version: version, name: f.Name(), sql: string(b)}
+		if first, _, _ := strings.Cut(m.sql, "\n"); strings.HasPrefix(first, "-- batch: ") {
+			var ok bool
+			m.batchTable, m.batchColumn, ok = strings.Cut(strings.TrimPrefix(first, "-- batch: "), ".")
+			if !ok {
+				return nil, errors.Errorf("migration %s: batch must be table.column", f.Name())
+			}
+		}
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].version < ms[j].version })
+	return ms, nil
+}
+
+// schemaVersion returns the version of the last applied migration, or 0.
+func (s *EFContext) schemaVersion(ctx context.Context) (int, error) {
+	if _, err := s.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT4 PRIMARY KEY,
+			applied TIMESTAMP DEFAULT now() NOT NULL
+		)
+	`); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := s.DB.QueryRowContext(ctx, `SELECT max(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// Migrate applies the migrations newer than the database's schema version.
+func (s *EFContext) Migrate(ctx context.Context) error {
+	ms, err := migrations()
+	if err != nil {
+		return err
+	}
+	current, err := s.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range ms {
+		if m.version <= current {
+			continue
+		}
+		if m.batchTable != "" {
+			if err := s.migrateBatches(ctx, m); err != nil {
+				return errors.Wrap(err, m.name)
+			}
+		}
+		if err := crdb.ExecuteTx(ctx, s.DB, nil, func(tx *sql.Tx) error {
+			if m.batchTable == "" {
+				if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, m.version)
+			return err
+		}); err != nil {
+			return errors.Wrap(err, m.name)
+		}
+		slog.Info("applied migration", "migration", m.name)
+	}
+	return nil
+}
+
+// migrateBatches runs batched migration m over the range of its column.
+func (s *EFContext) migrateBatches(ctx context.Context, m migration) error {
+	var lo, hi sql.NullInt64
+	if err := s.DB.QueryRowContext(ctx, fmt.Sprintf(`SELECT min(%[1]s), max(%[1]s) FROM %[2]s`, m.batchColumn, m.batchTable)).Scan(&lo, &hi); err != nil {
+		return err
+	}
+	if !lo.Valid {
+		return nil
+	}
+	for start := lo.Int64; start <= hi.Int64; start += migrationBatchSize {
+		res, err := s.DB.ExecContext(ctx, m.sql, start, start+migrationBatchSize)
+		if err != nil {
+			return errors.Wrapf(err, "batch from %d", start)
+		}
+		n, _ := res.RowsAffected()
+		slog.Debug("migration batch", "migration", m.name, "from", start, "rows", n)
+	}
+	return nil
+}
+
+// checkSchema returns an error if the database has not had every embedded
+// migration applied.
+func (s *EFContext) checkSchema(ctx context.Context) error {
+	ms, err := migrations()
+	if err != nil {
+		return err
+	}
+	current, err := s.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if latest := ms[len(ms)-1].version; current < latest {
+		return errors.Errorf("schema version %d is older than %d; run with -migrate", current, latest)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+-- The schema as created by -create-tables before migrations. Databases
+-- created that way are adopted at this version; the columns and tables added
+-- to -create-tables since are added by the following migrations.
+
+CREATE TABLE IF NOT EXISTS config (key STRING PRIMARY KEY, val BYTES);
+
+CREATE TABLE IF NOT EXISTS hashes (
+	id        INT4 PRIMARY KEY,
+	hash      STRING NOT NULL,
+	processed INT4 DEFAULT 0 NOT NULL,
+	INDEX (processed)
+);
+
+CREATE TABLE IF NOT EXISTS killmails (
+	id        INT4 PRIMARY KEY,
+	km        JSONB NOT NULL,
+	zkb JSONB NOT NULL,
+	processed INT4 DEFAULT 0 NOT NULL,
+	INDEX (processed)
+);
+
+CREATE TABLE IF NOT EXISTS fits (
+	killmail    INT4,
+	ship        INT4 NOT NULL,
+	cost        INT8,
+	solarsystem INT4 NOT NULL,
+	hi          JSONB NOT NULL,
+	med         JSONB NOT NULL,
+	low         JSONB NOT NULL,
+	rig         JSONB NOT NULL,
+	sub         JSONB NOT NULL,
+	items       JSONB NOT NULL,
+	PRIMARY KEY (killmail DESC),
+	INVERTED INDEX (items)
+);
@@ -0,0 +1,117 @@
+-- Columns and tables added to -create-tables after the baseline schema, for
+-- databases adopted at version 1.
+
+-- processed_at is when the fit was last stored, used for conditional
+-- requests.
+ALTER TABLE killmails ADD COLUMN IF NOT EXISTS processed_at TIMESTAMP;
+
+-- killed is the killmail's time, so ranges of old killmails can be scanned
+-- without touching recent ones.
+ALTER TABLE killmails ADD COLUMN IF NOT EXISTS killed TIMESTAMP;
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS character INT4;
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS corporation INT4;
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS alliance INT4;
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS category INT4 DEFAULT 6 NOT NULL;
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS npc BOOL DEFAULT false NOT NULL;
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS solo BOOL DEFAULT false NOT NULL;
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS awox BOOL DEFAULT false NOT NULL;
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS location INT4;
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS dropped INT8;
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS points INT4;
+
+CREATE TABLE IF NOT EXISTS reports (
+	generated TIMESTAMP PRIMARY KEY,
+	report    JSONB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS fotd (
+	day      DATE PRIMARY KEY,
+	killmail INT4 NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS popularity (
+	id      INT4 PRIMARY KEY,
+	fits    INT8 NOT NULL,
+	updated TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS names (
+	id       INT4 PRIMARY KEY,
+	category STRING,
+	name     STRING,
+	INDEX (name) WHERE name IS NULL,
+	INVERTED INDEX (name gin_trgm_ops)
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id       INT8 DEFAULT unique_rowid() PRIMARY KEY,
+	url      STRING NOT NULL,
+	secret   STRING NOT NULL,
+	ship     INT4,
+	item     INT4,
+	alliance INT4,
+	created  TIMESTAMP DEFAULT now() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	webhook      INT8 NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+	killmail     INT4 NOT NULL,
+	attempts     INT4 DEFAULT 0 NOT NULL,
+	next_attempt TIMESTAMP DEFAULT now() NOT NULL,
+	delivered    TIMESTAMP,
+	last_error   STRING,
+	PRIMARY KEY (webhook, killmail),
+	INDEX (next_attempt) WHERE delivered IS NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id         INT8 DEFAULT unique_rowid() PRIMARY KEY,
+	name       STRING NOT NULL,
+	hash       STRING NOT NULL UNIQUE,
+	rate_limit FLOAT8 DEFAULT 50 NOT NULL,
+	burst      INT4 DEFAULT 200 NOT NULL,
+	bulk       BOOL DEFAULT true NOT NULL,
+	admin      BOOL DEFAULT false NOT NULL,
+	created    TIMESTAMP DEFAULT now() NOT NULL,
+	revoked    TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS notifiers (
+	id            INT8 DEFAULT unique_rowid() PRIMARY KEY,
+	kind          STRING NOT NULL,
+	url           STRING NOT NULL,
+	secret        STRING NOT NULL,
+	filter        STRING NOT NULL,
+	last_killmail INT4,
+	created       TIMESTAMP DEFAULT now() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS checkpoints (
+	source   STRING PRIMARY KEY,
+	position STRING NOT NULL,
+	updated  TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS dead_letter (
+	killmail INT4 PRIMARY KEY,
+	error    STRING NOT NULL,
+	attempts INT4 DEFAULT 1 NOT NULL,
+	failed   TIMESTAMP DEFAULT now() NOT NULL,
+	INDEX (failed DESC)
+);
+
+CREATE TABLE IF NOT EXISTS prices (
+	id      INT4 PRIMARY KEY,
+	price   FLOAT8 NOT NULL,
+	updated TIMESTAMP NOT NULL
+);
@@ -0,0 +1,14 @@
+-- Indexes over the columns of the previous migration, which can't be used in
+-- the transaction adding them.
+
+CREATE INDEX IF NOT EXISTS killmails_killed_idx ON killmails (killed);
+
+CREATE INDEX IF NOT EXISTS fits_character_killmail_idx ON fits (character, killmail DESC);
+
+CREATE INDEX IF NOT EXISTS fits_corporation_killmail_idx ON fits (corporation, killmail DESC);
+
+CREATE INDEX IF NOT EXISTS fits_alliance_killmail_idx ON fits (alliance, killmail DESC);
+
+CREATE INDEX IF NOT EXISTS fits_category_killmail_idx ON fits (category, killmail DESC);
+
+CREATE INDEX IF NOT EXISTS fits_location_killmail_idx ON fits (location, killmail DESC);
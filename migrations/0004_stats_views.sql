@@ -0,0 +1,35 @@
+-- Hull module, ship usage, and doctrine stats, refreshed by RefreshViews.
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS hull_modules AS
+	SELECT
+		f.ship, i.value::INT4 AS item, count(DISTINCT f.killmail) AS fits
+	FROM
+		fits AS f JOIN killmails AS k ON k.id = f.killmail,
+		jsonb_array_elements_text(f.items) AS i
+	WHERE
+		k.killed > now() - INTERVAL '30 days'
+		AND i.value::INT4 != f.ship
+	GROUP BY
+		f.ship, item;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS ship_usage AS
+	SELECT
+		date_trunc('week', k.killed) AS week, f.ship, count(*) AS fits
+	FROM
+		fits AS f JOIN killmails AS k ON k.id = f.killmail
+	WHERE
+		k.killed > now() - INTERVAL '84 days'
+	GROUP BY
+		week, f.ship;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS doctrines AS
+	SELECT
+		f.ship, f.hi, f.med, f.low, f.rig, f.sub, count(*) AS fits, max(f.killmail) AS killmail
+	FROM
+		fits AS f JOIN killmails AS k ON k.id = f.killmail
+	WHERE
+		k.killed > now() - INTERVAL '30 days'
+	GROUP BY
+		f.ship, f.hi, f.med, f.low, f.rig, f.sub
+	HAVING
+		count(*) >= 5;
@@ -0,0 +1,7 @@
+-- Store fit items as an INT4[] with an inverted index, which containment and
+-- overlap filters use faster than JSONB containment. The array is filled by
+-- the next migration and replaces items after it.
+
+DROP MATERIALIZED VIEW IF EXISTS hull_modules;
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS item_ids INT4[];
@@ -0,0 +1,7 @@
+-- batch: fits.killmail
+UPDATE
+	fits
+SET
+	item_ids = ARRAY(SELECT jsonb_array_elements_text(items)::INT4)
+WHERE
+	killmail >= $1 AND killmail < $2 AND item_ids IS NULL;
@@ -0,0 +1 @@
+ALTER TABLE fits DROP COLUMN IF EXISTS items;
@@ -0,0 +1,5 @@
+ALTER TABLE fits RENAME COLUMN item_ids TO items;
+
+ALTER TABLE fits ALTER COLUMN items SET NOT NULL;
+
+CREATE INVERTED INDEX IF NOT EXISTS fits_items_idx ON fits (items);
@@ -0,0 +1,11 @@
+CREATE MATERIALIZED VIEW IF NOT EXISTS hull_modules AS
+	SELECT
+		f.ship, item, count(DISTINCT f.killmail) AS fits
+	FROM
+		fits AS f JOIN killmails AS k ON k.id = f.killmail,
+		unnest(f.items) AS item
+	WHERE
+		k.killed > now() - INTERVAL '30 days'
+		AND item != f.ship
+	GROUP BY
+		f.ship, item;
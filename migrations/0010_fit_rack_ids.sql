@@ -0,0 +1,15 @@
+-- Store fit racks as INT4[] so listing fits scans them without decoding
+-- JSON. The arrays are filled by the next migration and replace the racks
+-- after it.
+
+DROP MATERIALIZED VIEW IF EXISTS doctrines;
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS hi_ids INT4[];
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS med_ids INT4[];
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS low_ids INT4[];
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS rig_ids INT4[];
+
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS sub_ids INT4[];
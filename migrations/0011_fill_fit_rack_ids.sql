@@ -0,0 +1,12 @@
+-- batch: fits.killmail
+-- Empty racks were stored as JSON null.
+UPDATE
+	fits
+SET
+	hi_ids = CASE WHEN jsonb_typeof(hi) = 'array' THEN ARRAY(SELECT jsonb_array_elements_text(hi)::INT4) ELSE ARRAY[]::INT4[] END,
+	med_ids = CASE WHEN jsonb_typeof(med) = 'array' THEN ARRAY(SELECT jsonb_array_elements_text(med)::INT4) ELSE ARRAY[]::INT4[] END,
+	low_ids = CASE WHEN jsonb_typeof(low) = 'array' THEN ARRAY(SELECT jsonb_array_elements_text(low)::INT4) ELSE ARRAY[]::INT4[] END,
+	rig_ids = CASE WHEN jsonb_typeof(rig) = 'array' THEN ARRAY(SELECT jsonb_array_elements_text(rig)::INT4) ELSE ARRAY[]::INT4[] END,
+	sub_ids = CASE WHEN jsonb_typeof(sub) = 'array' THEN ARRAY(SELECT jsonb_array_elements_text(sub)::INT4) ELSE ARRAY[]::INT4[] END
+WHERE
+	killmail >= $1 AND killmail < $2 AND hi_ids IS NULL;
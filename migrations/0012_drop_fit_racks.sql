@@ -0,0 +1,9 @@
+ALTER TABLE fits DROP COLUMN IF EXISTS hi;
+
+ALTER TABLE fits DROP COLUMN IF EXISTS med;
+
+ALTER TABLE fits DROP COLUMN IF EXISTS low;
+
+ALTER TABLE fits DROP COLUMN IF EXISTS rig;
+
+ALTER TABLE fits DROP COLUMN IF EXISTS sub;
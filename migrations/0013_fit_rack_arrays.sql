@@ -0,0 +1,19 @@
+ALTER TABLE fits RENAME COLUMN hi_ids TO hi;
+
+ALTER TABLE fits ALTER COLUMN hi SET NOT NULL;
+
+ALTER TABLE fits RENAME COLUMN med_ids TO med;
+
+ALTER TABLE fits ALTER COLUMN med SET NOT NULL;
+
+ALTER TABLE fits RENAME COLUMN low_ids TO low;
+
+ALTER TABLE fits ALTER COLUMN low SET NOT NULL;
+
+ALTER TABLE fits RENAME COLUMN rig_ids TO rig;
+
+ALTER TABLE fits ALTER COLUMN rig SET NOT NULL;
+
+ALTER TABLE fits RENAME COLUMN sub_ids TO sub;
+
+ALTER TABLE fits ALTER COLUMN sub SET NOT NULL;
@@ -0,0 +1,11 @@
+CREATE MATERIALIZED VIEW IF NOT EXISTS doctrines AS
+	SELECT
+		f.ship, f.hi, f.med, f.low, f.rig, f.sub, count(*) AS fits, max(f.killmail) AS killmail
+	FROM
+		fits AS f JOIN killmails AS k ON k.id = f.killmail
+	WHERE
+		k.killed > now() - INTERVAL '30 days'
+	GROUP BY
+		f.ship, f.hi, f.med, f.low, f.rig, f.sub
+	HAVING
+		count(*) >= 5;
@@ -0,0 +1,24 @@
+-- Characters logged in with EVE SSO and their browser sessions.
+
+CREATE TABLE IF NOT EXISTS characters (
+	id            INT4 PRIMARY KEY,
+	name          STRING NOT NULL,
+	-- owner_hash changes when the character is transferred to
+	-- another account, which ends its sessions.
+	owner_hash    STRING NOT NULL,
+	scopes        STRING[] NOT NULL,
+	access_token  STRING NOT NULL,
+	refresh_token STRING NOT NULL,
+	token_expiry  TIMESTAMP NOT NULL,
+	created       TIMESTAMP DEFAULT now() NOT NULL,
+	updated       TIMESTAMP DEFAULT now() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	hash      STRING PRIMARY KEY,
+	character INT4 NOT NULL REFERENCES characters (id) ON DELETE CASCADE,
+	created   TIMESTAMP DEFAULT now() NOT NULL,
+	expires   TIMESTAMP NOT NULL,
+	INDEX (character),
+	INDEX (expires)
+);
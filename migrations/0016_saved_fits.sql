@@ -0,0 +1,11 @@
+-- Fits bookmarked by logged in characters. Reprocessing updates fits in
+-- place, but fits may still be archived or, if they no longer qualify,
+-- deleted, so killmail doesn't reference them.
+
+CREATE TABLE IF NOT EXISTS saved_fits (
+	character INT4 NOT NULL REFERENCES characters (id) ON DELETE CASCADE,
+	killmail  INT4 NOT NULL,
+	saved     TIMESTAMP DEFAULT now() NOT NULL,
+	PRIMARY KEY (character, killmail),
+	INDEX (character, saved DESC)
+);
@@ -0,0 +1,16 @@
+-- Votes of logged in characters on fits, and the resulting score of each
+-- voted fit, kept apart from fits so it survives reprocessing.
+
+CREATE TABLE IF NOT EXISTS fit_votes (
+	killmail  INT4 NOT NULL,
+	character INT4 NOT NULL REFERENCES characters (id) ON DELETE CASCADE,
+	vote      INT2 NOT NULL CHECK (vote IN (-1, 1)),
+	voted     TIMESTAMP DEFAULT now() NOT NULL,
+	PRIMARY KEY (killmail, character)
+);
+
+CREATE TABLE IF NOT EXISTS fit_scores (
+	killmail INT4 PRIMARY KEY,
+	score    INT4 NOT NULL,
+	INDEX (score DESC, killmail DESC)
+);
@@ -0,0 +1,15 @@
+-- Comments of logged in characters on fits. Deleted comments are kept,
+-- hidden, so moderators can review and restore them.
+
+CREATE TABLE IF NOT EXISTS fit_comments (
+	id         INT8 DEFAULT unique_rowid() PRIMARY KEY,
+	killmail   INT4 NOT NULL,
+	character  INT4 NOT NULL REFERENCES characters (id) ON DELETE CASCADE,
+	body       STRING NOT NULL,
+	created    TIMESTAMP DEFAULT now() NOT NULL,
+	deleted    TIMESTAMP,
+	-- deleted_by is the author, or the admin API key of the moderator.
+	deleted_by STRING,
+	INDEX (killmail, created),
+	INDEX (character, created)
+);
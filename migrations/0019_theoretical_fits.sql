@@ -0,0 +1,17 @@
+-- Fits submitted as EFT by logged in characters rather than taken from
+-- killmails.
+
+CREATE TABLE IF NOT EXISTS theoretical_fits (
+	id        INT8 DEFAULT unique_rowid() PRIMARY KEY,
+	character INT4 NOT NULL REFERENCES characters (id) ON DELETE CASCADE,
+	name      STRING NOT NULL,
+	ship      INT4 NOT NULL,
+	-- items are the ship, modules, and charges, as in fits.
+	items     INT4[] NOT NULL,
+	-- eft is the fit as submitted. Racks are parsed from it when read so
+	-- they follow SDE updates.
+	eft       STRING NOT NULL,
+	created   TIMESTAMP DEFAULT now() NOT NULL,
+	INDEX (character, created),
+	INVERTED INDEX (items)
+);
@@ -0,0 +1,35 @@
+-- Named lists of fits kept by logged in characters, visible to their
+-- corporation and, with its share token, anyone.
+
+ALTER TABLE characters ADD COLUMN IF NOT EXISTS corporation INT4;
+
+ALTER TABLE characters ADD COLUMN IF NOT EXISTS alliance INT4;
+
+-- affiliated is when corporation and alliance were checked with ESI.
+ALTER TABLE characters ADD COLUMN IF NOT EXISTS affiliated TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS doctrine_lists (
+	id          INT8 DEFAULT unique_rowid() PRIMARY KEY,
+	owner       INT4 NOT NULL REFERENCES characters (id) ON DELETE CASCADE,
+	name        STRING NOT NULL,
+	-- corporation is the owner's corporation when the list was created,
+	-- whose members may view it.
+	corporation INT4 NOT NULL,
+	share_token STRING UNIQUE,
+	created     TIMESTAMP DEFAULT now() NOT NULL,
+	updated     TIMESTAMP DEFAULT now() NOT NULL,
+	INDEX (owner),
+	INDEX (corporation)
+);
+
+CREATE TABLE IF NOT EXISTS doctrine_list_fits (
+	id          INT8 DEFAULT unique_rowid() PRIMARY KEY,
+	list        INT8 NOT NULL REFERENCES doctrine_lists (id) ON DELETE CASCADE,
+	-- Each entry is a killmail fit or a theoretical fit.
+	killmail    INT4,
+	theoretical INT8 REFERENCES theoretical_fits (id) ON DELETE CASCADE,
+	note        STRING NOT NULL,
+	added       TIMESTAMP DEFAULT now() NOT NULL,
+	CHECK ((killmail IS NULL) != (theoretical IS NULL)),
+	INDEX (list, added)
+);
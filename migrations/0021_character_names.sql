@@ -0,0 +1,2 @@
+-- Characters are looked up by name, ignoring case, for pilot pages.
+CREATE INDEX IF NOT EXISTS names_character_name ON names (lower(name)) WHERE category = 'character';
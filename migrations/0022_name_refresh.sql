@@ -0,0 +1,5 @@
+-- resolved is when a name was last looked up with ESI, so stale names can
+-- be refreshed. Names resolved before it was added are refreshed first.
+ALTER TABLE names ADD COLUMN IF NOT EXISTS resolved TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS names_resolved ON names (resolved) WHERE name IS NOT NULL;
@@ -0,0 +1,9 @@
+-- batch: killmails.id
+-- Killmails stored before killed was added have it set from their ESI time,
+-- so archiving, stats views, cost recomputation, and loss totals see them.
+UPDATE
+	killmails
+SET
+	killed = (km->>'killmail_time')::TIMESTAMP
+WHERE
+	id >= $1 AND id < $2 AND killed IS NULL;
@@ -0,0 +1,2 @@
+-- attempts counts failed fetches of a hash's killmail from ESI.
+ALTER TABLE hashes ADD COLUMN IF NOT EXISTS attempts INT4 DEFAULT 0 NOT NULL;
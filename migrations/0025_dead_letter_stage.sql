@@ -0,0 +1,2 @@
+-- stage is where the killmail failed: fetching it from ESI or processing it.
+ALTER TABLE dead_letter ADD COLUMN IF NOT EXISTS stage STRING DEFAULT 'process' NOT NULL;
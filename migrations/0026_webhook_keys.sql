@@ -0,0 +1,4 @@
+-- api_key is the API key that registered the webhook, for capping
+-- registrations per key.
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS api_key INT8;
+CREATE INDEX IF NOT EXISTS webhooks_api_key_idx ON webhooks (api_key);
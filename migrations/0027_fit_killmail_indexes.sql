@@ -0,0 +1,3 @@
+-- Archive deletes saved and listed fits by killmail.
+CREATE INDEX IF NOT EXISTS saved_fits_killmail_idx ON saved_fits (killmail);
+CREATE INDEX IF NOT EXISTS doctrine_list_fits_killmail_idx ON doctrine_list_fits (killmail);
@@ -0,0 +1,3 @@
+-- Solar system names come from the static data's universe map, so systems
+-- are no longer resolved with ESI. System IDs are 30000000 to 32999999.
+DELETE FROM names WHERE category = 'solar_system' OR (category IS NULL AND id BETWEEN 30000000 AND 32999999);
@@ -0,0 +1,4 @@
+-- alliance is set for lists that members of the owner's alliance may also
+-- view, to the owner's alliance when it was set.
+ALTER TABLE doctrine_lists ADD COLUMN IF NOT EXISTS alliance INT4;
+CREATE INDEX IF NOT EXISTS doctrine_lists_alliance_idx ON doctrine_lists (alliance) WHERE alliance IS NOT NULL;
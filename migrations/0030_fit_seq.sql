@@ -0,0 +1,5 @@
+-- seq orders fits by when they were first stored, for resuming the event
+-- stream. Fits stored before it was added have none.
+CREATE SEQUENCE IF NOT EXISTS fits_seq;
+ALTER TABLE fits ADD COLUMN IF NOT EXISTS seq INT8;
+CREATE INDEX IF NOT EXISTS fits_seq_idx ON fits (seq) STORING (ship, cost, items) WHERE seq IS NOT NULL;
@@ -0,0 +1,5 @@
+-- last_processed and last_killmail are the processed_at and killmail of the
+-- last fit a notifier posted, so fits stored out of killmail order aren't
+-- skipped.
+ALTER TABLE notifiers ADD COLUMN IF NOT EXISTS last_processed TIMESTAMP;
+CREATE INDEX IF NOT EXISTS killmails_processed_at_idx ON killmails (processed_at, id);
@@ -0,0 +1,39 @@
+-- Abbreviations expanded in searches, seeded with common ones. Rows may be
+-- edited in the database; RebuildSearch reloads them.
+CREATE TABLE IF NOT EXISTS synonyms (
+	abbr      STRING PRIMARY KEY,
+	expansion STRING NOT NULL
+);
+
+INSERT
+INTO
+	synonyms (abbr, expansion)
+VALUES
+	('aar', 'ancillary armor repairer'),
+	('ab', 'afterburner'),
+	('asb', 'ancillary shield booster'),
+	('bcs', 'ballistic control system'),
+	('dc', 'damage control'),
+	('dcu', 'damage control'),
+	('dda', 'drone damage amplifier'),
+	('eanm', 'energized adaptive nano membrane'),
+	('hs', 'heat sink'),
+	('lar', 'large armor repairer'),
+	('lse', 'large shield extender'),
+	('mar', 'medium armor repairer'),
+	('mfs', 'magnetic field stabilizer'),
+	('mjd', 'micro jump drive'),
+	('mse', 'medium shield extender'),
+	('mwd', 'microwarpdrive'),
+	('neut', 'energy neutralizer'),
+	('nos', 'energy nosferatu'),
+	('sar', 'small armor repairer'),
+	('scram', 'warp scrambler'),
+	('sebo', 'sensor booster'),
+	('tc', 'tracking computer'),
+	('te', 'tracking enhancer'),
+	('web', 'stasis webifier')
+ON CONFLICT
+	(abbr)
+DO
+	NOTHING;
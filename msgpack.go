@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const msgpackContentType = "application/msgpack"
+
+// wantsMsgpack reports whether the client's Accept header requests
+// MessagePack.
+func wantsMsgpack(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, msgpackContentType) || strings.Contains(accept, "application/x-msgpack")
+}
+
+// msgpackResult encodes res as MessagePack using the same field names as
+// its JSON encoding.
+func msgpackResult(res interface{}) (rawResult, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(res); err != nil {
+		return rawResult{}, errors.Wrap(err, "msgpack")
+	}
+	return rawResult{contentType: msgpackContentType, data: buf.Bytes()}, nil
+}
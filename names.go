@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxNameIDs is the maximum number of IDs ESI resolves per request.
+const maxNameIDs = 1000
+
+// entityCategories are the ESI name categories stored in the names table.
+var entityCategories = map[string]bool{
+	"alliance":    true,
+	"character":   true,
+	"corporation": true,
+}
+
+// entityCategoryNames are the keys of entityCategories in a fixed order.
+var entityCategoryNames = []string{"alliance", "character", "corporation"}
+
+// entityIDs returns the character, corporation, and alliance IDs of the
+// victim and attackers of km.
+func (k KM) entityIDs() []int32 {
+	var ids []int32
+	add := func(id ...int32) {
+		for _, i := range id {
+			if i > 0 {
+				ids = append(ids, i)
+			}
+		}
+	}
+	v := k.Victim
+	add(v.CharacterId, v.CorporationId, v.AllianceId)
+	for _, a := range k.Attackers {
+		add(a.CharacterId, a.CorporationId, a.AllianceId)
+	}
+	return ids
+}
+
+// nullID returns id, or nil if it is unset.
+func nullID(id int32) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// nameRefreshAge is how old a resolved name is before it is looked up
+// again, as characters, corporations, and alliances can be renamed.
+const nameRefreshAge = time.Hour * 24 * 30
+
+// ResolveNames looks up names for IDs in the names table that don't have
+// one yet using ESI, then refreshes up to maxNameIDs names older than
+// nameRefreshAge.
+func (s *EFContext) ResolveNames(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		var ids []int32
+		if err := s.X.SelectContext(ctx, &ids, `SELECT id FROM names WHERE name IS NULL LIMIT $1`, maxNameIDs); err != nil {
+			slog.Error("resolve names", "err", err)
+			return
+		}
+		if len(ids) == 0 {
+			break
+		}
+		if err := s.resolveNames(ctx, ids, false); err != nil {
+			slog.Error("resolve names", "err", err)
+			return
+		}
+		slog.Info("resolved names", "count", len(ids))
+	}
+
+	var ids []int32
+	if err := s.X.SelectContext(ctx, &ids, `
+		SELECT
+			id
+		FROM
+			names
+		WHERE
+			name IS NOT NULL AND (resolved IS NULL OR resolved < $1)
+		ORDER BY
+			resolved NULLS FIRST
+		LIMIT
+			$2
+	`, time.Now().Add(-nameRefreshAge).UTC(), maxNameIDs); err != nil {
+		slog.Error("refresh names", "err", err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+	if err := s.resolveNames(ctx, ids, true); err != nil {
+		slog.Error("refresh names", "err", err)
+		return
+	}
+	slog.Info("refreshed names", "count", len(ids))
+}
+
+// resolveNames stores the ESI names of ids. IDs ESI didn't return are
+// stored with an empty name so they aren't requested again, unless refresh
+// is set, in which case their known names are kept.
+func (s *EFContext) resolveNames(ctx context.Context, ids []int32, refresh bool) error {
+	names, err := fetchNames(ctx, ids)
+	if err != nil {
+		return err
+	}
+	byID := map[int32]esiName{}
+	for _, n := range names {
+		byID[n.ID] = n
+	}
+	var found []int32
+	var cats, strs []string
+	for _, id := range ids {
+		n, ok := byID[id]
+		if !ok && refresh {
+			continue
+		}
+		found = append(found, id)
+		cats = append(cats, n.Category)
+		strs = append(strs, n.Name)
+	}
+	if _, err := s.DB.ExecContext(ctx, `
+		UPSERT
+		INTO
+			names (id, category, name, resolved)
+		SELECT
+			unnest($1::INT4[]), unnest($2::STRING[]), unnest($3::STRING[]), now()
+	`, found, cats, strs); err != nil {
+		return errors.Wrap(err, "store names")
+	}
+	if refresh {
+		_, err := s.DB.ExecContext(ctx, `UPDATE names SET resolved = now() WHERE id = ANY ($1)`, ids)
+		return errors.Wrap(err, "mark names refreshed")
+	}
+	return nil
+}
+
+type esiName struct {
+	ID       int32  `json:"id"`
+	Category string `json:"category"`
+	Name     string `json:"name"`
+}
+
+// fetchNames resolves ids using ESI's /universe/names/ endpoint.
+func fetchNames(ctx context.Context, ids []int32) ([]esiName, error) {
+	body, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, esiBase+"/universe/names/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := esiDo(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("universe names: %s", resp.Status)
+	}
+	var names []esiName
+	if err := json.NewDecoder(resp.Body).Decode(&names); err != nil {
+		return nil, errors.Wrap(err, "universe names")
+	}
+	return names, nil
+}
+
+// searchNames returns up to limit characters, corporations, and alliances of
+// category typ (or any of them if empty) whose names contain term. Solar
+// systems, also stored in names, aren't searched.
+func (s *EFContext) searchNames(ctx context.Context, term, typ string, limit int) ([]SearchResult, error) {
+	categories := entityCategoryNames
+	if typ != "" {
+		categories = []string{typ}
+	}
+	var ret []SearchResult
+	err := s.read(ctx).SelectContext(ctx, &ret, `
+		SELECT
+			category AS type, name, id
+		FROM
+			names
+		WHERE
+			name ILIKE '%' || $1 || '%'
+			AND category = ANY ($2)
+		ORDER BY
+			name
+		LIMIT
+			$3
+	`, likeEscaper.Replace(term), categories, limit)
+	return ret, err
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, so user input matches
+// literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// entityNames returns the stored names of ids, leaving out unknown ones.
+func (s *EFContext) entityNames(ctx context.Context, ids []int32) (map[int32]string, error) {
+	var rows []struct {
+		ID   int32
+		Name string
+	}
+	if err := s.read(ctx).SelectContext(ctx, &rows, `SELECT id, name FROM names WHERE id = ANY ($1) AND name != ''`, ids); err != nil {
+		return nil, errors.Wrap(err, "names")
+	}
+	ret := make(map[int32]string, len(rows))
+	for _, row := range rows {
+		ret[row.ID] = row.Name
+	}
+	return ret, nil
+}
+
+// characterID returns the ID of the character named name, ignoring case,
+// or 0 if no stored character has the name.
+func (s *EFContext) characterID(ctx context.Context, name string) (int32, error) {
+	var id int32
+	err := s.read(ctx).QueryRowContext(ctx, `
+		SELECT
+			id
+		FROM
+			names
+		WHERE
+			category = 'character' AND lower(name) = lower($1)
+		LIMIT
+			1
+	`, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, errors.Wrap(err, "character name")
+}
+
+// nameVictim sets the stored names of the entities of v.
+func (s *EFContext) nameVictim(ctx context.Context, v *Victim) error {
+	items := []*Item{&v.Character, &v.Corporation, &v.Alliance}
+	var ids []int32
+	for _, item := range items {
+		if item.ID != 0 && item.Name == "" {
+			ids = append(ids, item.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	names, err := s.entityNames(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if item.Name == "" {
+			item.Name = names[item.ID]
+		}
+	}
+	return nil
+}
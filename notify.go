@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+)
+
+// notifyBatch is the maximum number of fits sent per notifier per run.
+const notifyBatch = 10
+
+// notifySettle is how long after being stored fits are posted, so fits
+// stored by transactions still committing when a run starts aren't passed.
+const notifySettle = time.Minute
+
+// A notifierKind formats fits for a chat service's incoming webhooks.
+type notifierKind interface {
+	// validURL reports whether u is a webhook URL of the service.
+	validURL(u *url.URL) bool
+	// payload returns the JSON message posted to the webhook for f.
+	payload(f *FitDetail) interface{}
+}
+
+// notifierKinds are the supported chat services by notifier kind name.
+var notifierKinds = map[string]notifierKind{
+	"discord": discordNotifier{},
+	"slack":   slackNotifier{},
+}
+
+// Notifier registers (POST) or removes (DELETE) a notifier that posts fits
+// matching a saved filter to a chat service webhook of the given kind. The
+// filter is given as the same ship, item, group, and victim parameters as
+// Fits.
+func (s *EFContext) Notifier(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	r.ParseForm()
+	switch r.Method {
+	case http.MethodPost:
+		name := r.Form.Get("kind")
+		if name == "" {
+			name = "discord"
+		}
+		kind, ok := notifierKinds[name]
+		if !ok {
+			return nil, badRequest("unknown notifier kind %q", name)
+		}
+		u, err := url.Parse(r.Form.Get("url"))
+		if err != nil || u.Scheme != "https" || !kind.validURL(u) {
+			return nil, badRequest("invalid %s webhook url", name)
+		}
+		if err := s.validateFitsForm(r.Form); err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		if args := s.writeFitsFilter(ctx, &sb, r.Form, map[string][]Item{}); len(args) == 0 {
+			return nil, badRequest("missing filter")
+		}
+		filter := url.Values{}
+		for _, p := range fitsParams {
+			if v, ok := r.Form[p.Name]; ok {
+				filter[p.Name] = v
+			}
+		}
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		ret := Subscription{
+			Secret: hex.EncodeToString(secret),
+		}
+		if err := s.DB.QueryRowContext(ctx, `
+			INSERT
+			INTO
+				notifiers (kind, url, secret, filter)
+			VALUES
+				($1, $2, $3, $4)
+			RETURNING
+				id
+		`, name, u.String(), ret.Secret, filter.Encode()).Scan(&ret.ID); err != nil {
+			return nil, err
+		}
+		return ret, nil
+	case http.MethodDelete:
+		res, err := s.DB.ExecContext(ctx, `DELETE FROM notifiers WHERE id = $1 AND secret = $2`, r.Form.Get("id"), r.Form.Get("secret"))
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, notFound("unknown notifier")
+		}
+		return nil, nil
+	}
+	return nil, methodNotAllowed(r.Method)
+}
+
+// Notify posts fits stored since the last run to notifiers whose filters
+// they match, in the order they were stored. A new notifier starts with fits
+// stored after its first run.
+func (s *EFContext) Notify(ctx context.Context) {
+	var notifiers []notifierCursor
+	if err := s.X.SelectContext(ctx, &notifiers, `
+		SELECT
+			id, kind, url, filter, last_processed, last_killmail
+		FROM
+			notifiers
+	`); err != nil {
+		slog.Error("notify", "err", err)
+		return
+	}
+	for _, n := range notifiers {
+		if ctx.Err() != nil {
+			return
+		}
+		kind, ok := notifierKinds[n.Kind]
+		if !ok {
+			slog.Error("notify: unknown kind", "notifier", n.ID, "kind", n.Kind)
+			continue
+		}
+		if err := s.notify(ctx, kind, n); err != nil {
+			slog.Error("notify", "notifier", n.ID, "err", err)
+		}
+	}
+}
+
+// notifierCursor is a notifier and the last fit it posted.
+type notifierCursor struct {
+	ID            int64
+	Kind          string
+	URL           string
+	Filter        string
+	LastProcessed sql.NullTime  `db:"last_processed"`
+	LastKillmail  sql.NullInt64 `db:"last_killmail"`
+}
+
+func (s *EFContext) notify(ctx context.Context, kind notifierKind, n notifierCursor) error {
+	// Notifiers without a processed_at cursor, including those that
+	// predate it, start from now.
+	if !n.LastProcessed.Valid {
+		_, err := s.DB.ExecContext(ctx, `UPDATE notifiers SET last_processed = now() - $2::INTERVAL, last_killmail = 0 WHERE id = $1`, n.ID, notifySettle.String())
+		return err
+	}
+	form, err := url.ParseQuery(n.Filter)
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	sb.WriteString(`SELECT fits.killmail, killmails.processed_at FROM fits JOIN killmails ON killmails.id = fits.killmail WHERE TRUE`)
+	args := s.writeFitsFilter(ctx, &sb, form, map[string][]Item{})
+	args = append(args, n.LastProcessed.Time, n.LastKillmail.Int64, notifySettle.String(), notifyBatch)
+	fmt.Fprintf(&sb, ` AND (killmails.processed_at, fits.killmail) > ($%d, $%d) AND killmails.processed_at < now() - $%d::INTERVAL ORDER BY killmails.processed_at, fits.killmail LIMIT $%d`,
+		len(args)-3, len(args)-2, len(args)-1, len(args))
+	var fits []struct {
+		Killmail  int32
+		Processed time.Time `db:"processed_at"`
+	}
+	if err := s.X.SelectContext(ctx, &fits, sb.String(), args...); err != nil {
+		return err
+	}
+	for _, fit := range fits {
+		f, err := s.fit(ctx, fit.Killmail)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(kind.payload(f))
+		if err != nil {
+			return err
+		}
+		if err := postJSON(ctx, n.URL, body); err != nil {
+			return err
+		}
+		if _, err := s.DB.ExecContext(ctx, `UPDATE notifiers SET last_processed = $2, last_killmail = $3 WHERE id = $1`, n.ID, fit.Processed, fit.Killmail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatISK returns v abbreviated with a K, M, or B suffix.
+func formatISK(v float64) string {
+	switch {
+	case v >= 1e9:
+		return fmt.Sprintf("%.2fB ISK", v/1e9)
+	case v >= 1e6:
+		return fmt.Sprintf("%.2fM ISK", v/1e6)
+	case v >= 1e3:
+		return fmt.Sprintf("%.2fK ISK", v/1e3)
+	}
+	return fmt.Sprintf("%.0f ISK", v)
+}
+
+// truncate returns s cut to at most n characters, so a character isn't split
+// into invalid UTF-8.
+func truncate(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// postJSON POSTs body to u, returning an error on non-2xx responses.
+func postJSON(ctx context.Context, u string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("post %s: %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
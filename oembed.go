@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+)
+
+// OEmbedResult is an oEmbed rich response describing a fit.
+type OEmbedResult struct {
+	Version         string `json:"version"`
+	Type            string `json:"type"`
+	Title           string `json:"title"`
+	ProviderName    string `json:"provider_name"`
+	ProviderURL     string `json:"provider_url"`
+	HTML            string `json:"html"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	ThumbnailURL    string `json:"thumbnail_url"`
+	ThumbnailWidth  int    `json:"thumbnail_width"`
+	ThumbnailHeight int    `json:"thumbnail_height"`
+}
+
+// OEmbed returns oEmbed JSON for a fit page URL given by the url parameter,
+// so links to fits unfurl with the ship, its value, and a render.
+func (s *EFContext) OEmbed(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	if f := r.FormValue("format"); f != "" && f != "json" {
+		return nil, &httpError{Status: http.StatusNotImplemented, Code: "unsupported_format", Message: fmt.Sprintf("unsupported format %q", f)}
+	}
+	u, err := url.Parse(r.FormValue("url"))
+	if err != nil {
+		return nil, badRequest("invalid url")
+	}
+	site, _ := url.Parse(siteURL)
+	id, err := strconv.Atoi(strings.TrimPrefix(u.Path, "/fit/"))
+	if u.Host != site.Host || !strings.HasPrefix(u.Path, "/fit/") || err != nil {
+		return nil, notFound("url is not a fit")
+	}
+	f, err := s.fit(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	link := fmt.Sprintf("%s/fit/%d", siteURL, f.Killmail)
+	title := fmt.Sprintf("%s - %s", f.Ship.Name, formatISK(f.Zkb.FittedValue))
+	return OEmbedResult{
+		Version:         "1.0",
+		Type:            "rich",
+		Title:           title,
+		ProviderName:    "fittin.gs",
+		ProviderURL:     siteURL,
+		HTML:            fmt.Sprintf(`<a href="%s">%s</a>`, link, html.EscapeString(title)),
+		Width:           400,
+		Height:          128,
+		ThumbnailURL:    fmt.Sprintf("%s/icon/%d?type=render&size=128", apiURL, f.Ship.ID),
+		ThumbnailWidth:  128,
+		ThumbnailHeight: 128,
+	}, nil
+}
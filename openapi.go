@@ -0,0 +1,547 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+)
+
+type apiHandler func(context.Context, *http.Request, *servertiming.Header) (interface{}, error)
+
+// apiRoute describes a JSON API endpoint. It is used both to register the
+// handler and to generate the OpenAPI document.
+type apiRoute struct {
+	// Name is the route's path relative to the version prefix.
+	Name    string
+	Handler apiHandler
+	// Methods are the supported HTTP methods, GET if empty.
+	Methods []string
+	Summary string
+	Params  []apiParam
+	// Body is a value of the JSON body type of POST requests, whose
+	// parameters are then only those of other methods.
+	Body interface{}
+	// Response is a value of the response type. A rawResult documents its
+	// content type.
+	Response interface{}
+	// Uncached routes are never served from the response cache, such as
+	// those whose responses differ between identical requests.
+	Uncached bool
+	// Cache is how long responses may be reused, defaultCache if zero.
+	Cache cachePolicy
+	// Timeout bounds how long the handler may run, Request_Timeout if 0.
+	// Route_Timeouts overrides it.
+	Timeout time.Duration
+}
+
+// methods returns the HTTP methods route supports.
+func (route apiRoute) methods() []string {
+	if len(route.Methods) == 0 {
+		return []string{http.MethodGet}
+	}
+	return route.Methods
+}
+
+// allows reports whether route supports method. HEAD is allowed with GET.
+func (route apiRoute) allows(method string) bool {
+	for _, m := range route.methods() {
+		if m == method || (m == http.MethodGet && method == http.MethodHead) {
+			return true
+		}
+	}
+	return false
+}
+
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+	Multi       bool
+}
+
+var fieldsParam = apiParam{Name: "fields", Type: "string", Description: "comma-separated fields to include, such as Killmail,Ship"}
+
+var fitsParams = []apiParam{
+	{Name: "category", Type: "string", Description: "hull category: ship or structure"},
+	{Name: "npc", Type: "boolean", Description: "only NPC losses if true, or only losses to players if false"},
+	{Name: "solo", Type: "boolean", Description: "only solo kills if true, or only kills with several attackers if false"},
+	{Name: "awox", Type: "boolean", Description: "only kills by the victim's own corporation or alliance if true, or none of them if false"},
+	{Name: "location", Type: "integer", Description: "ID of the celestial nearest the kill"},
+	{Name: "ship", Type: "integer", Description: "ship type ID"},
+	{Name: "item", Type: "integer", Description: "item type ID; all items must be fitted", Multi: true},
+	{Name: "group", Type: "integer", Description: "group ID; an item of each group must be fitted", Multi: true},
+	{Name: "character", Type: "integer", Description: "victim character ID"},
+	{Name: "corporation", Type: "integer", Description: "victim corporation ID"},
+	{Name: "alliance", Type: "integer", Description: "victim alliance ID"},
+}
+
+// isRoute reports whether name is the name of an API or admin route.
+func (s *EFContext) isRoute(name string) bool {
+	for _, route := range append(s.apiRoutes(), s.adminRoutes()...) {
+		if route.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *EFContext) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{
+			Name:    "Fit",
+			Handler: s.Fit,
+			Summary: "Fit of a killmail.",
+			Params: []apiParam{
+				{Name: "id", Type: "integer", Description: "killmail ID", Required: true},
+				fieldsParam,
+			},
+			Response: FitDetail{},
+			Cache:    cachePolicy{MaxAge: time.Hour, Stale: time.Hour * 24},
+		},
+		{
+			Name:    "Fits",
+			Handler: s.Fits,
+			Summary: "Most recent fits matching filters.",
+			Params: append([]apiParam{
+				fieldsParam,
+				{Name: "limit", Type: "integer", Description: "number of fits, at most 100, or 1000 with a bulk API key"},
+				{Name: "sort", Type: "string", Description: "recent (default) for the latest fits first, or score for the highest voted"},
+				{Name: "format", Type: "string", Description: "csv for a CSV of killmail, ship, cost, dropped, points, and rack columns, or ndjson for one fit per line; also selected by Accept: text/csv or application/x-ndjson. Both are streamed."},
+			}, fitsParams...),
+			Response: FitsResult{},
+			// Bulk exports stream up to the limit of a bulk API key.
+			Timeout: time.Minute * 5,
+			Cache:   cachePolicy{MaxAge: time.Minute, Stale: time.Minute * 5},
+		},
+		{
+			Name:    "Pilot",
+			Handler: s.Pilot,
+			Summary: "Most recent losses of a character.",
+			Params: []apiParam{
+				{Name: "id", Type: "integer", Description: "character ID; one of id or name is required"},
+				{Name: "name", Type: "string", Description: "character name, ignoring case"},
+				{Name: "limit", Type: "integer", Description: "number of losses, at most 100"},
+			},
+			Response: Pilot{},
+			Cache:    cachePolicy{MaxAge: time.Minute, Stale: time.Minute * 5},
+		},
+		{
+			Name:    "Corporation",
+			Handler: s.Corporation,
+			Summary: "Most recent losses of members of a corporation, with the most lost hulls and total ISK lost over the last 30 days.",
+			Params: []apiParam{
+				{Name: "id", Type: "integer", Description: "corporation ID", Required: true},
+				{Name: "limit", Type: "integer", Description: "number of losses, at most 100"},
+			},
+			Response: EntityLosses{},
+			Cache:    cachePolicy{MaxAge: time.Minute * 5, Stale: time.Minute * 30},
+		},
+		{
+			Name:    "Alliance",
+			Handler: s.Alliance,
+			Summary: "Most recent losses of members of an alliance, with the most lost hulls and total ISK lost over the last 30 days.",
+			Params: []apiParam{
+				{Name: "id", Type: "integer", Description: "alliance ID", Required: true},
+				{Name: "limit", Type: "integer", Description: "number of losses, at most 100"},
+			},
+			Response: EntityLosses{},
+			Cache:    cachePolicy{MaxAge: time.Minute * 5, Stale: time.Minute * 30},
+		},
+		{
+			Name:    "Search",
+			Handler: s.Search,
+			Summary: "Items, groups, and names matching a search term.",
+			Params: []apiParam{
+				{Name: "term", Type: "string", Description: "search term of at least 3 characters", Required: true},
+				{Name: "type", Type: "string", Description: "restrict results to ship, structure, item, group, character, corporation, or alliance"},
+			},
+			Response: SearchResults{},
+			Timeout:  time.Second * 10,
+			Cache:    cachePolicy{MaxAge: time.Minute * 10, Stale: time.Hour},
+		},
+		{
+			Name:     "Autocomplete",
+			Handler:  s.Autocomplete,
+			Summary:  "Names starting with a term.",
+			Params:   []apiParam{{Name: "term", Type: "string", Description: "prefix of at least 2 characters", Required: true}},
+			Response: []SearchResult{},
+			Timeout:  time.Second * 5,
+			Cache:    cachePolicy{MaxAge: time.Minute * 10, Stale: time.Hour},
+		},
+		{
+			Name:     "FOTD",
+			Handler:  s.FOTD,
+			Summary:  "Fit of the day.",
+			Response: FitDetail{},
+			Cache:    cachePolicy{MaxAge: time.Minute * 10, Stale: time.Hour},
+		},
+		{
+			Name:     "Random",
+			Handler:  s.Random,
+			Summary:  "Random recent fit matching filters.",
+			Params:   fitsParams,
+			Response: FitDetail{},
+			Uncached: true,
+			Cache:    cachePolicy{NoStore: true},
+		},
+		{
+			Name:     "Report",
+			Handler:  s.Report,
+			Summary:  "Latest module meta report.",
+			Response: Report{},
+			Cache:    cachePolicy{MaxAge: time.Minute * 10, Stale: time.Hour},
+		},
+		{
+			Name:     "HullModules",
+			Handler:  s.HullModules,
+			Summary:  "Modules most often fitted to a ship in the last 30 days.",
+			Params:   []apiParam{{Name: "ship", Type: "integer", Description: "ship type ID", Required: true}},
+			Response: []ItemCount{},
+			Cache:    cachePolicy{MaxAge: time.Hour, Stale: time.Hour * 24},
+		},
+		{
+			Name:     "ShipUsage",
+			Handler:  s.ShipUsage,
+			Summary:  "Weekly losses of a ship over the last 12 weeks.",
+			Params:   []apiParam{{Name: "ship", Type: "integer", Description: "ship type ID", Required: true}},
+			Response: []ShipWeek{},
+			Cache:    cachePolicy{MaxAge: time.Hour, Stale: time.Hour * 24},
+		},
+		{
+			Name:     "Doctrines",
+			Handler:  s.Doctrines,
+			Summary:  "Fits lost at least 5 times in the last 30 days, most lost first.",
+			Params:   []apiParam{{Name: "ship", Type: "integer", Description: "ship type ID"}},
+			Response: []Doctrine{},
+			Cache:    cachePolicy{MaxAge: time.Hour, Stale: time.Hour * 24},
+		},
+		{
+			Name:     "Feed",
+			Handler:  s.Feed,
+			Summary:  "Atom feed of recent fits matching filters, with EFT entry content.",
+			Params:   fitsParams,
+			Response: rawResult{contentType: "application/atom+xml"},
+			Cache:    cachePolicy{MaxAge: time.Minute * 5, Stale: time.Hour},
+		},
+		{
+			Name:    "Webhook",
+			Handler: s.Webhook,
+			Methods: []string{http.MethodPost, http.MethodDelete},
+			Summary: "Register (POST) or remove (DELETE) a webhook receiving new fits matching filters. Deliveries are signed with the returned secret. Registering requires an API key and an https URL of a public host.",
+			Params: []apiParam{
+				{Name: "url", Type: "string", Description: "https URL to POST new fits to (POST)"},
+				{Name: "ship", Type: "integer", Description: "ship type ID filter (POST)"},
+				{Name: "item", Type: "integer", Description: "item type ID filter (POST)"},
+				{Name: "alliance", Type: "integer", Description: "victim alliance ID filter (POST)"},
+				{Name: "id", Type: "integer", Description: "webhook ID (DELETE)"},
+				{Name: "secret", Type: "string", Description: "webhook secret (DELETE)"},
+			},
+			Response: Subscription{},
+			Cache:    cachePolicy{NoStore: true},
+		},
+		{
+			Name:    "Notifier",
+			Handler: s.Notifier,
+			Methods: []string{http.MethodPost, http.MethodDelete},
+			Summary: "Register (POST) or remove (DELETE) a Discord or Slack webhook notified with a summary of each new fit matching filters.",
+			Params: append([]apiParam{
+				{Name: "kind", Type: "string", Description: "discord (default) or slack (POST)"},
+				{Name: "url", Type: "string", Description: "incoming webhook URL (POST)"},
+				{Name: "id", Type: "integer", Description: "notifier ID (DELETE)"},
+				{Name: "secret", Type: "string", Description: "notifier secret (DELETE)"},
+			}, fitsParams...),
+			Response: Subscription{},
+			Cache:    cachePolicy{NoStore: true},
+		},
+		{
+			Name:     "Me",
+			Handler:  s.Me,
+			Summary:  "Character logged in with EVE SSO at /sso/login.",
+			Response: Character{},
+			Uncached: true,
+			Cache:    cachePolicy{NoStore: true},
+		},
+		{
+			Name:    "me/saved",
+			Handler: s.SavedFits,
+			Methods: []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+			Summary: "Fits saved by the logged in character (GET), or save (POST) or remove (DELETE) one.",
+			Params: []apiParam{
+				{Name: "id", Type: "integer", Description: "killmail ID (POST and DELETE)"},
+			},
+			Response: []*FitSummary{},
+			Uncached: true,
+			Cache:    cachePolicy{NoStore: true},
+		},
+		{
+			Name:    "Vote",
+			Handler: s.Vote,
+			Methods: []string{http.MethodPost},
+			Summary: "Vote on a fit as the logged in character, replacing any earlier vote. Fits listed with sort=score are ordered by the sum of their votes.",
+			Params: []apiParam{
+				{Name: "id", Type: "integer", Description: "killmail ID", Required: true},
+				{Name: "vote", Type: "string", Description: "up, down, or none to remove the vote", Required: true},
+			},
+			Response: FitVote{},
+			Uncached: true,
+			Cache:    cachePolicy{NoStore: true},
+		},
+		{
+			Name:    "Comments",
+			Handler: s.Comments,
+			Methods: []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+			Summary: "Comments on a fit (GET), or post (POST) or delete (DELETE) one as the logged in character. Characters may post 5 comments per 10 minutes.",
+			Params: []apiParam{
+				{Name: "id", Type: "integer", Description: "killmail ID (GET and POST)"},
+				{Name: "body", Type: "string", Description: "comment of at most 500 characters (POST)"},
+				{Name: "comment", Type: "integer", Description: "comment ID (DELETE)"},
+			},
+			Response: []Comment{},
+			Uncached: true,
+			Cache:    cachePolicy{NoStore: true},
+		},
+		{
+			Name:    "TheoreticalFits",
+			Handler: s.TheoreticalFits,
+			Methods: []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+			Summary: "Fits submitted in EFT format rather than taken from killmails, labeled Theoretical. GET lists the newest 50 matching filters, or the one given by id. Logged in characters may submit (POST) up to 10 fits an hour and delete (DELETE) their own.",
+			Params: []apiParam{
+				{Name: "id", Type: "integer", Description: "theoretical fit ID (GET and DELETE)"},
+				{Name: "eft", Type: "string", Description: "fit in EFT format (POST)"},
+				{Name: "ship", Type: "integer", Description: "ship type ID (GET)"},
+				{Name: "item", Type: "integer", Description: "item type ID; all items must be fitted (GET)", Multi: true},
+				{Name: "group", Type: "integer", Description: "group ID; an item of each group must be fitted (GET)", Multi: true},
+			},
+			Response: []*TheoreticalFit{},
+			Cache:    cachePolicy{MaxAge: time.Minute, Stale: time.Minute * 5},
+		},
+		{
+			Name:    "DoctrineLists",
+			Handler: s.DoctrineLists,
+			Methods: []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+			Summary: "Doctrine lists of the logged in character and those shared with its corporation or alliance (GET), or the one given by id with its fits, viewable by members of the owner's corporation, of its alliance if shared with it, or with its share token. POST creates a list, or renames or shares the one given by id; DELETE deletes one. Corporation and alliance membership is verified with ESI.",
+			Params: []apiParam{
+				{Name: "id", Type: "integer", Description: "doctrine list ID"},
+				{Name: "token", Type: "string", Description: "share token of the list, also accepted in the X-Share-Token header (GET)"},
+				{Name: "name", Type: "string", Description: "list name of at most 100 characters (POST)"},
+				{Name: "share", Type: "boolean", Description: "whether the list can be viewed with a share token (POST)"},
+				{Name: "alliance", Type: "boolean", Description: "whether members of the owner's alliance can view the list (POST)"},
+			},
+			Response: DoctrineList{},
+			Uncached: true,
+			Cache:    cachePolicy{NoStore: true},
+		},
+		{
+			Name:    "DoctrineListFits",
+			Handler: s.DoctrineListFits,
+			Methods: []string{http.MethodPost, http.MethodDelete},
+			Summary: "Add (POST) a killmail or theoretical fit to a doctrine list of the logged in character, or remove (DELETE) one. Lists may have 100 fits.",
+			Params: []apiParam{
+				{Name: "list", Type: "integer", Description: "doctrine list ID (POST)"},
+				{Name: "killmail", Type: "integer", Description: "killmail ID (POST)"},
+				{Name: "theoretical", Type: "integer", Description: "theoretical fit ID (POST)"},
+				{Name: "note", Type: "string", Description: "note of at most 500 characters (POST)"},
+				{Name: "id", Type: "integer", Description: "doctrine list fit ID (DELETE)"},
+			},
+			Response: DoctrineListFit{},
+			Uncached: true,
+			Cache:    cachePolicy{NoStore: true},
+		},
+		{
+			Name:    "GraphQL",
+			Handler: s.GraphQL,
+			Methods: []string{http.MethodGet, http.MethodPost},
+			Summary: "GraphQL query, given by parameters (GET) or a JSON body (POST).",
+			Params: []apiParam{
+				{Name: "query", Type: "string", Description: "GraphQL query", Required: true},
+				{Name: "operationName", Type: "string"},
+				{Name: "variables", Type: "string", Description: "JSON object of variables"},
+			},
+			Body: GraphQLRequest{},
+			// GraphQL responses are never enveloped.
+			Response: rawResult{contentType: "application/json"},
+			Cache:    cachePolicy{MaxAge: time.Minute, Stale: time.Minute * 5},
+		},
+	}
+}
+
+// OpenAPI returns an OpenAPI 3 document describing the API routes.
+func (s *EFContext) OpenAPI(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	type obj = map[string]interface{}
+	schemas := obj{}
+	paths := obj{}
+	errSchema := obj{
+		"type": "object",
+		"properties": obj{
+			"error": openAPISchema(reflect.TypeOf(APIError{}), schemas),
+			"meta":  openAPISchema(reflect.TypeOf(Meta{}), schemas),
+		},
+	}
+	for _, route := range s.apiRoutes() {
+		var params []obj
+		for _, p := range route.Params {
+			schema := obj{"type": p.Type}
+			if p.Multi {
+				schema = obj{"type": "array", "items": schema}
+			}
+			params = append(params, obj{
+				"name":        p.Name,
+				"in":          "query",
+				"description": p.Description,
+				"required":    p.Required,
+				"schema":      schema,
+			})
+		}
+		contentType, schema := "application/json", obj{}
+		if raw, ok := route.Response.(rawResult); ok {
+			contentType = raw.contentType
+			if contentType != "application/json" {
+				schema = obj{"type": "string"}
+			}
+		} else {
+			if route.Response != nil {
+				schema = openAPISchema(reflect.TypeOf(route.Response), schemas)
+			}
+			schema = obj{
+				"type": "object",
+				"properties": obj{
+					"data":  schema,
+					"error": openAPISchema(reflect.TypeOf(&APIError{}), schemas),
+					"meta":  openAPISchema(reflect.TypeOf(Meta{}), schemas),
+				},
+			}
+		}
+		ops := obj{}
+		for _, m := range route.methods() {
+			op := obj{
+				"summary":    route.Summary,
+				"parameters": params,
+				"responses": obj{
+					"200": obj{
+						"description": "OK",
+						"content": obj{
+							contentType: obj{"schema": schema},
+						},
+					},
+					"default": obj{
+						"description": "Error",
+						"content": obj{
+							"application/json": obj{"schema": errSchema},
+						},
+					},
+				},
+			}
+			if route.Body != nil && m == http.MethodPost {
+				op["parameters"] = []obj{}
+				op["requestBody"] = obj{
+					"required": true,
+					"content": obj{
+						"application/json": obj{"schema": openAPISchema(reflect.TypeOf(route.Body), schemas)},
+					},
+				}
+			}
+			ops[strings.ToLower(m)] = op
+		}
+		paths[apiV1.Prefix+route.Name] = ops
+	}
+	return obj{
+		"openapi": "3.0.3",
+		"info": obj{
+			"title":   "fittin.gs",
+			"version": apiV1.Version,
+		},
+		"paths":      paths,
+		"components": obj{"schemas": schemas},
+	}, nil
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// openAPISchema returns the schema of values of t as encoded by
+// encoding/json. Named struct types are added to schemas and referenced.
+func openAPISchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	type obj = map[string]interface{}
+	switch t {
+	case timeType:
+		return obj{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return obj{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := openAPISchema(t.Elem(), schemas)
+		if _, ok := s["$ref"]; !ok {
+			s["nullable"] = true
+		}
+		return s
+	case reflect.Bool:
+		return obj{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return obj{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return obj{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return obj{"type": "number"}
+	case reflect.String:
+		return obj{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return obj{"type": "string", "format": "byte"}
+		}
+		return obj{"type": "array", "items": openAPISchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return obj{"type": "object", "additionalProperties": openAPISchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return openAPIStruct(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			// Reserve the name before recursing in case t is recursive.
+			schemas[t.Name()] = obj{}
+			schemas[t.Name()] = openAPIStruct(t, schemas)
+		}
+		return obj{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return obj{}
+}
+
+// openAPIStruct returns the object schema of struct type t, including the
+// fields of embedded structs.
+func openAPIStruct(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	props := map[string]interface{}{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || f.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				addFields(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = openAPISchema(f.Type, schemas)
+		}
+	}
+	addFields(t)
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+)
+
+// Pilot is a character's recent losses.
+type Pilot struct {
+	// Character is the pilot's ID and name.
+	Character Item
+	// Losses are the fits of the pilot's latest losses, newest first.
+	Losses []*FitSummary
+}
+
+// Pilot returns the latest losses of the character given by id or name.
+func (s *EFContext) Pilot(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	r.ParseForm()
+	v := &validator{form: r.Form}
+	v.maxCount("id", 1)
+	ids := v.id("id", nil)
+	limit := fitsLimit
+	if r.Form.Get("limit") != "" {
+		limit = v.intRange("limit", 1, fitsLimit)
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	var id int32
+	switch name := strings.TrimSpace(r.FormValue("name")); {
+	case len(ids) == 1 && name == "":
+		id = ids[0]
+	case len(ids) == 0 && name != "":
+		var err error
+		if id, err = s.characterID(ctx, name); err != nil {
+			return nil, err
+		}
+		if id == 0 {
+			return nil, notFound("unknown character %q", name)
+		}
+	default:
+		return nil, badRequest("one of id or name required")
+	}
+	res, err := s.fits(ctx, url.Values{"character": {strconv.Itoa(int(id))}}, limit, nil, timing)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Pilot{
+		Character: Item{ID: id},
+		Losses:    res.Fits,
+	}
+	if c := res.Filter["character"]; len(c) == 1 {
+		ret.Character = c[0]
+	}
+	return ret, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach-go/crdb"
+)
+
+const (
+	// popularityInterval is how often item popularity is recomputed and
+	// reloaded.
+	popularityInterval = time.Hour
+	// popularityFits is the number of most recent fits counted.
+	popularityFits = 10000
+)
+
+// popularity caches the number of recent fits each item and group appears in.
+type popularity struct {
+	sync.Mutex
+	loaded time.Time
+	items  map[int32]int
+	groups map[int32]int
+}
+
+// UpdatePopularity recounts how many recent fits each item appears in if the
+// counts are older than popularityInterval.
+func (s *EFContext) UpdatePopularity(ctx context.Context) {
+	var last sql.NullTime
+	if err := s.DB.QueryRowContext(ctx, `SELECT max(updated) FROM popularity`).Scan(&last); err != nil {
+		slog.Error("update popularity", "err", err)
+		return
+	}
+	if last.Valid && time.Since(last.Time) < popularityInterval {
+		return
+	}
+	s.updatePopularity(ctx)
+}
+
+// updatePopularity recounts how many recent fits each item appears in.
+func (s *EFContext) updatePopularity(ctx context.Context) {
+	var fits []int32Array
+	if err := s.X.SelectContext(ctx, &fits, `SELECT items FROM fits ORDER BY killmail DESC LIMIT $1`, popularityFits); err != nil {
+		slog.Error("update popularity", "err", err)
+		return
+	}
+	counts := map[int32]int64{}
+	for _, items := range fits {
+		seen := map[int32]bool{}
+		for _, id := range items {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			counts[id]++
+		}
+	}
+	var ids []int32
+	var ns []int64
+	for id, n := range counts {
+		ids = append(ids, id)
+		ns = append(ns, n)
+	}
+
+	if err := crdb.ExecuteTx(ctx, s.DB, nil, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM popularity WHERE TRUE`); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT
+			INTO
+				popularity (id, fits, updated)
+			SELECT
+				unnest($1::INT4[]), unnest($2::INT8[]), now()
+		`, ids, ns)
+		return err
+	}); err != nil {
+		slog.Error("update popularity", "err", err)
+		return
+	}
+	slog.Info("updated popularity", "items", len(ids))
+}
+
+// itemPopularity returns the item and group popularity counts, reloading
+// them from the database if stale. On error the previous counts are used.
+func (s *EFContext) itemPopularity(ctx context.Context) (items, groups map[int32]int) {
+	p := &s.popularity
+	p.Lock()
+	defer p.Unlock()
+	if time.Since(p.loaded) < popularityInterval {
+		return p.items, p.groups
+	}
+	p.loaded = time.Now()
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, fits FROM popularity`)
+	if err != nil {
+		slog.Error("load popularity", "err", err)
+		return p.items, p.groups
+	}
+	defer rows.Close()
+	g := s.Global()
+	items = map[int32]int{}
+	groups = map[int32]int{}
+	for rows.Next() {
+		var id int32
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			slog.Error("load popularity", "err", err)
+			return p.items, p.groups
+		}
+		items[id] = n
+		groups[g.Items[id].Group] += n
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("load popularity", "err", err)
+		return p.items, p.groups
+	}
+	p.items, p.groups = items, groups
+	return items, groups
+}
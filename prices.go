@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// pricesInterval is how often prices are fetched and fit costs
+	// recomputed.
+	pricesInterval = time.Hour * 6
+	// costRecomputeAge is how old the fits whose costs are recomputed can
+	// be. Older fits keep their last cost.
+	costRecomputeAge   = time.Hour * 24 * 30
+	costRecomputeBatch = 1000
+)
+
+// esiPrice is an entry of ESI's /markets/prices/.
+type esiPrice struct {
+	TypeID        int32   `json:"type_id"`
+	AveragePrice  float64 `json:"average_price"`
+	AdjustedPrice float64 `json:"adjusted_price"`
+}
+
+// UpdatePrices fetches market prices and recomputes the costs of recent fits
+// from them if prices are older than pricesInterval.
+func (s *EFContext) UpdatePrices(ctx context.Context) {
+	var last sql.NullTime
+	if err := s.DB.QueryRowContext(ctx, `SELECT max(updated) FROM prices`).Scan(&last); err != nil {
+		slog.Error("update prices", "err", err)
+		return
+	}
+	if last.Valid && time.Since(last.Time) < pricesInterval {
+		return
+	}
+	s.updatePrices(ctx)
+}
+
+// updatePrices fetches market prices and recomputes the costs of recent fits
+// from them.
+func (s *EFContext) updatePrices(ctx context.Context) {
+	prices, err := fetchPrices(ctx)
+	if err != nil {
+		slog.Error("update prices", "err", err)
+		return
+	}
+	var ids []int32
+	var ps []float64
+	for id, p := range prices {
+		ids = append(ids, id)
+		ps = append(ps, p)
+	}
+	if _, err := s.DB.ExecContext(ctx, `
+		UPSERT
+		INTO
+			prices (id, price, updated)
+		SELECT
+			unnest($1::INT4[]), unnest($2::FLOAT8[]), now()
+	`, ids, ps); err != nil {
+		slog.Error("update prices", "err", err)
+		return
+	}
+	slog.Info("updated prices", "types", len(ids))
+	if err := s.recomputeCosts(ctx, prices); err != nil {
+		slog.Error("recompute costs", "err", err)
+	}
+}
+
+// fetchPrices returns the price of each type from ESI: its average price, or
+// its adjusted price if it has no average.
+func fetchPrices(ctx context.Context) (map[int32]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, esiBase+"/markets/prices/", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := esiDo(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("market prices: %s", resp.Status)
+	}
+	var list []esiPrice
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, errors.Wrap(err, "market prices")
+	}
+	prices := make(map[int32]float64, len(list))
+	for _, p := range list {
+		price := p.AveragePrice
+		if price == 0 {
+			price = p.AdjustedPrice
+		}
+		if price > 0 {
+			prices[p.TypeID] = price
+		}
+	}
+	return prices, nil
+}
+
+// recomputeCosts sets the cost of fits of killmails newer than
+// costRecomputeAge to the price of their hull and modules, replacing zkb's
+// estimate from kill time.
+func (s *EFContext) recomputeCosts(ctx context.Context, prices map[int32]float64) error {
+	after := int32(1<<31 - 1)
+	updated := 0
+	for ctx.Err() == nil {
+		var fits []struct {
+			Killmail          int32
+			Ship              int32
+			Hi, Med, Low, Rig int32Array
+			Sub               int32Array
+		}
+		if err := s.X.SelectContext(ctx, &fits, `
+			SELECT
+				f.killmail, f.ship, f.hi, f.med, f.low, f.rig, f.sub
+			FROM
+				fits AS f JOIN killmails AS k ON k.id = f.killmail
+			WHERE
+				f.killmail < $1 AND k.killed > $2
+			ORDER BY
+				f.killmail DESC
+			LIMIT
+				$3
+		`, after, time.Now().Add(-costRecomputeAge), costRecomputeBatch); err != nil {
+			return err
+		}
+		if len(fits) == 0 {
+			break
+		}
+		ids := make([]int32, len(fits))
+		costs := make([]int64, len(fits))
+		for i, f := range fits {
+			cost := prices[f.Ship]
+			for _, rack := range []int32Array{f.Hi, f.Med, f.Low, f.Rig, f.Sub} {
+				for _, id := range rack {
+					cost += prices[id]
+				}
+			}
+			ids[i], costs[i] = f.Killmail, int64(cost)
+		}
+		if _, err := s.DB.ExecContext(ctx, `
+			UPDATE
+				fits
+			SET
+				cost = c.cost
+			FROM
+				(SELECT unnest($1::INT4[]) AS killmail, unnest($2::INT8[]) AS cost) AS c
+			WHERE
+				fits.killmail = c.killmail
+		`, ids, costs); err != nil {
+			return err
+		}
+		updated += len(fits)
+		after = fits[len(fits)-1].Killmail
+	}
+	slog.Info("recomputed costs", "fits", updated)
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitIdle is how long a client's limiter is kept after its last
+// request.
+const rateLimitIdle = time.Minute * 10
+
+// rateTier is the token bucket applied to a class of clients.
+type rateTier struct {
+	Limit rate.Limit
+	Burst int
+}
+
+// rateLimiter enforces per-client token buckets. It runs before requests are
+// authenticated, so invalid API keys can't be tried at the database's pace.
+// The classify function identifies the client of a request and its tier; the
+// default keys clients with an API key found by apiKey at the key's own tier
+// and others by IP, as seen by the nearest of trustedProxies proxies, at the
+// limiter's default tier. A key is only found once cached, so a key's first
+// request after its cache entry expires counts against its IP.
+type rateLimiter struct {
+	tier     rateTier
+	classify func(r *http.Request) (key string, tier rateTier)
+
+	mu      sync.Mutex
+	clients map[string]*rateClient
+	swept   time.Time
+}
+
+type rateClient struct {
+	limiter *rate.Limiter
+	tier    rateTier
+	seen    time.Time
+}
+
+func newRateLimiter(tier rateTier, trustedProxies int, apiKey func(*http.Request) *apiKey) *rateLimiter {
+	l := &rateLimiter{
+		tier:    tier,
+		clients: map[string]*rateClient{},
+	}
+	l.classify = func(r *http.Request) (string, rateTier) {
+		if key := apiKey(r); key != nil {
+			return "key:" + strconv.FormatInt(key.ID, 10), key.Tier
+		}
+		return "ip:" + clientIP(r, trustedProxies), l.tier
+	}
+	return l
+}
+
+// allow reports whether r may proceed, setting X-RateLimit headers on w. If
+// not, it writes a 429 response.
+func (l *rateLimiter) allow(w http.ResponseWriter, r *http.Request, v apiVersion) bool {
+	key, tier := l.classify(r)
+	ok, remaining := l.take(key, tier)
+
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(tier.Burst))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(remaining)))))
+	if ok {
+		return true
+	}
+	// Time until a token is available.
+	wait := time.Duration((1 - remaining) / float64(tier.Limit) * float64(time.Second))
+	h.Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeError(w, r, v, &httpError{
+		Status:  http.StatusTooManyRequests,
+		Code:    "rate_limited",
+		Message: "rate limit exceeded",
+	})
+	return false
+}
+
+// take takes a token from the bucket of client key at tier, reporting
+// whether one was available and how many remain.
+func (l *rateLimiter) take(key string, tier rateTier) (ok bool, remaining float64) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > rateLimitIdle {
+		for k, c := range l.clients {
+			if now.Sub(c.seen) > rateLimitIdle {
+				delete(l.clients, k)
+			}
+		}
+		l.swept = now
+	}
+	c := l.clients[key]
+	if c == nil || c.tier != tier {
+		c = &rateClient{limiter: rate.NewLimiter(tier.Limit, tier.Burst), tier: tier}
+		l.clients[key] = c
+	}
+	c.seen = now
+	return c.limiter.AllowN(now, 1), c.limiter.TokensAt(now)
+}
+
+// clientIP returns the IP of the client of r behind trustedProxies proxies,
+// each of which appends the address it received the request from to
+// X-Forwarded-For. Clients can forge earlier entries, so the entry
+// trustedProxies from the end is used.
+func clientIP(r *http.Request, trustedProxies int) string {
+	var xff []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		xff = append(xff, strings.Split(h, ",")...)
+	}
+	if trustedProxies > 0 && len(xff) > 0 {
+		i := len(xff) - trustedProxies
+		if i < 0 {
+			// Fewer proxies than configured appended entries.
+			i = 0
+		}
+		return strings.TrimSpace(xff[i])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
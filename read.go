@@ -5,63 +5,99 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/antihax/goesi/esi"
 	"github.com/cockroachdb/cockroach-go/crdb"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// CreateTables drops all tables and creates them again by applying every
+// migration.
 func (s *EFContext) CreateTables() {
 	if _, err := s.DB.Exec(`
+		DROP MATERIALIZED VIEW IF EXISTS hull_modules;
+
+		DROP MATERIALIZED VIEW IF EXISTS ship_usage;
+
+		DROP MATERIALIZED VIEW IF EXISTS doctrines;
+
 		DROP TABLE IF EXISTS hashes;
 
 		DROP TABLE IF EXISTS fits;
 
 		DROP TABLE IF EXISTS killmails;
 
-		CREATE TABLE hashes (
-			id        INT4 PRIMARY KEY,
-			hash      STRING NOT NULL,
-			processed INT4 DEFAULT 0 NOT NULL,
-			INDEX (processed)
-		);
-
-		CREATE TABLE killmails (
-			id        INT4 PRIMARY KEY,
-			km        JSONB NOT NULL,
-			zkb JSONB NOT NULL,
-			processed INT4 DEFAULT 0 NOT NULL,
-			INDEX (processed)
-		);
-
-		CREATE TABLE fits (
-			killmail    INT4,
-			ship        INT4 NOT NULL,
-			cost        INT8,
-			solarsystem INT4 NOT NULL,
-			hi          JSONB NOT NULL,
-			med         JSONB NOT NULL,
-			low         JSONB NOT NULL,
-			rig         JSONB NOT NULL,
-			sub         JSONB NOT NULL,
-			items       JSONB NOT NULL,
-			PRIMARY KEY (killmail DESC),
-			INVERTED INDEX (items)
-		);
+		DROP TABLE IF EXISTS reports;
+
+		DROP TABLE IF EXISTS fotd;
+
+		DROP TABLE IF EXISTS popularity;
+
+		DROP TABLE IF EXISTS names;
+
+		DROP TABLE IF EXISTS webhook_deliveries;
+
+		DROP TABLE IF EXISTS webhooks;
+
+		DROP TABLE IF EXISTS notifiers;
+
+		DROP TABLE IF EXISTS api_keys;
+
+		DROP TABLE IF EXISTS saved_fits;
+
+		DROP TABLE IF EXISTS doctrine_list_fits;
+
+		DROP TABLE IF EXISTS doctrine_lists;
+
+		DROP TABLE IF EXISTS theoretical_fits;
+
+		DROP TABLE IF EXISTS fit_comments;
+
+		DROP TABLE IF EXISTS fit_votes;
+
+		DROP TABLE IF EXISTS fit_scores;
+
+		DROP TABLE IF EXISTS sessions;
+
+		DROP TABLE IF EXISTS characters;
+
+		DROP TABLE IF EXISTS checkpoints;
+
+		DROP TABLE IF EXISTS dead_letter;
+
+		DROP TABLE IF EXISTS prices;
+
+		DROP TABLE IF EXISTS synonyms;
+
+		DROP TABLE IF EXISTS schema_migrations;
 	`); err != nil {
-		log.Fatal(err)
+		fatal("drop tables", "err", err)
+	}
+	if err := s.Migrate(context.Background()); err != nil {
+		fatal("create tables", "err", err)
 	}
 }
 
 const (
 	ProcHashFetched = 1
+	// ProcHashFailed marks hashes whose killmail could not be fetched from
+	// ESI after fetchAttemptLimit attempts, or was refused by ESI.
+	ProcHashFailed = -1
 
 	ProcKMFitAdded  = 1
 	ProcKMZkbAdded  = 2
 	ProcKMCostAdded = 3
+	// ProcKMFailed marks killmails that failed to process, which are
+	// recorded in dead_letter.
+	ProcKMFailed = -1
+	// ProcKMReprocess marks processed killmails queued to have their fits
+	// derived again by ProcessFits.
+	ProcKMReprocess = -2
 )
 
 type KM esi.GetKillmailsKillmailIdKillmailHashOk
@@ -117,11 +153,29 @@ const (
 	SubSlot7
 )
 
-func IsHigh(s Slot) bool   { return s.IsHigh() }
-func IsMedium(s Slot) bool { return s.IsMedium() }
-func IsLow(s Slot) bool    { return s.IsLow() }
-func IsRig(s Slot) bool    { return s.IsRig() }
-func IsSub(s Slot) bool    { return s.IsSub() }
+// Structure fighter tubes and service slots.
+const (
+	FighterTube0 Slot = 159 + iota
+	FighterTube1
+	FighterTube2
+	FighterTube3
+	FighterTube4
+	ServiceSlot0
+	ServiceSlot1
+	ServiceSlot2
+	ServiceSlot3
+	ServiceSlot4
+	ServiceSlot5
+	ServiceSlot6
+	ServiceSlot7
+)
+
+func IsHigh(s Slot) bool    { return s.IsHigh() }
+func IsMedium(s Slot) bool  { return s.IsMedium() }
+func IsLow(s Slot) bool     { return s.IsLow() }
+func IsRig(s Slot) bool     { return s.IsRig() }
+func IsSub(s Slot) bool     { return s.IsSub() }
+func IsService(s Slot) bool { return s.IsService() }
 
 func (s Slot) IsHigh() bool {
 	return s >= HiSlot0 && s <= HiSlot7
@@ -143,197 +197,404 @@ func (s Slot) IsSub() bool {
 	return s >= SubSlot0 && s <= SubSlot7
 }
 
+func (s Slot) IsService() bool {
+	return s >= ServiceSlot0 && s <= ServiceSlot7
+}
+
+func (s Slot) IsFighterTube() bool {
+	return s >= FighterTube0 && s <= FighterTube4
+}
+
 // FetchHashes listens on the zkillboard redisq API and populates the hashes
 // and killmails tables with results. As soon as zkillboard has no more results
-// or ctx is cancelled this function returns.
+// or ctx is cancelled this function returns. redisq serves one request per
+// queue ID at a time, so unlike FetchKillmails it doesn't use the worker pool.
 func (s *EFContext) FetchHashes(ctx context.Context) {
-	// We don't want the db txn to fail if ctx is canceled.
-	dbCtx := context.Background()
+	s.warnIngestionGap(ctx, checkpointRedisQ, redisqRetention)
 	for {
 		if ctx.Err() != nil {
 			return
 		}
-
 		// Use a low ttw so the request stops as soon as possible to
 		// lower the google cloud run request times.
-		resp, _ := http.Get("https://redisq.zkillboard.com/listen.php?queueID=fittin.gs&ttw=1")
-		if resp == nil || resp.StatusCode != 200 {
-			return
+		ok, err := s.fetchRedisQ(ctx, 1)
+		if err != nil {
+			slog.Warn("fetch hashes", "err", err)
+			s.ingest.failed(checkpointRedisQ)
 		}
-		var pkg ZKillPackage
-		_ = json.NewDecoder(resp.Body).Decode(&pkg)
-		resp.Body.Close()
-		if pkg.Package == nil {
+		if !ok {
 			return
 		}
-		rawKM, err := json.Marshal(pkg.Package.Killmail)
-		if err != nil {
-			panic(err)
+	}
+}
+
+// fetchRedisQ waits up to ttw seconds for a killmail from redisq and stores
+// it, reporting whether one was available.
+func (s *EFContext) fetchRedisQ(ctx context.Context, ttw int) (bool, error) {
+	ctx, span := tracer.Start(ctx, "FetchHashes.fetch")
+	defer span.End()
+	u := fmt.Sprintf("https://redisq.zkillboard.com/listen.php?queueID=%s&ttw=%d", url.QueryEscape(s.redisqQueue), ttw)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := retryDo(req, httpClient.Do)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.Errorf("redisq: %s", resp.Status)
+	}
+	var pkg ZKillPackage
+	if err := json.NewDecoder(resp.Body).Decode(&pkg); err != nil {
+		return false, errors.Wrap(err, "redisq decode")
+	}
+	if pkg.Package == nil {
+		return false, nil
+	}
+	rawZKB, err := json.Marshal(pkg.Package.Zkb)
+	if err != nil {
+		return false, err
+	}
+	rawKM := []byte(pkg.Package.Killmail)
+	if len(rawKM) == 0 || string(rawKM) == "null" {
+		// Only the ID and hash were sent, so get the killmail from ESI. If
+		// that fails, FetchKillmails retries later.
+		if rawKM, err = fetchESIKillmail(ctx, pkg.Package.KillID, pkg.Package.Zkb.Hash); err != nil {
+			slog.Error("fetch hashes", "killmail", pkg.Package.KillID, "err", err)
+			span.RecordError(err)
+			s.ingest.failed("esi")
+			if err := s.storeHash(context.Background(), pkg.Package.KillID, pkg.Package.Zkb.Hash); err != nil {
+				slog.Error("fetch hashes", "killmail", pkg.Package.KillID, "err", err)
+			}
+			s.checkpointKillmail(context.Background(), checkpointRedisQ, pkg.Package.KillID)
+			return true, nil
 		}
-		rawZKB, err := json.Marshal(pkg.Package.Zkb)
-		if err != nil {
-			panic(err)
+	}
+	// We don't want the db txn to fail if ctx is canceled, but do want its
+	// queries traced.
+	dbCtx := trace.ContextWithSpan(context.Background(), span)
+	if err := s.storeKillmail(dbCtx, pkg.Package.KillID, pkg.Package.Zkb.Hash, rawKM, rawZKB); err != nil {
+		slog.Error("fetch hashes", "killmail", pkg.Package.KillID, "err", err)
+		span.RecordError(err)
+	}
+	s.checkpointKillmail(dbCtx, checkpointRedisQ, pkg.Package.KillID)
+	return true, nil
+}
+
+// storeHash records a killmail ID and hash whose killmail hasn't been
+// fetched yet, for FetchKillmails.
+func (s *EFContext) storeHash(ctx context.Context, id int, hash string) error {
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT
+		INTO
+			hashes (id, hash)
+		VALUES
+			($1, $2)
+		ON CONFLICT
+			(id)
+		DO
+			NOTHING
+	`, id, hash)
+	return errors.Wrap(err, "store hash")
+}
+
+// FetchKillmails fetches the killmails of stored hashes from ESI with the
+// worker pool until none are left, ctx is canceled, or fetches fail.
+func (s *EFContext) FetchKillmails(ctx context.Context) {
+	s.runWorkers(ctx, s.fetchKillmail)
+}
+
+// fetchKillmail fetches one of the killmails of stored hashes partitioned to
+// worker, reporting whether there may be more.
+func (s *EFContext) fetchKillmail(ctx context.Context, worker, workers int) bool {
+	var id int
+	var hash string
+	// Hashes that failed before are tried after the others so one failing
+	// fetch can't hold up the worker's partition.
+	if err := s.DB.QueryRowContext(ctx, `SELECT id, hash FROM hashes WHERE processed = 0 AND id % $2 = $1 ORDER BY attempts LIMIT 1`, worker, workers).Scan(&id, &hash); err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("fetch killmails", "err", err)
 		}
-		if err := crdb.ExecuteTx(dbCtx, s.DB, nil, func(txn *sql.Tx) error {
-			if _, err := txn.ExecContext(dbCtx, `
-				INSERT
-				INTO
-					hashes (id, hash, processed)
-				VALUES
-					($1, $2, $3)
-				ON CONFLICT
-					(id)
-				DO
-					NOTHING
-			`, pkg.Package.KillID, pkg.Package.Zkb.Hash, ProcHashFetched); err != nil {
-				return err
-			}
-			if _, err := txn.ExecContext(dbCtx, `
-				INSERT
-				INTO
-					killmails (id, km, zkb)
-				VALUES
-					($1, $2, $3)
-				ON CONFLICT
-					(id)
-				DO
-					NOTHING
-			`, pkg.Package.KillID, rawKM, rawZKB); err != nil {
-				return err
-			}
-			return nil
-		}); err != nil {
-			log.Print(err)
-		} else {
-			log.Println("inserted", pkg.Package.KillID)
+		return false
+	}
+	rawKM, err := fetchESIKillmail(ctx, id, hash)
+	if err != nil {
+		slog.Error("fetch killmails", "killmail", id, "err", err)
+		s.ingest.failed("esi")
+		s.fetchFailed(ctx, id, err)
+		return false
+	}
+	// zkb data isn't known; ProcessFits treats it as empty.
+	if err := s.storeKillmail(ctx, id, hash, rawKM, nil); err != nil {
+		slog.Error("fetch killmails", "killmail", id, "err", err)
+		return false
+	}
+	return true
+}
+
+// fetchAttemptLimit is how many times fetching a killmail from ESI may fail
+// before its hash is marked failed.
+const fetchAttemptLimit = 5
+
+// fetchFailed records a failed fetch of killmail id, marking its hash failed
+// if ESI refused it or it has failed fetchAttemptLimit times.
+func (s *EFContext) fetchFailed(ctx context.Context, id int, cause error) {
+	var proc int
+	if err := s.DB.QueryRowContext(ctx, `
+		UPDATE
+			hashes
+		SET
+			attempts = attempts + 1,
+			processed = CASE WHEN $2 OR attempts + 1 >= $3 THEN $4 ELSE processed END
+		WHERE
+			id = $1
+		RETURNING
+			processed
+	`, id, permanentESIError(cause), fetchAttemptLimit, ProcHashFailed).Scan(&proc); err != nil {
+		slog.Error("record fetch failure", "killmail", id, "err", err)
+		return
+	}
+	if proc == ProcHashFailed {
+		slog.Warn("gave up fetching killmail", "killmail", id, "err", cause)
+		s.deadLetter(ctx, int32(id), deadLetterFetch, cause)
+	}
+}
+
+// storeKillmail inserts a fetched killmail for processing by ProcessFits.
+// Killmails already stored are ignored. rawZKB may be nil if zkb's data
+// isn't known.
+func (s *EFContext) storeKillmail(ctx context.Context, id int, hash string, rawKM, rawZKB []byte) error {
+	if rawZKB == nil {
+		rawZKB = []byte("{}")
+	}
+	killed := killmailTime(rawKM)
+	err := crdb.ExecuteTx(ctx, s.DB, nil, func(txn *sql.Tx) error {
+		if _, err := txn.ExecContext(ctx, `
+			INSERT
+			INTO
+				hashes (id, hash, processed)
+			VALUES
+				($1, $2, $3)
+			ON CONFLICT
+				(id)
+			DO
+				UPDATE SET processed = excluded.processed
+		`, id, hash, ProcHashFetched); err != nil {
+			return err
+		}
+		if _, err := txn.ExecContext(ctx, `
+			INSERT
+			INTO
+				killmails (id, km, zkb, killed)
+			VALUES
+				($1, $2, $3, $4)
+			ON CONFLICT
+				(id)
+			DO
+				NOTHING
+		`, id, rawKM, rawZKB, killed); err != nil {
+			return err
 		}
+		return nil
+	})
+	if err != nil {
+		s.ingest.failed("store")
+		return err
+	}
+	if killed.Valid {
+		s.ingest.stored(killed.Time)
+	}
+	slog.Info("inserted", "killmail", id)
+	return nil
+}
+
+// killmailTime returns the time of an ESI killmail, or null if it can't be
+// decoded.
+func killmailTime(rawKM []byte) sql.NullTime {
+	var km struct {
+		KillmailTime time.Time `json:"killmail_time"`
+	}
+	if json.Unmarshal(rawKM, &km) != nil || km.KillmailTime.IsZero() {
+		return sql.NullTime{}
 	}
+	return sql.NullTime{Time: km.KillmailTime, Valid: true}
 }
 
 type ZKillPackage struct {
 	Package *struct {
-		KillID   int `json:"killID"`
-		Killmail struct {
-			Attackers []struct {
-				CorporationID  int  `json:"corporation_id"`
-				DamageDone     int  `json:"damage_done"`
-				FinalBlow      bool `json:"final_blow"`
-				SecurityStatus int  `json:"security_status"`
-				ShipTypeID     int  `json:"ship_type_id"`
-			} `json:"attackers"`
-			KillmailID    int       `json:"killmail_id"`
-			KillmailTime  time.Time `json:"killmail_time"`
-			SolarSystemID int       `json:"solar_system_id"`
-			Victim        struct {
-				AllianceID    int `json:"alliance_id"`
-				CharacterID   int `json:"character_id"`
-				CorporationID int `json:"corporation_id"`
-				DamageTaken   int `json:"damage_taken"`
-				Items         []struct {
-					Flag              int `json:"flag"`
-					ItemTypeID        int `json:"item_type_id"`
-					QuantityDropped   int `json:"quantity_dropped,omitempty"`
-					Singleton         int `json:"singleton"`
-					QuantityDestroyed int `json:"quantity_destroyed,omitempty"`
-				} `json:"items"`
-				Position struct {
-					X float64 `json:"x"`
-					Y float64 `json:"y"`
-					Z float64 `json:"z"`
-				} `json:"position"`
-				ShipTypeID int `json:"ship_type_id"`
-			} `json:"victim"`
-		} `json:"killmail"`
-		Zkb Zkb `json:"zkb"`
+		KillID int `json:"killID"`
+		// Killmail is the ESI killmail, kept raw so no fields are lost.
+		Killmail json.RawMessage `json:"killmail"`
+		Zkb      Zkb             `json:"zkb"`
 	} `json:"package"`
 }
 
 type Zkb struct {
-	LocationID  int     `json:"locationID"`
-	Hash        string  `json:"hash"`
-	FittedValue float64 `json:"fittedValue"`
-	TotalValue  float64 `json:"totalValue"`
-	Points      int     `json:"points"`
-	Npc         bool    `json:"npc"`
-	Solo        bool    `json:"solo"`
-	Awox        bool    `json:"awox"`
-	Href        string  `json:"href"`
+	LocationID   int     `json:"locationID"`
+	Hash         string  `json:"hash"`
+	FittedValue  float64 `json:"fittedValue"`
+	DroppedValue float64 `json:"droppedValue"`
+	TotalValue   float64 `json:"totalValue"`
+	Points       int     `json:"points"`
+	Npc          bool    `json:"npc"`
+	Solo         bool    `json:"solo"`
+	Awox         bool    `json:"awox"`
+	Href         string  `json:"href"`
 }
 
-func (s *EFContext) ProcessFits(ctx context.Context) {
-	dbCtx := context.Background()
-	for {
-		if ctx.Err() != nil {
-			return
+// solo reports whether km was a solo kill. zkb's flag is used if known,
+// otherwise a kill is solo if a single player was involved.
+func (k KM) solo(zkb Zkb, known bool) bool {
+	if known {
+		return zkb.Solo
+	}
+	players := 0
+	for _, a := range k.Attackers {
+		if a.CharacterId != 0 {
+			players++
 		}
+	}
+	return players == 1
+}
 
-		if err := crdb.ExecuteTx(dbCtx, s.DB, nil, s.processKM); err != nil {
-			log.Printf("process fits: %+v", err)
-			return
-		}
+// ProcessFits processes stored killmails with the worker pool until none are
+// left or ctx is canceled.
+func (s *EFContext) ProcessFits(ctx context.Context) {
+	s.runWorkers(ctx, s.processFit)
+}
+
+// processFit processes one of the unprocessed killmails partitioned to
+// worker, reporting whether there may be more.
+func (s *EFContext) processFit(ctx context.Context, worker, workers int) bool {
+	_, span := tracer.Start(ctx, "ProcessFits.process")
+	defer span.End()
+	dbCtx := trace.ContextWithSpan(context.Background(), span)
+
+	var res processedKM
+	if err := crdb.ExecuteTx(dbCtx, s.DB, nil, func(tx *sql.Tx) error {
+		var err error
+		res, err = s.processKM(tx, worker, workers)
+		return err
+	}); err == sql.ErrNoRows {
+		return false
+	} else if err != nil {
+		slog.Error("process fits", "killmail", res.ID, "err", err)
+		span.RecordError(err)
+		s.ingest.failed("process")
+		// Move a failed killmail aside so it doesn't block the queue.
+		return res.ID != 0 && s.deadLetter(dbCtx, res.ID, deadLetterProcess, err) == nil
+	}
+	s.ingest.processed(res.Time)
+	if res.Fit != nil && !res.Reprocessed {
+		s.live.publish(res.Fit)
 	}
+	return true
+}
+
+// processedKM is a killmail processed by processKM.
+type processedKM struct {
+	ID   int32
+	Time time.Time
+	// Fit is set if a fit was stored.
+	Fit *LiveFit
+	// Reprocessed is set if the killmail had been processed before.
+	Reprocessed bool
 }
 
-func (s *EFContext) processKM(tx *sql.Tx) error {
+// processKM processes one unprocessed killmail partitioned to worker. The ID
+// of the killmail is also returned with errors processing it.
+func (s *EFContext) processKM(tx *sql.Tx, worker, workers int) (processedKM, error) {
+	var res processedKM
+	var proc int
 	var rawKM, rawZKB []byte
-	if err := tx.QueryRow(`SELECT km, zkb FROM killmails WHERE processed = 0 LIMIT 1`).Scan(&rawKM, &rawZKB); err != nil {
-		return err
+	if err := tx.QueryRow(`
+		SELECT
+			id, processed, km, zkb
+		FROM
+			killmails
+		WHERE
+			processed IN (0, $3) AND id % $2 = $1
+		LIMIT
+			1
+	`, worker, workers, ProcKMReprocess).Scan(&res.ID, &proc, &rawKM, &rawZKB); err != nil {
+		return res, err
 	}
+	res.Reprocessed = proc == ProcKMReprocess
+	var err error
+	res.Fit, res.Time, err = s.processRawKM(tx, rawKM, rawZKB, res.Reprocessed)
+	return res, err
+}
 
+// processRawKM stores the fit of a killmail, replacing any stored before, so
+// processing a killmail again is a no-op unless the derivation changed.
+// Webhooks are only enqueued the first time.
+func (s *EFContext) processRawKM(tx *sql.Tx, rawKM, rawZKB []byte, reprocess bool) (*LiveFit, time.Time, error) {
 	var km KM
 	if err := json.Unmarshal(rawKM, &km); err != nil {
-		panic(err)
+		return nil, time.Time{}, errors.Wrap(err, "decode killmail")
 	}
 	var zkb Zkb
 	if len(rawZKB) > 0 {
 		if err := json.Unmarshal(rawZKB, &zkb); err != nil {
-			panic(err)
+			return nil, time.Time{}, errors.Wrap(err, "decode zkb")
 		}
 	}
-	// Only process fits where there's something fitted to a high
-	// slot. This filters out boring fits and stuff like drones.
-	hi, _, _, _, _, items := km.Items(s)
-	hiCount := 0
-	for _, h := range hi {
-		if h.ID > 0 {
-			hiCount++
+	// zkb data always has the hash, so it's missing if that is.
+	zkbKnown := zkb.Hash != ""
+	// Only process fits where there's something fitted to a high (or, for
+	// structures, service) slot. This filters out boring fits and stuff like
+	// drones.
+	racks, items := km.Items(s)
+	var fit *LiveFit
+	fitted := 0
+	for _, rack := range [][8]ItemCharge{racks.Hi, racks.Service} {
+		for _, h := range rack {
+			if h.ID > 0 {
+				fitted++
+			}
 		}
 	}
-	if hiCount > 0 {
+	if rule := s.skipFit(km, racks); rule != "" && fitted > 0 {
+		slog.Debug("skipped fit", "killmail", km.KillmailId, "rule", rule)
+		fitted = 0
+	}
+	if fitted > 0 {
 		v := km.Victim
+		g := s.Global()
 		var args []interface{}
 		args = append(args, km.KillmailId, v.ShipTypeId, km.SolarSystemId)
 		// Find items per slot.
-		filter := func(f func(Slot) bool) []byte {
-			var items []int32
+		filter := func(f func(Slot) bool) []int32 {
+			// Not nil, which would be stored as NULL.
+			items := []int32{}
 			for _, i := range v.Items {
 				if f(Slot(i.Flag)) {
 					items = append(items, i.ItemTypeId)
 				}
 			}
-			enc, err := json.Marshal(&items)
-			if err != nil {
-				panic(err)
-			}
-			return enc
+			return items
 		}
 		args = append(args, filter(IsHigh))
 		args = append(args, filter(IsMedium))
 		args = append(args, filter(IsLow))
 		args = append(args, filter(IsRig))
 		args = append(args, filter(IsSub))
-		enc, err := json.Marshal(&items)
-		if err != nil {
-			panic(err)
-		}
-		args = append(args, enc)
+		args = append(args, items)
 		args = append(args, int64(zkb.FittedValue))
+		args = append(args, nullID(v.CharacterId), nullID(v.CorporationId), nullID(v.AllianceId))
+		args = append(args, g.Groups[g.Items[v.ShipTypeId].Group].Category)
+		args = append(args, zkb.Npc, km.solo(zkb, zkbKnown), zkb.Awox, nullID(int32(zkb.LocationID)))
+		args = append(args, int64(zkb.DroppedValue), zkb.Points, reprocess)
 
-		if _, err := tx.Exec(`
+		var seq int64
+		if err := tx.QueryRow(`
 			INSERT
 			INTO
 				fits
 					(
+						seq,
 						killmail,
 						ship,
 						solarsystem,
@@ -343,54 +604,139 @@ func (s *EFContext) processKM(tx *sql.Tx) error {
 						rig,
 						sub,
 						items,
-						cost
+						cost,
+						character,
+						corporation,
+						alliance,
+						category,
+						npc,
+						solo,
+						awox,
+						location,
+						dropped,
+						points
 					)
 			VALUES
-				($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+				(nextval('fits_seq'), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
 			ON CONFLICT
 				(killmail)
+			DO
+				UPDATE SET
+					ship = excluded.ship,
+					solarsystem = excluded.solarsystem,
+					hi = excluded.hi,
+					med = excluded.med,
+					low = excluded.low,
+					rig = excluded.rig,
+					sub = excluded.sub,
+					items = excluded.items,
+					-- Reprocessing keeps costs recomputed by recomputeCosts.
+					cost = CASE WHEN $21 THEN fits.cost ELSE excluded.cost END,
+					character = excluded.character,
+					corporation = excluded.corporation,
+					alliance = excluded.alliance,
+					category = excluded.category,
+					npc = excluded.npc,
+					solo = excluded.solo,
+					awox = excluded.awox,
+					location = excluded.location,
+					dropped = excluded.dropped,
+					points = excluded.points
+			RETURNING
+				COALESCE(seq, 0)
+		`, args...).Scan(&seq); err != nil {
+			return nil, time.Time{}, errors.Wrap(err, "upsert")
+		}
+		if _, err := tx.Exec(`
+			INSERT
+			INTO
+				names (id)
+			SELECT
+				unnest($1::INT4[])
+			ON CONFLICT
+				(id)
 			DO
 				NOTHING
-		`, args...); err != nil {
-			return errors.Wrap(err, "upsert")
+		`, km.entityIDs()); err != nil {
+			return nil, time.Time{}, errors.Wrap(err, "insert names")
+		}
+		if !reprocess {
+			if err := enqueueWebhooks(tx, km, items); err != nil {
+				return nil, time.Time{}, err
+			}
+		}
+		fit = &LiveFit{
+			Killmail: km.KillmailId,
+			Ship:     v.ShipTypeId,
+			Name:     g.Items[v.ShipTypeId].Name,
+			Cost:     int64(zkb.FittedValue),
+			items:    items,
+			seq:      seq,
+		}
+	} else if reprocess {
+		// The fit no longer qualifies, such as after an ingestion rule change.
+		if _, err := tx.Exec(`DELETE FROM fits WHERE killmail = $1`, km.KillmailId); err != nil {
+			return nil, time.Time{}, errors.Wrap(err, "delete fit")
 		}
 	}
 	proc := ProcKMFitAdded
 	if zkb.FittedValue > 0 {
 		proc = ProcKMCostAdded
 	}
-	if _, err := tx.Exec(`UPDATE killmails SET processed = $2 WHERE id = $1`, km.KillmailId, proc); err != nil {
-		return errors.Wrap(err, "update killmails")
+	if _, err := tx.Exec(`UPDATE killmails SET processed = $2, processed_at = now() WHERE id = $1`, km.KillmailId, proc); err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "update killmails")
 	}
 
-	fmt.Println("processed km", km.KillmailId, "to", proc)
-	return nil
+	if _, err := tx.Exec(`DELETE FROM dead_letter WHERE killmail = $1`, km.KillmailId); err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "delete dead letter")
+	}
+
+	slog.Info("processed", "killmail", km.KillmailId, "proc", proc)
+	return fit, km.KillmailTime, nil
+}
+
+// Racks are the modules fitted to each rack of a ship or structure. Service
+// and Fighter are only used by structures; Fighter holds the squadron in
+// each fighter tube.
+type Racks struct {
+	Hi, Med, Low, Rig, Sub [8]ItemCharge
+	Service                [8]ItemCharge
+	Fighter                [8]ItemCharge
 }
 
-func (k KM) Items(s *EFContext) (hi, med, low, rig, sub [8]ItemCharge, items []int32) {
+// Items returns the victim's fitted racks and the IDs of its ship and
+// fitted items.
+func (k KM) Items(s *EFContext) (r Racks, items []int32) {
+	g := s.Global()
 	items = append(items, k.Victim.ShipTypeId)
 	for _, i := range k.Victim.Items {
 		flag := Slot(i.Flag)
-		item := s.Global.Items[i.ItemTypeId]
-		charge := s.Global.Groups[item.Group].IsCharge()
+		item := g.Items[i.ItemTypeId]
+		charge := g.Groups[item.Group].IsCharge()
 		var n Slot
 		var cur *[8]ItemCharge
 		switch {
 		case IsHigh(flag):
 			n = HiSlot0
-			cur = &hi
+			cur = &r.Hi
 		case IsMedium(flag):
 			n = MedSlot0
-			cur = &med
+			cur = &r.Med
 		case IsLow(flag):
 			n = LoSlot0
-			cur = &low
+			cur = &r.Low
 		case IsRig(flag):
 			n = RigSlot0
-			cur = &rig
+			cur = &r.Rig
 		case IsSub(flag):
 			n = SubSlot0
-			cur = &sub
+			cur = &r.Sub
+		case IsService(flag):
+			n = ServiceSlot0
+			cur = &r.Service
+		case flag.IsFighterTube():
+			n = FighterTube0
+			cur = &r.Fighter
 		default:
 			continue
 		}
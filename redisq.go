@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	// redisqTTW is how long each continuous redisq request waits for a
+	// killmail, in seconds.
+	redisqTTW = 10
+	// redisqMaxBackoff caps the wait between failed redisq requests.
+	redisqMaxBackoff = time.Minute
+)
+
+// ListenRedisQ continuously consumes killmails from redisq and processes
+// them as they arrive, until ctx is canceled. redisq keeps the position of
+// the queue for a few hours, so restarting with the same queue ID resumes
+// where the previous run stopped.
+func (s *EFContext) ListenRedisQ(ctx context.Context) {
+	slog.Info("listening on redisq", "queue", s.redisqQueue)
+	s.warnIngestionGap(ctx, checkpointRedisQ, redisqRetention)
+	backoff := time.Second
+	for ctx.Err() == nil {
+		ok, err := s.fetchRedisQ(ctx, redisqTTW)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("redisq", "err", err, "backoff", backoff)
+			s.ingest.failed(checkpointRedisQ)
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, redisqMaxBackoff)
+			continue
+		}
+		backoff = time.Second
+		if ok {
+			s.ProcessFits(ctx)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// replicaCheckInterval is how often the read replica's health is
+	// checked.
+	replicaCheckInterval = time.Second * 10
+	replicaCheckTimeout  = time.Second * 2
+)
+
+// replica is a read replica of the database. Reads of read-only requests go
+// to it while it is healthy and fall back to the primary while it isn't.
+type replica struct {
+	X       *sqlx.DB
+	healthy atomic.Bool
+}
+
+// watch checks the replica's health every replicaCheckInterval until ctx is
+// done.
+func (rep *replica) watch(ctx context.Context) {
+	for {
+		pctx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+		err := rep.X.PingContext(pctx)
+		cancel()
+		if healthy := err == nil; rep.healthy.Swap(healthy) != healthy {
+			if healthy {
+				slog.Info("read replica healthy")
+			} else {
+				slog.Warn("read replica unhealthy, reading from primary", "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(replicaCheckInterval):
+		}
+	}
+}
+
+type readOnlyContextKey struct{}
+
+// withReadOnly marks ctx as belonging to a request that only reads, so its
+// queries may go to the read replica.
+func withReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyContextKey{}, true)
+}
+
+// read returns the database to query for ctx: the read replica if ctx is
+// read-only and the replica is healthy, and the primary otherwise. Queries
+// that must see the request's own writes use s.X or s.DB instead.
+func (s *EFContext) read(ctx context.Context) *sqlx.DB {
+	if s.replica != nil && s.replica.healthy.Load() && ctx.Value(readOnlyContextKey{}) != nil {
+		return s.replica.X
+	}
+	return s.X
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+)
+
+const (
+	// reportInterval is how often a new meta report is generated.
+	reportInterval = time.Hour
+	// reportFits is the number of most recent fits included in a report.
+	reportFits = 10000
+)
+
+// Report is a summary of module choices in recent fits, grouped by hull
+// class (the ship's group) and then by category.
+type Report struct {
+	Generated time.Time
+	Fits      int
+	Hulls     map[string]*HullReport
+}
+
+type HullReport struct {
+	Fits int
+	// Categories maps a category (e.g., "propulsion") to counts of fits
+	// per option (e.g., "afterburner").
+	Categories map[string]map[string]int
+}
+
+// reportCategories classifies a fit's modules. Each returns the option the
+// fit uses for that category, or "" to exclude the fit from it.
+var reportCategories = map[string]func(g *staticData, items []Item) string{
+	"propulsion": func(g *staticData, items []Item) string {
+		var ab, mwd bool
+		for _, item := range items {
+			switch {
+			case strings.Contains(item.Lower, "microwarpdrive"):
+				mwd = true
+			case strings.Contains(item.Lower, "afterburner"):
+				ab = true
+			}
+		}
+		switch {
+		case ab && mwd:
+			return "dual prop"
+		case mwd:
+			return "microwarpdrive"
+		case ab:
+			return "afterburner"
+		}
+		return "none"
+	},
+	"tank": func(g *staticData, items []Item) string {
+		var armor, shield int
+		for _, item := range items {
+			name := strings.ToLower(g.Groups[item.Group].Name)
+			switch {
+			case strings.Contains(name, "armor"):
+				armor++
+			case strings.Contains(name, "shield"):
+				shield++
+			}
+		}
+		switch {
+		case armor > shield:
+			return "armor"
+		case shield > armor:
+			return "shield"
+		case armor > 0:
+			return "mixed"
+		}
+		return "none"
+	},
+}
+
+// GenerateReport creates a new meta report if the latest one is older than
+// reportInterval.
+func (s *EFContext) GenerateReport(ctx context.Context) {
+	var last sql.NullTime
+	if err := s.DB.QueryRowContext(ctx, `SELECT max(generated) FROM reports`).Scan(&last); err != nil {
+		slog.Error("generate report", "err", err)
+		return
+	}
+	if last.Valid && time.Since(last.Time) < reportInterval {
+		return
+	}
+	s.generateReport(ctx)
+}
+
+// generateReport creates a new meta report from the latest fits.
+func (s *EFContext) generateReport(ctx context.Context) {
+	var fits []struct {
+		Ship              int32
+		Hi, Med, Low, Rig int32Array
+	}
+	if err := s.X.SelectContext(ctx, &fits, `
+		SELECT
+			ship,
+			hi,
+			med,
+			low,
+			rig
+		FROM
+			fits
+		ORDER BY
+			killmail DESC
+		LIMIT
+			$1
+	`, reportFits); err != nil {
+		slog.Error("generate report", "err", err)
+		return
+	}
+
+	report := Report{
+		Generated: time.Now().UTC(),
+		Hulls:     map[string]*HullReport{},
+	}
+	g := s.Global()
+	for _, f := range fits {
+		hull := g.Groups[g.Items[f.Ship].Group].Name
+		if hull == "" {
+			continue
+		}
+		var items []Item
+		for _, rack := range []int32Array{f.Hi, f.Med, f.Low, f.Rig} {
+			for _, id := range rack {
+				items = append(items, g.Items[id])
+			}
+		}
+		hr := report.Hulls[hull]
+		if hr == nil {
+			hr = &HullReport{Categories: map[string]map[string]int{}}
+			report.Hulls[hull] = hr
+		}
+		for name, f := range reportCategories {
+			opt := f(g, items)
+			if opt == "" {
+				continue
+			}
+			if hr.Categories[name] == nil {
+				hr.Categories[name] = map[string]int{}
+			}
+			hr.Categories[name][opt]++
+		}
+		hr.Fits++
+		report.Fits++
+	}
+
+	enc, err := json.Marshal(report)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := s.DB.ExecContext(ctx, `INSERT INTO reports (generated, report) VALUES ($1, $2)`, report.Generated, enc); err != nil {
+		slog.Error("generate report", "err", err)
+		return
+	}
+	slog.Info("generated report", "fits", report.Fits)
+}
+
+// Report returns the latest meta report.
+func (s *EFContext) Report(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	return s.latestReport(ctx)
+}
+
+func (s *EFContext) latestReport(ctx context.Context) (json.RawMessage, error) {
+	var raw []byte
+	if err := s.read(ctx).QueryRowContext(ctx, `SELECT report FROM reports ORDER BY generated DESC LIMIT 1`).Scan(&raw); err == sql.ErrNoRows {
+		return nil, notFound("no report generated")
+	} else if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(raw), nil
+}
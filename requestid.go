@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+type requestIDContextKey struct{}
+
+// validRequestID matches client-supplied request IDs that are reused rather
+// than replaced.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID returns the X-Request-Id of r if it is valid, or a new random ID.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); validRequestID.MatchString(id) {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDFromContext returns the request ID of ctx, or "" outside of a
+// request.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// httpClient is used for outgoing requests. It forwards the request ID of a
+// request's context as X-Request-Id and traces the request.
+var httpClient = &http.Client{Transport: requestIDTransport{RoundTripper: http.DefaultTransport}}
+
+type requestIDTransport struct {
+	http.RoundTripper
+	// external is set for requests to user-supplied URLs, such as webhooks,
+	// which are traced but not sent the request ID or trace context.
+	external bool
+}
+
+func (t requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Only the scheme and host are recorded, as paths and queries of
+	// webhook URLs are secrets.
+	ctx, span := startChildSpan(req.Context(), "HTTP "+req.Method,
+		semconv.HTTPMethod(req.Method),
+		semconv.HTTPURL(req.URL.Scheme+"://"+req.URL.Host),
+	)
+	defer span.End()
+	req = req.Clone(ctx)
+	if !t.external {
+		if id := requestIDFromContext(ctx); id != "" {
+			req.Header.Set("X-Request-Id", id)
+		}
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	}
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
+	return resp, nil
+}
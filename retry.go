@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// retryAttempts is how many times an upstream request is tried.
+	retryAttempts = 4
+	retryBase     = 500 * time.Millisecond
+	retryMax      = 30 * time.Second
+)
+
+// throttled counts rate limited upstream responses by host, for debugVars.
+var throttled throttleCounts
+
+type throttleCounts struct {
+	sync.Mutex
+	hosts map[string]int64
+}
+
+func (t *throttleCounts) add(host string) {
+	t.Lock()
+	defer t.Unlock()
+	if t.hosts == nil {
+		t.hosts = map[string]int64{}
+	}
+	t.hosts[host]++
+}
+
+// vars returns a copy of the counts.
+func (t *throttleCounts) vars() map[string]int64 {
+	t.Lock()
+	defer t.Unlock()
+	return copyCounts(t.hosts)
+}
+
+// retryDo sends req with send, retrying transient failures with jittered
+// exponential backoff until retryAttempts is reached or req's context is
+// done. Transport errors and 429, 420 (ESI's error limit) and 5xx responses
+// are transient; a longer Retry-After is honored. Other responses, including
+// permanent errors, are returned for the caller to check.
+func retryDo(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	ctx := req.Context()
+	backoff := retryBase
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := send(req)
+		if ctx.Err() != nil {
+			return resp, err
+		}
+		if resp != nil && throttledStatus(resp.StatusCode) {
+			throttled.add(req.URL.Host)
+			slog.Warn("upstream rate limited", "host", req.URL.Host, "status", resp.Status)
+		}
+		wait, retry := retryWait(resp, err)
+		if !retry || attempt == retryAttempts {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		// Full jitter keeps clients that failed together from retrying
+		// together.
+		wait = max(wait, time.Duration(rand.Int63n(int64(backoff))))
+		slog.Debug("retrying request", "url", req.URL.Redacted(), "attempt", attempt, "wait", wait, "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, retryMax)
+	}
+}
+
+// retryWait reports whether the result of a request is transient and how long
+// the server asked to wait before retrying.
+func retryWait(resp *http.Response, err error) (time.Duration, bool) {
+	if err != nil {
+		return 0, true
+	}
+	if !throttledStatus(resp.StatusCode) && resp.StatusCode < 500 {
+		return 0, false
+	}
+	secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	return min(time.Duration(secs)*time.Second, retryMax), true
+}
+
+// throttledStatus reports whether status means the client is rate limited.
+func throttledStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == statusErrorLimited
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/cockroachdb/cockroach-go/crdb"
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+)
+
+// maxSavedFits is how many fits a character may save.
+const maxSavedFits = 1000
+
+// SavedFits lists (GET), saves (POST), or removes (DELETE) the fits
+// bookmarked by the logged in character. Saved fits are listed most
+// recently saved first.
+func (s *EFContext) SavedFits(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	c, err := requireCharacter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return s.savedFits(ctx, c.ID)
+	}
+	v := &validator{form: r.Form}
+	v.maxCount("id", 1)
+	v.id("id", nil)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	if id <= 0 {
+		return nil, badRequest("id required")
+	}
+	switch r.Method {
+	case http.MethodPost:
+		// The count and insert share a transaction so concurrent saves
+		// can't exceed maxSavedFits.
+		err := crdb.ExecuteTx(ctx, s.DB, nil, func(tx *sql.Tx) error {
+			var n int
+			if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM saved_fits WHERE character = $1`, c.ID).Scan(&n); err != nil {
+				return errors.Wrap(err, "count saved fits")
+			}
+			if n >= maxSavedFits {
+				return badRequest("at most %d fits may be saved", maxSavedFits)
+			}
+			res, err := tx.ExecContext(ctx, `
+				INSERT
+				INTO
+					saved_fits (character, killmail)
+				SELECT
+					$1, killmail
+				FROM
+					fits
+				WHERE
+					killmail = $2
+				ON CONFLICT
+					DO NOTHING
+			`, c.ID, id)
+			if err != nil {
+				return errors.Wrap(err, "save fit")
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				var exists bool
+				if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM fits WHERE killmail = $1)`, id).Scan(&exists); err != nil {
+					return err
+				}
+				if !exists {
+					return notFound("unknown fit")
+				}
+			}
+			return nil
+		})
+		return nil, err
+	case http.MethodDelete:
+		res, err := s.DB.ExecContext(ctx, `DELETE FROM saved_fits WHERE character = $1 AND killmail = $2`, c.ID, id)
+		if err != nil {
+			return nil, errors.Wrap(err, "remove saved fit")
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, notFound("fit not saved")
+		}
+		return nil, nil
+	}
+	return nil, methodNotAllowed(r.Method)
+}
+
+// savedFits returns the fits saved by character, most recently saved first.
+// Saved fits whose fit was archived, or deleted by reprocessing because it
+// no longer qualifies, are left out.
+func (s *EFContext) savedFits(ctx context.Context, character int32) ([]*FitSummary, error) {
+	var rows []fitRow
+	// Saves are read from the primary so a fit just saved is listed.
+	if err := s.X.SelectContext(ctx, &rows, `
+		SELECT
+			f.killmail, f.ship, f.cost, f.dropped, f.points, COALESCE(fs.score, 0) AS score, f.hi, f.med, f.low
+		FROM
+			saved_fits AS sf
+			JOIN fits AS f ON f.killmail = sf.killmail
+			LEFT JOIN fit_scores AS fs ON fs.killmail = sf.killmail
+		WHERE
+			sf.character = $1
+		ORDER BY
+			sf.saved DESC
+	`, character); err != nil {
+		return nil, errors.Wrap(err, "saved fits")
+	}
+	g := s.Global()
+	ret := make([]*FitSummary, len(rows))
+	for i, row := range rows {
+		ret[i] = g.fitSummary(row)
+	}
+	return ret, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// staticDataKey is the config table key of the gob-encoded static data.
+const staticDataKey = "global"
+
+// staticData is the item and group data extracted from the SDE. A loaded
+// staticData is never modified, so readers may keep using a snapshot while a
+// new one is swapped in.
+type staticData struct {
+	Items  map[int32]Item
+	Groups map[int32]Group
+	// Attributes are the dogma attributes by ID.
+	Attributes map[int32]DogmaAttribute
+	// TypeAttributes are the dogma attribute values of each item by
+	// attribute ID.
+	TypeAttributes map[int32]map[int32]float64
+	// Mutations are how abyssal module types are created, by abyssal type.
+	Mutations map[int32]Mutation
+	// Systems, Constellations, and Regions are the universe map by ID.
+	Systems        map[int32]SolarSystem
+	Constellations map[int32]Constellation
+	Regions        map[int32]Region
+	// Checksum is CCP's checksum of the SDE the data was read from, if
+	// known.
+	Checksum string
+	// Loaded is when the data was read from the SDE.
+	Loaded time.Time
+
+	// groupItems are the items of each group, and itemNames the items by
+	// lower case name, derived by setGlobal.
+	groupItems map[int32][]int32
+	itemNames  map[string]int32
+}
+
+// Global returns the current static data. Callers should load it once and
+// use that snapshot throughout, so a reload midway can't mix two versions.
+func (s *EFContext) Global() *staticData {
+	return s.global.Load()
+}
+
+// setGlobal replaces the static data and rebuilds the search index, which is
+// derived from it.
+func (s *EFContext) setGlobal(ctx context.Context, g *staticData) error {
+	g.groupItems = map[int32][]int32{}
+	g.itemNames = map[string]int32{}
+	for id, item := range g.Items {
+		g.groupItems[item.Group] = append(g.groupItems[item.Group], id)
+		// Of items sharing a name, the lowest ID is the original.
+		if other, ok := g.itemNames[item.Lower]; !ok || id < other {
+			g.itemNames[item.Lower] = id
+		}
+	}
+	s.global.Store(g)
+	return s.buildSearchIndex(ctx)
+}
+
+// DogmaAttribute is a dogma attribute, such as a module's damage modifier.
+type DogmaAttribute struct {
+	ID          int32
+	Name        string
+	DisplayName string
+}
+
+// Mutation describes how mutaplasmids turn source modules into an abyssal
+// module type.
+type Mutation struct {
+	// Sources are the types that can be mutated into the abyssal type.
+	Sources []int32
+	// Attributes are the multiplier ranges applied to the mutated dogma
+	// attributes, by attribute ID.
+	Attributes map[int32]AttributeRange
+}
+
+// AttributeRange is the range of an attribute multiplier.
+type AttributeRange struct {
+	Min, Max float64
+}
+
+// openSDE opens the SDE at path, either an extracted directory or the
+// official sde.zip archive. The returned closer must be called when done.
+func openSDE(path string) (fs.FS, io.Closer, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		return os.DirFS(path), io.NopCloser(nil), nil
+	}
+	z, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, path)
+	}
+	return sdeRoot(z), z, nil
+}
+
+// sdeFormats are the readers of the supported SDE formats by name.
+var sdeFormats = map[string]func(fs.FS) (*staticData, error){
+	"yaml":     readSDE,
+	"fuzzwork": readFuzzwork,
+}
+
+// loadSDE reads static data from the SDE at path, as accepted by openSDE, in
+// the named format of sdeFormats.
+func loadSDE(path, format string) (*staticData, error) {
+	read := sdeFormats[format]
+	if read == nil {
+		return nil, errors.Errorf("unknown SDE format %q", format)
+	}
+	fsys, c, err := openSDE(path)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	g, err := read(fsys)
+	if err != nil {
+		return nil, err
+	}
+	g.Loaded = time.Now().UTC()
+	return g, nil
+}
+
+// readSDE reads the known groups, their items, and the items' dogma
+// attributes from the fsd directory of the SDE in fsys.
+func readSDE(fsys fs.FS) (*staticData, error) {
+	g := &staticData{
+		Groups:         map[int32]Group{},
+		Items:          map[int32]Item{},
+		Attributes:     map[int32]DogmaAttribute{},
+		TypeAttributes: map[int32]map[int32]float64{},
+		Mutations:      map[int32]Mutation{},
+	}
+	var groups map[int32]struct {
+		CategoryID int32 `yaml:"categoryID"`
+		Name       map[string]string
+	}
+	if err := readYAML(fsys, "fsd/groupIDs.yaml", &groups); err != nil {
+		return nil, err
+	}
+	for id, m := range groups {
+		grp := Group{
+			ID:       id,
+			Name:     m.Name["en"],
+			Category: m.CategoryID,
+		}
+		if !grp.IsKnown() {
+			continue
+		}
+		g.Groups[id] = grp
+	}
+	var types map[int32]struct {
+		GroupID int32 `yaml:"groupID"`
+		Name    map[string]string
+	}
+	if err := readYAML(fsys, "fsd/typeIDs.yaml", &types); err != nil {
+		return nil, err
+	}
+	for id, m := range types {
+		if _, ok := g.Groups[m.GroupID]; !ok {
+			continue
+		}
+		g.Items[id] = Item{
+			ID:    id,
+			Group: m.GroupID,
+			Name:  m.Name["en"],
+			Lower: strings.ToLower(m.Name["en"]),
+		}
+	}
+	var attrs map[int32]struct {
+		Name          string            `yaml:"name"`
+		DisplayNameID map[string]string `yaml:"displayNameID"`
+	}
+	if err := readYAML(fsys, "fsd/dogmaAttributes.yaml", &attrs); err != nil {
+		return nil, err
+	}
+	for id, m := range attrs {
+		g.Attributes[id] = DogmaAttribute{
+			ID:          id,
+			Name:        m.Name,
+			DisplayName: m.DisplayNameID["en"],
+		}
+	}
+	var dogma map[int32]struct {
+		DogmaAttributes []struct {
+			AttributeID int32   `yaml:"attributeID"`
+			Value       float64 `yaml:"value"`
+		} `yaml:"dogmaAttributes"`
+	}
+	if err := readYAML(fsys, "fsd/typeDogma.yaml", &dogma); err != nil {
+		return nil, err
+	}
+	for id, m := range dogma {
+		if _, ok := g.Items[id]; !ok {
+			continue
+		}
+		vals := make(map[int32]float64, len(m.DogmaAttributes))
+		for _, a := range m.DogmaAttributes {
+			vals[a.AttributeID] = a.Value
+		}
+		g.TypeAttributes[id] = vals
+	}
+	var dynamic map[int32]struct {
+		AttributeIDs       map[int32]AttributeRange `yaml:"attributeIDs"`
+		InputOutputMapping []struct {
+			ApplicableTypes []int32 `yaml:"applicableTypes"`
+			ResultingType   int32   `yaml:"resultingType"`
+		} `yaml:"inputOutputMapping"`
+	}
+	if err := readYAML(fsys, "fsd/dynamicItemAttributes.yaml", &dynamic); err != nil {
+		return nil, err
+	}
+	// Several mutaplasmids produce each abyssal type, so merge their sources
+	// and widen the attribute ranges to cover all of them.
+	for _, m := range dynamic {
+		for _, mapping := range m.InputOutputMapping {
+			mut, ok := g.Mutations[mapping.ResultingType]
+			if !ok {
+				mut.Attributes = map[int32]AttributeRange{}
+			}
+			for _, src := range mapping.ApplicableTypes {
+				if !containsInt32(mut.Sources, src) {
+					mut.Sources = append(mut.Sources, src)
+				}
+			}
+			for id, r := range m.AttributeIDs {
+				if cur, ok := mut.Attributes[id]; ok {
+					r.Min = math.Min(r.Min, cur.Min)
+					r.Max = math.Max(r.Max, cur.Max)
+				}
+				mut.Attributes[id] = r
+			}
+			g.Mutations[mapping.ResultingType] = mut
+		}
+	}
+	for _, mut := range g.Mutations {
+		sort.Slice(mut.Sources, func(i, j int) bool { return mut.Sources[i] < mut.Sources[j] })
+	}
+	if err := g.readUniverse(fsys); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func containsInt32(s []int32, v int32) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func readYAML(fsys fs.FS, path string, v interface{}) error {
+	slog.Info("reading SDE", "path", path)
+	return decodeYAML(fsys, path, v)
+}
+
+// decodeYAML is readYAML without logging, for the many small files of the
+// universe map.
+func decodeYAML(fsys fs.FS, path string, v interface{}) error {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return errors.Wrap(yaml.NewDecoder(f).Decode(v), path)
+}
+
+// loadStaticData returns the static data stored in the database, or nil if
+// none is stored.
+func (s *EFContext) loadStaticData(ctx context.Context) (*staticData, error) {
+	var raw []byte
+	err := s.DB.QueryRowContext(ctx, `SELECT val FROM config WHERE key = $1`, staticDataKey).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "load static data")
+	}
+	g := &staticData{}
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(g); err != nil {
+		return nil, errors.Wrap(err, "decode static data")
+	}
+	return g, nil
+}
+
+// storeStaticData saves g to the database, where other instances load it
+// from.
+func (s *EFContext) storeStaticData(ctx context.Context, g *staticData) error {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(g); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, `UPSERT INTO config (key, val) VALUES ($1, $2)`, staticDataKey, b.Bytes())
+	return errors.Wrap(err, "store static data")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sdeChecksumURL = "https://eve-static-data-export.s3-eu-west-1.amazonaws.com/tranquility/checksum"
+	sdeArchiveURL  = "https://eve-static-data-export.s3-eu-west-1.amazonaws.com/tranquility/sde.zip"
+
+	// sdeCheckInterval is how often UpdateSDE checks for a new SDE.
+	sdeCheckInterval = time.Hour
+	// sdeDiffLogMax is the most added or removed types logged by name.
+	sdeDiffLogMax = 50
+)
+
+// sdeCheck records when UpdateSDE last checked for a new SDE.
+type sdeCheck struct {
+	sync.Mutex
+	last time.Time
+}
+
+// UpdateSDE checks CCP's SDE checksum and, when it differs from that of the
+// stored static data, downloads the new SDE, stores it, and swaps it in. If
+// another instance already stored newer static data it is loaded instead.
+func (s *EFContext) UpdateSDE(ctx context.Context) {
+	s.sdeCheck.Lock()
+	defer s.sdeCheck.Unlock()
+	if time.Since(s.sdeCheck.last) < sdeCheckInterval {
+		return
+	}
+
+	checksum, err := fetchSDEChecksum(ctx)
+	if err != nil {
+		slog.Error("update sde", "err", err)
+		return
+	}
+	s.sdeCheck.last = time.Now()
+	cur := s.Global()
+	if checksum == cur.Checksum {
+		return
+	}
+
+	stored, err := s.loadStaticData(ctx)
+	if err != nil {
+		slog.Error("update sde", "err", err)
+		return
+	}
+	g := stored
+	if stored == nil || stored.Checksum != checksum {
+		slog.Info("downloading sde", "checksum", checksum)
+		if g, err = downloadSDE(ctx); err != nil {
+			slog.Error("update sde", "err", err)
+			return
+		}
+		g.Checksum = checksum
+		if err := s.storeStaticData(ctx, g); err != nil {
+			slog.Error("update sde", "err", err)
+			return
+		}
+	}
+	if err := s.setGlobal(ctx, g); err != nil {
+		slog.Error("update sde", "err", err)
+		return
+	}
+	logSDEDiff(cur, g)
+}
+
+func fetchSDEChecksum(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sdeChecksumURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("get %s: %s", sdeChecksumURL, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	// The file lists a checksum per archive, the first being sde.zip.
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return "", errors.New("empty sde checksum")
+	}
+	return fields[0], nil
+}
+
+// downloadSDE downloads the SDE archive and reads static data from it.
+func downloadSDE(ctx context.Context) (*staticData, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sdeArchiveURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("get %s: %s", sdeArchiveURL, resp.Status)
+	}
+	// zip needs random access, so spool the archive to disk.
+	f, err := os.CreateTemp("", "sde-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "download sde")
+	}
+	z, err := zip.NewReader(f, n)
+	if err != nil {
+		return nil, errors.Wrap(err, "open sde archive")
+	}
+	g, err := readSDE(sdeRoot(z))
+	if err != nil {
+		return nil, err
+	}
+	g.Loaded = time.Now().UTC()
+	return g, nil
+}
+
+// sdeRoot returns the directory of fsys containing the fsd directory, which
+// is the root or, in some archives, sde.
+func sdeRoot(fsys fs.FS) fs.FS {
+	if _, err := fs.Stat(fsys, "fsd"); err == nil {
+		return fsys
+	}
+	if sub, err := fs.Sub(fsys, "sde"); err == nil {
+		return sub
+	}
+	return fsys
+}
+
+// logSDEDiff logs the types added and removed between prev and next.
+func logSDEDiff(prev, next *staticData) {
+	var added, removed []string
+	for id, item := range next.Items {
+		if _, ok := prev.Items[id]; !ok {
+			added = append(added, item.Name)
+		}
+	}
+	for id, item := range prev.Items {
+		if _, ok := next.Items[id]; !ok {
+			removed = append(removed, item.Name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	slog.Info("updated sde",
+		"checksum", next.Checksum,
+		"items", len(next.Items),
+		"groups", len(next.Groups),
+		"added", len(added),
+		"removed", len(removed),
+	)
+	for _, names := range []struct {
+		msg   string
+		names []string
+	}{{"sde types added", added}, {"sde types removed", removed}} {
+		if len(names.names) == 0 {
+			continue
+		}
+		if len(names.names) > sdeDiffLogMax {
+			names.names = names.names[:sdeDiffLogMax]
+		}
+		slog.Info(names.msg, "types", names.names)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// fuzzyThreshold is the minimum trigram similarity for a fuzzy match.
+const fuzzyThreshold = 0.3
+
+// SearchResults are the results of a search and the normalized search term.
+type SearchResults struct {
+	Search  string
+	Results []SearchResult
+}
+
+// SearchResult is a single search match. Type is one of ship, item, group,
+// character, corporation, or alliance.
+type SearchResult struct {
+	Type string
+	Name string
+	ID   int32
+}
+
+type searchEntry struct {
+	SearchResult
+	lower    string
+	trigrams int
+}
+
+// searchIndex indexes item and group names. trigrams is used to find
+// approximate matches for misspelled search terms. sorted holds entry indexes
+// ordered by lowercase name for prefix lookups. An index is immutable once
+// built so it can be swapped while in use.
+type searchIndex struct {
+	entries  []searchEntry
+	trigrams map[string][]int
+	sorted   []int
+	synonyms map[string]string
+}
+
+// loadSynonyms reads the synonyms table. Rows may be edited in the database
+// and are picked up when the search index is rebuilt.
+func (s *EFContext) loadSynonyms(ctx context.Context) (map[string]string, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT abbr, expansion FROM synonyms`)
+	if err != nil {
+		return nil, errors.Wrap(err, "load synonyms")
+	}
+	defer rows.Close()
+	synonyms := map[string]string{}
+	for rows.Next() {
+		var abbr, expansion string
+		if err := rows.Scan(&abbr, &expansion); err != nil {
+			return nil, errors.Wrap(err, "load synonyms")
+		}
+		synonyms[strings.ToLower(abbr)] = strings.ToLower(expansion)
+	}
+	return synonyms, errors.Wrap(rows.Err(), "load synonyms")
+}
+
+// expandSynonyms replaces each abbreviated word in the lowercase term with
+// its expansion.
+func (idx *searchIndex) expandSynonyms(term string) string {
+	fields := strings.Fields(term)
+	for i, f := range fields {
+		if e, ok := idx.synonyms[f]; ok {
+			fields[i] = e
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// trigrams returns the unique trigrams of the lowercased s, padded so word
+// boundaries count.
+func trigrams(s string) []string {
+	seen := map[string]bool{}
+	var ret []string
+	for _, word := range strings.Fields(strings.ToLower(s)) {
+		r := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			t := string(r[i : i+3])
+			if seen[t] {
+				continue
+			}
+			seen[t] = true
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// buildSearchIndex loads the synonyms and indexes the current items and
+// groups, replacing the search index.
+func (s *EFContext) buildSearchIndex(ctx context.Context) error {
+	synonyms, err := s.loadSynonyms(ctx)
+	if err != nil {
+		return err
+	}
+	idx := &searchIndex{
+		trigrams: map[string][]int{},
+		synonyms: synonyms,
+	}
+	add := func(r SearchResult) {
+		ts := trigrams(r.Name)
+		for _, t := range ts {
+			idx.trigrams[t] = append(idx.trigrams[t], len(idx.entries))
+		}
+		idx.sorted = append(idx.sorted, len(idx.entries))
+		idx.entries = append(idx.entries, searchEntry{
+			SearchResult: r,
+			lower:        strings.ToLower(r.Name),
+			trigrams:     len(ts),
+		})
+	}
+	g := s.Global()
+	for id, group := range g.Groups {
+		add(SearchResult{
+			Type: "group",
+			Name: group.Name,
+			ID:   id,
+		})
+	}
+	for id, item := range g.Items {
+		if typ := searchCategories[g.Groups[item.Group].Category]; typ != "" {
+			add(SearchResult{
+				Type: typ,
+				Name: item.Name,
+				ID:   id,
+			})
+		}
+	}
+	sort.Slice(idx.sorted, func(i, j int) bool {
+		a, b := idx.entries[idx.sorted[i]], idx.entries[idx.sorted[j]]
+		if a.lower != b.lower {
+			return a.lower < b.lower
+		}
+		return a.ID < b.ID
+	})
+	s.search.Store(idx)
+	return nil
+}
+
+// match returns up to limit entries of type typ (or any type if empty) whose
+// lowercase names contain the lowercase term or all of its words. Results are
+// ranked by match quality (exact, prefix, substring, words), then by
+// popularity, then by name.
+func (idx *searchIndex) match(term, typ string, itemPop, groupPop map[int32]int, limit int) []SearchResult {
+	fields := strings.Fields(term)
+	// quality returns how well name matches term, lower is better, or -1 if
+	// it doesn't match.
+	quality := func(name string) int {
+		switch {
+		case name == term:
+			return 0
+		case strings.HasPrefix(name, term):
+			return 1
+		case strings.Contains(name, term):
+			return 2
+		}
+		for _, field := range fields {
+			if !strings.Contains(name, field) {
+				return -1
+			}
+		}
+		return 3
+	}
+	type ranked struct {
+		entry               int
+		quality, popularity int
+	}
+	var matches []ranked
+	for _, i := range idx.candidates(fields) {
+		e := idx.entries[i]
+		if typ != "" && e.Type != typ {
+			continue
+		}
+		q := quality(e.lower)
+		if q < 0 {
+			continue
+		}
+		pop := itemPop[e.ID]
+		if e.Type == "group" {
+			pop = groupPop[e.ID]
+		}
+		matches = append(matches, ranked{
+			entry:      i,
+			quality:    q,
+			popularity: pop,
+		})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		ea, eb := idx.entries[a.entry], idx.entries[b.entry]
+		switch {
+		case a.quality != b.quality:
+			return a.quality < b.quality
+		case a.popularity != b.popularity:
+			return a.popularity > b.popularity
+		case ea.Name != eb.Name:
+			return ea.Name < eb.Name
+		case ea.Type != eb.Type:
+			return ea.Type < eb.Type
+		}
+		return ea.ID < eb.ID
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	var ret []SearchResult
+	for _, m := range matches {
+		ret = append(ret, idx.entries[m.entry].SearchResult)
+	}
+	return ret
+}
+
+// candidates returns the indexes of entries that contain every trigram of
+// fields, a superset of the entries whose names contain all of fields. If
+// no field is long enough to have a trigram, all entries are returned.
+func (idx *searchIndex) candidates(fields []string) []int {
+	var lists [][]int
+	for _, f := range fields {
+		r := []rune(f)
+		for i := 0; i+3 <= len(r); i++ {
+			lists = append(lists, idx.trigrams[string(r[i:i+3])])
+		}
+	}
+	if len(lists) == 0 {
+		all := make([]int, len(idx.entries))
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+	// Posting lists are in ascending order, so intersect by merging.
+	ret := lists[0]
+	for _, l := range lists[1:] {
+		var next []int
+		for i, j := 0, 0; i < len(ret) && j < len(l); {
+			switch {
+			case ret[i] < l[j]:
+				i++
+			case ret[i] > l[j]:
+				j++
+			default:
+				next = append(next, ret[i])
+				i++
+				j++
+			}
+		}
+		ret = next
+	}
+	return ret
+}
+
+// prefix returns up to limit entries whose lowercase names start with the
+// lowercase term, in name order.
+func (idx *searchIndex) prefix(term string, limit int) []SearchResult {
+	term = strings.ToLower(term)
+	start := sort.Search(len(idx.sorted), func(i int) bool {
+		return idx.entries[idx.sorted[i]].lower >= term
+	})
+	var ret []SearchResult
+	for _, i := range idx.sorted[start:] {
+		e := idx.entries[i]
+		if !strings.HasPrefix(e.lower, term) || len(ret) >= limit {
+			break
+		}
+		ret = append(ret, e.SearchResult)
+	}
+	return ret
+}
+
+// fuzzy returns up to limit entries of type typ (or any type if empty) whose
+// names are similar to term, most similar first. Similarity is the Jaccard
+// index of the trigram sets.
+func (idx *searchIndex) fuzzy(term, typ string, limit int) []SearchResult {
+	ts := trigrams(term)
+	shared := map[int]int{}
+	for _, t := range ts {
+		for _, i := range idx.trigrams[t] {
+			shared[i]++
+		}
+	}
+	type match struct {
+		entry      int
+		similarity float64
+	}
+	var matches []match
+	for i, n := range shared {
+		if typ != "" && idx.entries[i].Type != typ {
+			continue
+		}
+		sim := float64(n) / float64(len(ts)+idx.entries[i].trigrams-n)
+		if sim < fuzzyThreshold {
+			continue
+		}
+		matches = append(matches, match{i, sim})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].similarity != matches[j].similarity {
+			return matches[i].similarity > matches[j].similarity
+		}
+		return idx.entries[matches[i].entry].Name < idx.entries[matches[j].entry].Name
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	ret := make([]SearchResult, len(matches))
+	for i, m := range matches {
+		ret[i] = idx.entries[m.entry].SearchResult
+	}
+	return ret
+}
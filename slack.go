@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// slackNotifier posts a Block Kit message summarizing the fit to Slack.
+type slackNotifier struct{}
+
+func (slackNotifier) validURL(u *url.URL) bool {
+	return u.Host == "hooks.slack.com" && strings.HasPrefix(u.Path, "/services/")
+}
+
+func (slackNotifier) payload(f *FitDetail) interface{} {
+	type text struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	type accessory struct {
+		Type     string `json:"type"`
+		ImageURL string `json:"image_url"`
+		AltText  string `json:"alt_text"`
+	}
+	type block struct {
+		Type      string     `json:"type"`
+		Text      text       `json:"text"`
+		Accessory *accessory `json:"accessory,omitempty"`
+	}
+	eft := f.EFT()
+	// Slack limits section text to 3000 characters.
+	eft = truncate(eft, 2900)
+	link := fmt.Sprintf("%s/fit/%d", siteURL, f.Killmail)
+	return struct {
+		Text   string  `json:"text"`
+		Blocks []block `json:"blocks"`
+	}{
+		Text: fmt.Sprintf("%s: %s", f.Ship.Name, link),
+		Blocks: []block{
+			{
+				Type: "section",
+				Text: text{
+					Type: "mrkdwn",
+					Text: fmt.Sprintf(
+						"*<%s|%s>*\nFitted value: %s\nTotal value: %s\n<https://zkillboard.com/kill/%d/|zKillboard>",
+						link, f.Ship.Name, formatISK(f.Zkb.FittedValue), formatISK(f.Zkb.TotalValue), f.Killmail,
+					),
+				},
+				Accessory: &accessory{
+					Type:     "image",
+					ImageURL: fmt.Sprintf("https://images.evetech.net/types/%d/render?size=128", f.Ship.ID),
+					AltText:  f.Ship.Name,
+				},
+			},
+			{
+				Type: "section",
+				Text: text{Type: "mrkdwn", Text: "```\n" + eft + "```"},
+			},
+		},
+	}
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const (
+	ssoAuthURL  = "https://login.eveonline.com/v2/oauth/authorize"
+	ssoTokenURL = "https://login.eveonline.com/v2/oauth/token"
+	// sessionCookie holds the token of a logged in character's session.
+	sessionCookie = "ef_session"
+	// ssoStateCookie holds the state of a login in progress, checked
+	// against the state returned to the callback.
+	ssoStateCookie = "ef_sso_state"
+	sessionTTL     = time.Hour * 24 * 30
+	ssoStateTTL    = time.Minute * 10
+	// ssoRefreshTimeout bounds a shared refresh of a character's token.
+	ssoRefreshTimeout = time.Second * 30
+	// sealedTokenPrefix marks encrypted stored tokens. Tokens stored before
+	// encryption lack it and are read as-is until refreshed.
+	sealedTokenPrefix = "gcm:"
+)
+
+// Character is an EVE character logged in with EVE SSO.
+type Character struct {
+	ID     int32
+	Name   string
+	Scopes []string
+}
+
+type characterContextKey struct{}
+
+// characterFromContext returns the logged in character of the request, or
+// nil if there is none.
+func characterFromContext(ctx context.Context) *Character {
+	c, _ := ctx.Value(characterContextKey{}).(*Character)
+	return c
+}
+
+// requireCharacter returns the logged in character of ctx, or an
+// unauthorized error if there is none.
+func requireCharacter(ctx context.Context) (*Character, error) {
+	c := characterFromContext(ctx)
+	if c == nil {
+		return nil, &httpError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "login required"}
+	}
+	return c, nil
+}
+
+// newSSOConfig returns the OAuth2 configuration of the EVE SSO application
+// with clientID, or nil if it is not set.
+func newSSOConfig(clientID, secret, callback string, scopes []string) *oauth2.Config {
+	if clientID == "" {
+		return nil
+	}
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: secret,
+		RedirectURL:  callback,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   ssoAuthURL,
+			TokenURL:  ssoTokenURL,
+			AuthStyle: oauth2.AuthStyleInHeader,
+		},
+	}
+}
+
+// ssoContext returns ctx with the client OAuth2 requests are made with.
+func ssoContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+}
+
+// secureCookies reports whether cookies are only sent over HTTPS, which is
+// the case when the SSO callback is an https URL. The request's own TLS
+// state isn't used since a proxy may terminate TLS.
+func (s *EFContext) secureCookies() bool {
+	return strings.HasPrefix(s.sso.RedirectURL, "https://")
+}
+
+// newTokenCipher returns the cipher characters' tokens are encrypted with,
+// keyed by a hash of the SSO secret so a database leak alone doesn't leak
+// them.
+func newTokenCipher(secret string) cipher.AEAD {
+	key := sha256.Sum256([]byte("ef sso tokens\x00" + secret))
+	// AES accepts 32 byte keys and GCM its blocks, so neither errors.
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+// sealToken returns token encrypted for storage.
+func (s *EFContext) sealToken(token string) (string, error) {
+	nonce := make([]byte, s.ssoTokens.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return sealedTokenPrefix + base64.RawStdEncoding.EncodeToString(s.ssoTokens.Seal(nonce, nonce, []byte(token), nil)), nil
+}
+
+// openToken returns the token stored as sealed.
+func (s *EFContext) openToken(sealed string) (string, error) {
+	enc, ok := strings.CutPrefix(sealed, sealedTokenPrefix)
+	if !ok {
+		return sealed, nil
+	}
+	b, err := base64.RawStdEncoding.DecodeString(enc)
+	if err != nil || len(b) < s.ssoTokens.NonceSize() {
+		return "", errors.New("invalid sealed token")
+	}
+	n := s.ssoTokens.NonceSize()
+	token, err := s.ssoTokens.Open(nil, b[:n], b[n:], nil)
+	if err != nil {
+		return "", errors.Wrap(err, "open token")
+	}
+	return string(token), nil
+}
+
+// sealTokens returns the access and refresh tokens of tok encrypted for
+// storage.
+func (s *EFContext) sealTokens(tok *oauth2.Token) (access, refresh string, err error) {
+	if access, err = s.sealToken(tok.AccessToken); err != nil {
+		return "", "", err
+	}
+	if refresh, err = s.sealToken(tok.RefreshToken); err != nil {
+		return "", "", err
+	}
+	return access, refresh, nil
+}
+
+// randomToken returns a random hex token.
+func randomToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SSOLogin redirects to EVE SSO to log in. After logging in the user is
+// sent back to the path in the redirect parameter, or /.
+func (s *EFContext) SSOLogin(w http.ResponseWriter, r *http.Request) {
+	if s.sso == nil {
+		writeError(w, r, apiVersion{}, notFound("login is not enabled"))
+		return
+	}
+	state, err := randomToken()
+	if err != nil {
+		writeError(w, r, apiVersion{}, err)
+		return
+	}
+	redirect := localRedirect(r.FormValue("redirect"))
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state + " " + redirect,
+		Path:     "/sso/",
+		MaxAge:   int(ssoStateTTL.Seconds()),
+		Secure:   s.secureCookies(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, s.sso.AuthCodeURL(state), http.StatusFound)
+}
+
+// SSOCallback completes a login started by SSOLogin: it exchanges the
+// authorization code for tokens, stores the character and its tokens, and
+// starts a session.
+func (s *EFContext) SSOCallback(w http.ResponseWriter, r *http.Request) {
+	if s.sso == nil {
+		writeError(w, r, apiVersion{}, notFound("login is not enabled"))
+		return
+	}
+	ctx := r.Context()
+	cookie, err := r.Cookie(ssoStateCookie)
+	if err != nil {
+		writeError(w, r, apiVersion{}, badRequest("login expired, try again"))
+		return
+	}
+	state, redirect, _ := strings.Cut(cookie.Value, " ")
+	if state == "" || r.FormValue("state") != state {
+		writeError(w, r, apiVersion{}, badRequest("login state mismatch, try again"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: ssoStateCookie, Path: "/sso/", MaxAge: -1})
+	if e := r.FormValue("error"); e != "" {
+		writeError(w, r, apiVersion{}, badRequest("login failed: %s", e))
+		return
+	}
+	tok, err := s.sso.Exchange(ssoContext(ctx), r.FormValue("code"))
+	if err != nil {
+		logger(ctx).Warn("sso exchange", "err", err)
+		writeError(w, r, apiVersion{}, &httpError{Status: http.StatusBadGateway, Code: "sso", Message: "EVE SSO login failed"})
+		return
+	}
+	claims, err := parseSSOToken(tok.AccessToken, s.sso.ClientID)
+	if err != nil {
+		writeError(w, r, apiVersion{}, err)
+		return
+	}
+	session, err := s.login(ctx, claims, tok)
+	if err != nil {
+		logger(ctx).Error("sso login", "err", err)
+		writeError(w, r, apiVersion{}, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		Secure:   s.secureCookies(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	logger(ctx).Info("sso login", "character", claims.characterID, "name", claims.Name)
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, localRedirect(redirect), http.StatusFound)
+}
+
+// localRedirect returns v if it is a path on this site, or / if not, so
+// logins can't redirect elsewhere. Browsers treat backslashes as slashes, so
+// /\evil.com would leave the site, and skip control characters.
+func localRedirect(v string) string {
+	if !strings.HasPrefix(v, "/") || strings.ContainsFunc(v, func(r rune) bool {
+		return r == '\\' || unicode.IsControl(r)
+	}) {
+		return "/"
+	}
+	u, err := url.Parse(v)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "/"
+	}
+	return v
+}
+
+// ssoClaims are the claims of an EVE SSO access token.
+type ssoClaims struct {
+	Subject string          `json:"sub"`
+	Issuer  string          `json:"iss"`
+	Name    string          `json:"name"`
+	Owner   string          `json:"owner"`
+	Scopes  json.RawMessage `json:"scp"`
+	// Audience is the client ID and "EVE Online".
+	Audience []string `json:"aud"`
+
+	characterID int32
+	scopes      []string
+}
+
+// parseSSOToken returns the claims of token, an access token issued to
+// clientID. Its signature isn't checked since it was received directly from
+// the SSO token endpoint over TLS.
+func parseSSOToken(token, clientID string) (*ssoClaims, error) {
+	invalid := &httpError{Status: http.StatusBadGateway, Code: "sso", Message: "invalid EVE SSO token"}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, invalid
+	}
+	var c ssoClaims
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, invalid
+	}
+	if c.Issuer != "login.eveonline.com" && c.Issuer != "https://login.eveonline.com" {
+		return nil, invalid
+	}
+	audience := false
+	for _, a := range c.Audience {
+		audience = audience || a == clientID
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(c.Subject, "CHARACTER:EVE:"), 10, 32)
+	if !audience || err != nil || id <= 0 || c.Owner == "" {
+		return nil, invalid
+	}
+	c.characterID = int32(id)
+	// scp is a string when there is one scope.
+	if err := json.Unmarshal(c.Scopes, &c.scopes); err != nil {
+		var scope string
+		if json.Unmarshal(c.Scopes, &scope) == nil && scope != "" {
+			c.scopes = []string{scope}
+		}
+	}
+	return &c, nil
+}
+
+// login stores the character of claims with tok and returns the token of a
+// new session for it. A character now owned by another account loses its
+// other sessions.
+func (s *EFContext) login(ctx context.Context, claims *ssoClaims, tok *oauth2.Token) (string, error) {
+	session, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	var owner sql.NullString
+	if err := s.DB.QueryRowContext(ctx, `SELECT owner_hash FROM characters WHERE id = $1`, claims.characterID).Scan(&owner); err != nil && err != sql.ErrNoRows {
+		return "", errors.Wrap(err, "lookup character")
+	}
+	if owner.Valid && owner.String != claims.Owner {
+		if _, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE character = $1`, claims.characterID); err != nil {
+			return "", errors.Wrap(err, "end sessions")
+		}
+	}
+	scopes := claims.scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	access, refresh, err := s.sealTokens(tok)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.DB.ExecContext(ctx, `
+		UPSERT
+		INTO
+			characters (id, name, owner_hash, scopes, access_token, refresh_token, token_expiry, updated)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, now())
+	`, claims.characterID, claims.Name, claims.Owner, scopes, access, refresh, tok.Expiry.UTC()); err != nil {
+		return "", errors.Wrap(err, "store character")
+	}
+	if _, err := s.DB.ExecContext(ctx, `INSERT INTO sessions (hash, character, expires) VALUES ($1, $2, $3)`,
+		hashToken(session), claims.characterID, time.Now().Add(sessionTTL).UTC()); err != nil {
+		return "", errors.Wrap(err, "create session")
+	}
+	// Logins are rare enough to clean up expired sessions.
+	if _, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE expires < now()`); err != nil {
+		logger(ctx).Error("expire sessions", "err", err)
+	}
+	return session, nil
+}
+
+// lookupSession returns the character of the session with token, or nil if
+// there is no such unexpired session.
+func (s *EFContext) lookupSession(ctx context.Context, token string) (*Character, error) {
+	var c Character
+	var scopes stringArray
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT
+			c.id, c.name, c.scopes
+		FROM
+			sessions AS s JOIN characters AS c ON c.id = s.character
+		WHERE
+			s.hash = $1 AND s.expires > now()
+	`, hashToken(token)).Scan(&c.ID, &c.Name, &scopes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "lookup session")
+	}
+	c.Scopes = scopes
+	return &c, nil
+}
+
+// characterToken returns a current access token of character id,
+// refreshing and storing it if it has expired. Concurrent calls for a
+// character share one refresh, as EVE SSO rotates refresh tokens and a
+// second refresh with the old one would fail.
+func (s *EFContext) characterToken(ctx context.Context, id int32) (*oauth2.Token, error) {
+	if s.sso == nil {
+		return nil, errors.New("sso is not configured")
+	}
+	tok, err, _ := s.ssoRefresh.Do(strconv.Itoa(int(id)), func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), ssoRefreshTimeout)
+		defer cancel()
+		return s.refreshToken(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tok.(*oauth2.Token), nil
+}
+
+// refreshToken implements characterToken.
+func (s *EFContext) refreshToken(ctx context.Context, id int32) (*oauth2.Token, error) {
+	var tok oauth2.Token
+	if err := s.DB.QueryRowContext(ctx, `
+		SELECT
+			access_token, refresh_token, token_expiry
+		FROM
+			characters
+		WHERE
+			id = $1
+	`, id).Scan(&tok.AccessToken, &tok.RefreshToken, &tok.Expiry); err != nil {
+		return nil, errors.Wrap(err, "lookup token")
+	}
+	expired := &httpError{Status: http.StatusUnauthorized, Code: "sso_expired", Message: "EVE SSO authorization expired, log in again"}
+	var err error
+	// Tokens sealed with a previous SSO secret can't be opened, and like a
+	// revoked refresh token need a new login.
+	if tok.AccessToken, err = s.openToken(tok.AccessToken); err != nil {
+		logger(ctx).Warn("open token", "character", id, "err", err)
+		return nil, expired
+	}
+	if tok.RefreshToken, err = s.openToken(tok.RefreshToken); err != nil {
+		logger(ctx).Warn("open token", "character", id, "err", err)
+		return nil, expired
+	}
+	tok.TokenType = "Bearer"
+	fresh, err := s.sso.TokenSource(ssoContext(ctx), &tok).Token()
+	if err != nil {
+		return nil, expired
+	}
+	if fresh.AccessToken != tok.AccessToken {
+		access, refresh, err := s.sealTokens(fresh)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.DB.ExecContext(ctx, `
+			UPDATE
+				characters
+			SET
+				access_token = $2, refresh_token = $3, token_expiry = $4, updated = now()
+			WHERE
+				id = $1
+		`, id, access, refresh, fresh.Expiry.UTC()); err != nil {
+			return nil, errors.Wrap(err, "store token")
+		}
+	}
+	return fresh, nil
+}
+
+// Me returns the logged in character.
+func (s *EFContext) Me(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	return requireCharacter(r.Context())
+}
+
+// SSOLogout ends the request's session.
+func (s *EFContext) SSOLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, r, apiVersion{}, methodNotAllowed(r.Method))
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if _, err := s.DB.ExecContext(r.Context(), `DELETE FROM sessions WHERE hash = $1`, hashToken(cookie.Value)); err != nil {
+			logger(r.Context()).Error("sso logout", "err", err)
+			writeError(w, r, apiVersion{}, err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// affiliationTTL is how long a character's looked up corporation and
+// alliance are trusted before they are checked with ESI again.
+const affiliationTTL = time.Hour
+
+// characterAffiliation returns the corporation and alliance, or 0 if none,
+// of character id as verified with ESI within affiliationTTL.
+func (s *EFContext) characterAffiliation(ctx context.Context, id int32) (corporation, alliance int32, err error) {
+	var corp, ally sql.NullInt32
+	var checked sql.NullTime
+	if err := s.DB.QueryRowContext(ctx, `SELECT corporation, alliance, affiliated FROM characters WHERE id = $1`, id).Scan(&corp, &ally, &checked); err != nil {
+		return 0, 0, errors.Wrap(err, "lookup affiliation")
+	}
+	if corp.Valid && checked.Valid && time.Since(checked.Time) < affiliationTTL {
+		return corp.Int32, ally.Int32, nil
+	}
+	affs, err := fetchAffiliations(ctx, []int32{id})
+	if err != nil || len(affs) != 1 {
+		logger(ctx).Error("character affiliation", "character", id, "err", err)
+		return 0, 0, &httpError{Status: http.StatusBadGateway, Code: "esi", Message: "could not verify corporation with ESI"}
+	}
+	a := affs[0]
+	if _, err := s.DB.ExecContext(ctx, `
+		UPDATE
+			characters
+		SET
+			corporation = $2, alliance = $3, affiliated = now()
+		WHERE
+			id = $1
+	`, id, a.CorporationID, nullID(a.AllianceID)); err != nil {
+		return 0, 0, errors.Wrap(err, "store affiliation")
+	}
+	return a.CorporationID, a.AllianceID, nil
+}
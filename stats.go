@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+)
+
+const (
+	// viewsInterval is how often the stats views are refreshed.
+	viewsInterval   = time.Hour
+	checkpointViews = "views"
+	// statsLimit is the most rows stats endpoints return.
+	statsLimit = 50
+)
+
+// statsViews are the materialized views of fit aggregates served by the stats
+// endpoints, refreshed by RefreshViews. Only the last 30 days of fits are
+// counted, or 12 weeks for ship_usage. A doctrine is a fit lost at least 5
+// times.
+var statsViews = []string{"hull_modules", "ship_usage", "doctrines"}
+
+// RefreshViews refreshes statsViews if they are older than viewsInterval.
+func (s *EFContext) RefreshViews(ctx context.Context) {
+	_, last, ok, err := s.getCheckpoint(ctx, checkpointViews)
+	if err != nil {
+		slog.Error("refresh views", "err", err)
+		return
+	}
+	if ok && time.Since(last) < viewsInterval {
+		return
+	}
+	s.refreshViews(ctx)
+}
+
+// refreshViews refreshes statsViews.
+func (s *EFContext) refreshViews(ctx context.Context) {
+	for _, view := range statsViews {
+		start := time.Now()
+		if _, err := s.DB.ExecContext(ctx, `REFRESH MATERIALIZED VIEW `+view); err != nil {
+			slog.Error("refresh views", "view", view, "err", err)
+			return
+		}
+		slog.Info("refreshed view", "view", view, "duration", time.Since(start))
+	}
+	if err := s.setCheckpoint(ctx, checkpointViews, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		slog.Error("refresh views", "err", err)
+	}
+}
+
+// ItemCount is how many fits an item appears in.
+type ItemCount struct {
+	Item
+	Fits int64
+}
+
+// HullModules returns the modules most often fitted to a ship in the last 30
+// days.
+func (s *EFContext) HullModules(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	ship, err := s.statsShip(r, true)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Item int32
+		Fits int64
+	}
+	if err := s.read(ctx).SelectContext(ctx, &rows, `
+		SELECT
+			item, fits
+		FROM
+			hull_modules
+		WHERE
+			ship = $1
+		ORDER BY
+			fits DESC
+		LIMIT
+			$2
+	`, ship, statsLimit); err != nil {
+		return nil, err
+	}
+	g := s.Global()
+	counts := []ItemCount{}
+	for _, row := range rows {
+		counts = append(counts, ItemCount{Item: g.Items[row.Item], Fits: row.Fits})
+	}
+	return counts, nil
+}
+
+// ShipWeek is how many fits of a ship were lost in a week.
+type ShipWeek struct {
+	Week time.Time
+	Fits int64
+}
+
+// ShipUsage returns the weekly losses of a ship over the last 12 weeks.
+func (s *EFContext) ShipUsage(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	ship, err := s.statsShip(r, true)
+	if err != nil {
+		return nil, err
+	}
+	weeks := []ShipWeek{}
+	if err := s.read(ctx).SelectContext(ctx, &weeks, `
+		SELECT
+			week, fits
+		FROM
+			ship_usage
+		WHERE
+			ship = $1
+		ORDER BY
+			week
+	`, ship); err != nil {
+		return nil, err
+	}
+	return weeks, nil
+}
+
+// Doctrine is a fit lost at least 5 times in the last 30 days.
+type Doctrine struct {
+	Ship                   Item
+	Hi, Med, Low, Rig, Sub []Item
+	Fits                   int64
+	// Killmail is the latest loss of the fit.
+	Killmail int32
+}
+
+// Doctrines returns the most lost doctrines, optionally of one ship.
+func (s *EFContext) Doctrines(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	ship, err := s.statsShip(r, false)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Ship                   int32
+		Hi, Med, Low, Rig, Sub int32Array
+		Fits                   int64
+		Killmail               int32
+	}
+	if err := s.read(ctx).SelectContext(ctx, &rows, `
+		SELECT
+			ship, hi, med, low, rig, sub, fits, killmail
+		FROM
+			doctrines
+		WHERE
+			$1 = 0 OR ship = $1
+		ORDER BY
+			fits DESC
+		LIMIT
+			$2
+	`, ship, statsLimit); err != nil {
+		return nil, err
+	}
+	g := s.Global()
+	items := func(ids []int32) []Item {
+		items := make([]Item, len(ids))
+		for i, id := range ids {
+			items[i] = g.Items[id]
+		}
+		return items
+	}
+	doctrines := []Doctrine{}
+	for _, row := range rows {
+		doctrines = append(doctrines, Doctrine{
+			Ship:     g.Items[row.Ship],
+			Hi:       items(row.Hi),
+			Med:      items(row.Med),
+			Low:      items(row.Low),
+			Rig:      items(row.Rig),
+			Sub:      items(row.Sub),
+			Fits:     row.Fits,
+			Killmail: row.Killmail,
+		})
+	}
+	return doctrines, nil
+}
+
+// statsShip validates the ship parameter of stats endpoints, returning 0 if
+// it is optional and unset.
+func (s *EFContext) statsShip(r *http.Request, required bool) (int32, error) {
+	r.ParseForm()
+	v := &validator{form: r.Form}
+	if required && r.Form.Get("ship") == "" {
+		v.fail("ship", "required")
+	}
+	v.maxCount("ship", 1)
+	ships := v.id("ship", func(id int32) bool { _, ok := s.Global().Items[id]; return ok })
+	if err := v.err(); err != nil {
+		return 0, err
+	}
+	if len(ships) == 0 {
+		return 0, nil
+	}
+	return ships[0], nil
+}
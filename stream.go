@@ -0,0 +1,104 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ndjsonContentType is the content type of newline-delimited JSON results.
+const ndjsonContentType = "application/x-ndjson"
+
+// streamResult is a handler result written incrementally by write rather
+// than encoded in memory, for large results. It is never enveloped or cached
+// and has no ETag.
+type streamResult struct {
+	contentType string
+	write       func(w io.Writer) error
+}
+
+// wantsNDJSON reports whether the client requested newline-delimited JSON by
+// format parameter or Accept header.
+func wantsNDJSON(r *http.Request) bool {
+	return r.FormValue("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+// writeStream writes res, gzipped if accepted by the client. Brotli isn't
+// offered since its streaming compression is slower for little gain.
+func writeStream(w http.ResponseWriter, r *http.Request, res streamResult, cacheControl string) error {
+	w.Header().Add("Content-Type", res.contentType)
+	w.Header().Add("Cache-Control", cacheControl)
+	w.Header().Add("Vary", "Accept, Accept-Encoding")
+	if !acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip") {
+		return res.write(w)
+	}
+	w.Header().Add("Content-Encoding", "gzip")
+	zw := gzip.NewWriter(w)
+	if err := res.write(zw); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// streamFits returns the fits listed by Fits as CSV or ndjson rows, each
+// written as it is read from the database.
+func (s *EFContext) streamFits(ctx context.Context, r *http.Request, limit int) (interface{}, error) {
+	// CSV has fixed columns.
+	var fields []string
+	if !wantsCSV(r) {
+		fields = requestFields(r)
+	}
+	query, args := s.fitsQuery(ctx, r.Form, limit, map[string][]Item{}, fields)
+	rows, err := s.read(ctx).QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	g := s.Global()
+	// each calls f with the summary of each row.
+	each := func(f func(*FitSummary) error) error {
+		defer rows.Close()
+		for rows.Next() {
+			var row fitRow
+			if err := rows.StructScan(&row); err != nil {
+				return err
+			}
+			if err := f(g.fitSummary(row)); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+	if wantsCSV(r) {
+		return streamResult{contentType: csvContentType, write: func(w io.Writer) error {
+			cw := csv.NewWriter(w)
+			cw.Write(fitsCSVHeader)
+			if err := each(func(f *FitSummary) error {
+				return cw.Write(fitCSVRecord(f))
+			}); err != nil {
+				return err
+			}
+			cw.Flush()
+			return errors.Wrap(cw.Error(), "csv")
+		}}, nil
+	}
+	return streamResult{contentType: ndjsonContentType, write: func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		return each(func(f *FitSummary) error {
+			var v interface{} = f
+			if fields != nil {
+				var err error
+				if v, err = selectFields(f, fields); err != nil {
+					return err
+				}
+			}
+			return enc.Encode(v)
+		})
+	}}, nil
+}
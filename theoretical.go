@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxEFTLength bounds the size of submitted EFT fits.
+	maxEFTLength = 10 << 10
+	// theoreticalRateCount is how many fits a character may submit per
+	// theoreticalRateWindow.
+	theoreticalRateCount  = 10
+	theoreticalRateWindow = time.Hour
+	// theoreticalFitsLimit is the most theoretical fits listed.
+	theoreticalFitsLimit = 50
+)
+
+// TheoreticalFit is a fit submitted by a character rather than taken from
+// a killmail. It has no killmail, cost, or zKillboard data.
+type TheoreticalFit struct {
+	ID int64
+	// Theoretical is always true, labeling the fit where it is listed
+	// with killmail fits.
+	Theoretical bool
+	Name        string
+	// Author is the name of the character that submitted the fit.
+	Author  string
+	Created time.Time
+	Ship    Item
+	Racks
+}
+
+// theoreticalRow is a row of the theoretical_fits table.
+type theoreticalRow struct {
+	ID      int64
+	Name    string
+	Author  string
+	EFT     string
+	Created time.Time
+}
+
+// TheoreticalFits lists (GET) theoretical fits matching the ship, item, and
+// group filters, newest first, or the one given by id. Logged in characters
+// may submit (POST) a fit in EFT format, or delete (DELETE) their own.
+func (s *EFContext) TheoreticalFits(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if r.FormValue("id") != "" {
+			id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+			if err != nil || id <= 0 {
+				return nil, badRequest("invalid id")
+			}
+			return s.theoreticalFit(ctx, id)
+		}
+		return s.theoreticalFits(ctx, r)
+	case http.MethodPost:
+		return s.submitTheoreticalFit(ctx, r)
+	case http.MethodDelete:
+		c, err := requireCharacter(ctx)
+		if err != nil {
+			return nil, err
+		}
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, badRequest("id required")
+		}
+		res, err := s.DB.ExecContext(ctx, `DELETE FROM theoretical_fits WHERE id = $1 AND character = $2`, id, c.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "delete theoretical fit")
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, notFound("unknown theoretical fit")
+		}
+		return nil, nil
+	}
+	return nil, methodNotAllowed(r.Method)
+}
+
+func (s *EFContext) submitTheoreticalFit(ctx context.Context, r *http.Request) (interface{}, error) {
+	c, err := requireCharacter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	text := r.FormValue("eft")
+	if len(text) > maxEFTLength {
+		return nil, badRequest("eft is longer than %d bytes", maxEFTLength)
+	}
+	g := s.Global()
+	ship, name, racks, err := g.parseEFT(text)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = ship.Name
+	}
+	var recent int
+	if err := s.DB.QueryRowContext(ctx, `
+		SELECT
+			count(*)
+		FROM
+			theoretical_fits
+		WHERE
+			character = $1 AND created > $2
+	`, c.ID, time.Now().Add(-theoreticalRateWindow).UTC()).Scan(&recent); err != nil {
+		return nil, errors.Wrap(err, "count theoretical fits")
+	}
+	if recent >= theoreticalRateCount {
+		return nil, &httpError{Status: http.StatusTooManyRequests, Code: "rate_limited", Message: "too many fits submitted, try again later"}
+	}
+	items := []int32{ship.ID}
+	for _, rack := range [][8]ItemCharge{racks.Hi, racks.Med, racks.Low, racks.Rig, racks.Sub} {
+		for _, ic := range rack {
+			if ic.ID != 0 {
+				items = append(items, ic.ID)
+			}
+			if ic.Charge != nil {
+				items = append(items, ic.Charge.ID)
+			}
+		}
+	}
+	ret := &TheoreticalFit{Theoretical: true, Name: name, Author: c.Name, Ship: ship, Racks: racks}
+	if err := s.DB.QueryRowContext(ctx, `
+		INSERT
+		INTO
+			theoretical_fits (character, name, ship, items, eft)
+		VALUES
+			($1, $2, $3, $4, $5)
+		RETURNING
+			id, created
+	`, c.ID, name, ship.ID, items, text).Scan(&ret.ID, &ret.Created); err != nil {
+		return nil, errors.Wrap(err, "submit theoretical fit")
+	}
+	logger(ctx).Info("theoretical fit", "id", ret.ID, "character", c.ID, "ship", ship.ID)
+	return ret, nil
+}
+
+const theoreticalSelect = `
+	SELECT
+		tf.id, tf.name, c.name AS author, tf.eft, tf.created
+	FROM
+		theoretical_fits AS tf JOIN characters AS c ON c.id = tf.character
+`
+
+func (s *EFContext) theoreticalFit(ctx context.Context, id int64) (*TheoreticalFit, error) {
+	var row theoreticalRow
+	if err := s.read(ctx).GetContext(ctx, &row, theoreticalSelect+` WHERE tf.id = $1`, id); err != nil {
+		return nil, err
+	}
+	return s.Global().theoreticalFit(row), nil
+}
+
+func (s *EFContext) theoreticalFits(ctx context.Context, r *http.Request) ([]*TheoreticalFit, error) {
+	r.ParseForm()
+	g := s.Global()
+	v := &validator{form: r.Form}
+	isItem := func(id int32) bool { _, ok := g.Items[id]; return ok }
+	v.maxCount("ship", 1)
+	ships := v.id("ship", isItem)
+	v.maxCount("item", maxItemFilters)
+	items := v.id("item", isItem)
+	v.maxCount("group", maxGroupFilters)
+	groups := v.id("group", func(id int32) bool { _, ok := g.Groups[id]; return ok })
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	var sb strings.Builder
+	sb.WriteString(theoreticalSelect + ` WHERE TRUE`)
+	var args []interface{}
+	// The ship and items share one containment filter as in fitsQuery.
+	if items = append(ships, items...); len(items) > 0 {
+		args = append(args, items)
+		fmt.Fprintf(&sb, ` AND tf.items @> $%d::INT4[]`, len(args))
+	}
+	for _, gid := range groups {
+		args = append(args, g.groupItems[gid])
+		fmt.Fprintf(&sb, ` AND tf.items && $%d::INT4[]`, len(args))
+	}
+	args = append(args, theoreticalFitsLimit)
+	fmt.Fprintf(&sb, ` ORDER BY tf.id DESC LIMIT $%d`, len(args))
+
+	var rows []theoreticalRow
+	if err := s.read(ctx).SelectContext(ctx, &rows, sb.String(), args...); err != nil {
+		return nil, errors.Wrap(err, "theoretical fits")
+	}
+	ret := make([]*TheoreticalFit, len(rows))
+	for i, row := range rows {
+		ret[i] = g.theoreticalFit(row)
+	}
+	return ret, nil
+}
+
+// theoreticalFit returns the TheoreticalFit of row, parsing its EFT with
+// the current static data. A fit that no longer parses, such as after an
+// item is removed from the SDE, is returned with empty racks.
+func (g *staticData) theoreticalFit(row theoreticalRow) *TheoreticalFit {
+	ship, _, racks, _ := g.parseEFT(row.EFT)
+	return &TheoreticalFit{
+		ID:          row.ID,
+		Theoretical: true,
+		Name:        row.Name,
+		Author:      row.Author,
+		Created:     row.Created,
+		Ship:        ship,
+		Racks:       racks,
+	}
+}
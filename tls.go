@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// configureTLS sets up srv to serve HTTPS as configured, returning whether
+// TLS is enabled and, if Redirect_Port is set, a server redirecting HTTP
+// requests to HTTPS. With TLS_Domains, certificates are obtained from
+// Let's Encrypt and the redirect server also answers ACME HTTP challenges.
+func configureTLS(cfg *Config, srv *http.Server) (tls bool, redirect *http.Server) {
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if _, port, _ := net.SplitHostPort(cfg.Port); port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	switch {
+	case len(cfg.TLS_Domains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLS_Domains...),
+			Cache:      autocert.DirCache(cfg.TLS_Cache),
+		}
+		srv.TLSConfig = m.TLSConfig()
+		h = m.HTTPHandler(h)
+		slog.Info("TLS via autocert", "domains", cfg.TLS_Domains)
+	case cfg.TLS_Cert != "":
+		slog.Info("TLS via certificate", "cert", cfg.TLS_Cert)
+	default:
+		return false, nil
+	}
+	if cfg.Redirect_Port != "" {
+		redirect = &http.Server{Addr: cfg.Redirect_Port, Handler: h}
+	}
+	return true, redirect
+}
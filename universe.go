@@ -0,0 +1,198 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"math"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// SolarSystem is a solar system of the universe map.
+type SolarSystem struct {
+	ID            int32
+	Name          string
+	Security      float64
+	Constellation int32
+	Region        int32
+}
+
+// Constellation is a constellation of the universe map.
+type Constellation struct {
+	ID     int32
+	Name   string
+	Region int32
+}
+
+// Region is a region of the universe map.
+type Region struct {
+	ID   int32
+	Name string
+}
+
+const (
+	bandHighsec  = "highsec"
+	bandLowsec   = "lowsec"
+	bandNullsec  = "nullsec"
+	bandWormhole = "wormhole"
+	bandAbyssal  = "abyssal"
+)
+
+// IsWormhole reports whether s is in wormhole space, whose region IDs start
+// at 11000000.
+func (s SolarSystem) IsWormhole() bool {
+	return s.Region >= 11000000 && s.Region < 12000000
+}
+
+// SecurityBand returns highsec, lowsec, or nullsec by the security status of
+// s as rounded in game, or wormhole or abyssal for those spaces.
+func (s SolarSystem) SecurityBand() string {
+	switch {
+	case s.IsWormhole():
+		return bandWormhole
+	case s.Region >= 12000000 && s.Region < 13000000:
+		return bandAbyssal
+	}
+	// Any positive security is lowsec; the game shows such systems below
+	// 0.05 as 0.1.
+	switch {
+	case math.Round(s.Security*10)/10 >= 0.5:
+		return bandHighsec
+	case s.Security > 0:
+		return bandLowsec
+	}
+	return bandNullsec
+}
+
+// newUniverse sets empty universe maps on g.
+func (g *staticData) newUniverse() {
+	g.Systems = map[int32]SolarSystem{}
+	g.Constellations = map[int32]Constellation{}
+	g.Regions = map[int32]Region{}
+}
+
+// readUniverse reads the universe map from the fsd/universe directory of the
+// SDE in fsys. Regions, constellations, and systems are nested directories
+// of staticdata files. Their names are looked up in bsd/invNames.yaml, as
+// the directory names lack spaces and punctuation. Like Fuzzwork dumps
+// without the map tables, SDEs without fsd/universe leave the map empty, and
+// without bsd/invNames.yaml leave it unnamed.
+func (g *staticData) readUniverse(fsys fs.FS) error {
+	g.newUniverse()
+	if _, err := fs.Stat(fsys, "fsd/universe"); errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("SDE has no universe map", "path", "fsd/universe")
+		return nil
+	}
+	// ids are the region, constellation, or system IDs by directory. A
+	// directory's staticdata file may be walked after its subdirectories,
+	// so parents are linked once all are read.
+	ids := map[string]int32{}
+	slog.Info("reading SDE", "path", "fsd/universe")
+	err := fs.WalkDir(fsys, "fsd/universe", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		var data struct {
+			RegionID        int32   `yaml:"regionID"`
+			ConstellationID int32   `yaml:"constellationID"`
+			SolarSystemID   int32   `yaml:"solarSystemID"`
+			Security        float64 `yaml:"security"`
+		}
+		switch path.Base(p) {
+		case "region.staticdata", "constellation.staticdata", "solarsystem.staticdata":
+			if err := decodeYAML(fsys, p, &data); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		dir := path.Dir(p)
+		switch {
+		case data.SolarSystemID != 0:
+			ids[dir] = data.SolarSystemID
+			g.Systems[data.SolarSystemID] = SolarSystem{ID: data.SolarSystemID, Security: data.Security}
+		case data.ConstellationID != 0:
+			ids[dir] = data.ConstellationID
+			g.Constellations[data.ConstellationID] = Constellation{ID: data.ConstellationID}
+		case data.RegionID != 0:
+			ids[dir] = data.RegionID
+			g.Regions[data.RegionID] = Region{ID: data.RegionID}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "fsd/universe")
+	}
+	for dir, id := range ids {
+		if s, ok := g.Systems[id]; ok {
+			s.Constellation = ids[path.Dir(dir)]
+			s.Region = ids[path.Dir(path.Dir(dir))]
+			g.Systems[id] = s
+		} else if c, ok := g.Constellations[id]; ok {
+			c.Region = ids[path.Dir(dir)]
+			g.Constellations[id] = c
+		}
+	}
+
+	var names []struct {
+		ItemID   int32  `yaml:"itemID"`
+		ItemName string `yaml:"itemName"`
+	}
+	if err := readYAML(fsys, "bsd/invNames.yaml", &names); errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("SDE has no universe names", "path", "bsd/invNames.yaml")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if r, ok := g.Regions[n.ItemID]; ok {
+			r.Name = n.ItemName
+			g.Regions[n.ItemID] = r
+		} else if c, ok := g.Constellations[n.ItemID]; ok {
+			c.Name = n.ItemName
+			g.Constellations[n.ItemID] = c
+		} else if s, ok := g.Systems[n.ItemID]; ok {
+			s.Name = n.ItemName
+			g.Systems[n.ItemID] = s
+		}
+	}
+	return nil
+}
+
+// readFuzzworkUniverse reads the universe map from the mapRegions,
+// mapConstellations, and mapSolarSystems tables of the Fuzzwork SDE in
+// fsys. Dumps without them leave the map empty.
+func (g *staticData) readFuzzworkUniverse(fsys fs.FS) error {
+	g.newUniverse()
+	err := readFuzzworkCSV(fsys, "mapRegions", func(row fuzzworkRow) {
+		id := row.int32("regionID")
+		g.Regions[id] = Region{ID: id, Name: row.str("regionName")}
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("SDE has no universe map", "table", "mapRegions")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := readFuzzworkCSV(fsys, "mapConstellations", func(row fuzzworkRow) {
+		id := row.int32("constellationID")
+		g.Constellations[id] = Constellation{
+			ID:     id,
+			Name:   row.str("constellationName"),
+			Region: row.int32("regionID"),
+		}
+	}); err != nil {
+		return err
+	}
+	return readFuzzworkCSV(fsys, "mapSolarSystems", func(row fuzzworkRow) {
+		id := row.int32("solarSystemID")
+		g.Systems[id] = SolarSystem{
+			ID:            id,
+			Name:          row.str("solarSystemName"),
+			Security:      row.float64("security"),
+			Constellation: row.int32("constellationID"),
+			Region:        row.int32("regionID"),
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxItemFilters and maxGroupFilters bound the clauses added to fits
+	// queries. Each group filter matches any of the group's items.
+	maxItemFilters  = 10
+	maxGroupFilters = 4
+	// maxTermLength bounds search and autocomplete terms.
+	maxTermLength = 100
+)
+
+// FieldError describes an invalid request parameter.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validator collects FieldErrors for parameters in a form.
+type validator struct {
+	form url.Values
+	errs []FieldError
+}
+
+func (v *validator) fail(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// id checks that each value of field is a positive int32 accepted by known,
+// if non-nil, and returns the valid values.
+func (v *validator) id(field string, known func(int32) bool) []int32 {
+	var ids []int32
+	for _, s := range v.form[field] {
+		n, err := strconv.ParseInt(s, 10, 64)
+		switch {
+		case err != nil:
+			v.fail(field, "%q is not an integer", s)
+		case n <= 0 || n > math.MaxInt32:
+			v.fail(field, "%d is out of range", n)
+		case known != nil && !known(int32(n)):
+			v.fail(field, "unknown ID %d", n)
+		default:
+			ids = append(ids, int32(n))
+		}
+	}
+	return ids
+}
+
+// intRange checks that field is an integer in [min, max] and returns it.
+func (v *validator) intRange(field string, min, max int) int {
+	n, err := strconv.Atoi(v.form.Get(field))
+	if err != nil {
+		v.fail(field, "%q is not an integer", v.form.Get(field))
+	} else if n < min || n > max {
+		v.fail(field, "must be between %d and %d", min, max)
+	}
+	return n
+}
+
+// maxCount checks that field has at most n values.
+func (v *validator) maxCount(field string, n int) {
+	if len(v.form[field]) > n {
+		v.fail(field, "at most %d values allowed", n)
+	}
+}
+
+// maxLen checks that field is at most n bytes.
+func (v *validator) maxLen(field string, n int) {
+	if len(v.form.Get(field)) > n {
+		v.fail(field, "at most %d characters allowed", n)
+	}
+}
+
+// oneOf checks that field, if set, is one of values.
+func (v *validator) oneOf(field string, values ...string) {
+	s := v.form.Get(field)
+	if s == "" {
+		return
+	}
+	for _, val := range values {
+		if s == val {
+			return
+		}
+	}
+	v.fail(field, "must be one of %s", strings.Join(values, ", "))
+}
+
+// err returns a bad request error listing the field errors, or nil.
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &httpError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_parameters",
+		Message: "invalid parameters",
+		Fields:  v.errs,
+	}
+}
+
+// validateFitsForm checks the flag, location, category, ship, item, group,
+// and victim filters accepted by writeFitsFilter.
+func (s *EFContext) validateFitsForm(form url.Values) error {
+	v := &validator{form: form}
+	g := s.Global()
+	isItem := func(id int32) bool { _, ok := g.Items[id]; return ok }
+	isGroup := func(id int32) bool { _, ok := g.Groups[id]; return ok }
+	for _, flag := range fitsFlags {
+		v.oneOf(flag, "true", "false")
+	}
+	v.maxCount("location", 1)
+	v.id("location", nil)
+	v.oneOf("category", "ship", "structure")
+	v.maxCount("ship", 1)
+	v.id("ship", isItem)
+	v.maxCount("item", maxItemFilters)
+	v.id("item", isItem)
+	v.maxCount("group", maxGroupFilters)
+	v.id("group", isGroup)
+	for category := range entityCategories {
+		v.maxCount(category, 1)
+		v.id(category, nil)
+	}
+	return v.err()
+}
+
+// validateTerm checks the term and type parameters of searches.
+func validateTerm(form url.Values) error {
+	v := &validator{form: form}
+	v.maxLen("term", maxTermLength)
+	v.oneOf("type", "ship", "structure", "item", "group", "character", "corporation", "alliance")
+	return v.err()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+)
+
+// VersionInfo describes the loaded static data and the running build.
+type VersionInfo struct {
+	SDE struct {
+		// Checksum is CCP's checksum of the SDE, empty if it was loaded
+		// from a local SDE.
+		Checksum string
+		// Loaded is when the static data was read from the SDE.
+		Loaded time.Time
+		Items  int
+		Groups int
+	}
+	Build struct {
+		GoVersion string
+		Revision  string
+		Time      string
+		Modified  bool
+	}
+}
+
+// Version returns the SDE version and build info, so clients can tell when
+// static data has changed.
+func (s *EFContext) Version(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	var v VersionInfo
+	g := s.Global()
+	v.SDE.Checksum = g.Checksum
+	v.SDE.Loaded = g.Loaded
+	v.SDE.Items = len(g.Items)
+	v.SDE.Groups = len(g.Groups)
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		v.Build.GoVersion = bi.GoVersion
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				v.Build.Revision = setting.Value
+			case "vcs.time":
+				v.Build.Time = setting.Value
+			case "vcs.modified":
+				v.Build.Modified = setting.Value == "true"
+			}
+		}
+	}
+	return v, nil
+}
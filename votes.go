@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/cockroachdb/cockroach-go/crdb"
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+)
+
+// voteValues are the values of Vote's vote parameter.
+var voteValues = map[string]int{
+	"up":   1,
+	"down": -1,
+	"none": 0,
+}
+
+// FitVote is a character's vote on a fit and the fit's resulting score.
+type FitVote struct {
+	Killmail int
+	// Vote is 1 for an up vote, -1 for a down vote, or 0 for none.
+	Vote  int
+	Score int
+}
+
+// Vote records the logged in character's up or down vote on a fit, or
+// removes it with none. A character has at most one vote per fit; voting
+// again replaces it.
+func (s *EFContext) Vote(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	c, err := requireCharacter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v := &validator{form: r.Form}
+	v.maxCount("id", 1)
+	v.id("id", nil)
+	v.oneOf("vote", "up", "down", "none")
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	vote, ok := voteValues[r.FormValue("vote")]
+	if id <= 0 || !ok {
+		return nil, badRequest("id and vote required")
+	}
+	var exists bool
+	if err := s.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM fits WHERE killmail = $1)`, id).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, notFound("unknown fit")
+	}
+	ret := &FitVote{Killmail: id, Vote: vote}
+	if err := crdb.ExecuteTx(ctx, s.DB, nil, func(tx *sql.Tx) error {
+		var err error
+		if vote == 0 {
+			_, err = tx.ExecContext(ctx, `DELETE FROM fit_votes WHERE killmail = $1 AND character = $2`, id, c.ID)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+				UPSERT
+				INTO
+					fit_votes (killmail, character, vote, voted)
+				VALUES
+					($1, $2, $3, now())
+			`, id, c.ID, vote)
+		}
+		if err != nil {
+			return err
+		}
+		// Recounting keeps the score exact under concurrent votes, and
+		// fits get few enough votes for it to be cheap.
+		return tx.QueryRowContext(ctx, `
+			UPSERT
+			INTO
+				fit_scores (killmail, score)
+			SELECT
+				$1, COALESCE(sum(vote), 0)
+			FROM
+				fit_votes
+			WHERE
+				killmail = $1
+			RETURNING
+				score
+		`, id).Scan(&ret.Score)
+	}); err != nil {
+		return nil, errors.Wrap(err, "vote")
+	}
+	return ret, nil
+}
@@ -4,220 +4,806 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/lib/pq"
+	"github.com/andybalholm/brotli"
 	servertiming "github.com/mitchellh/go-server-timing"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func (s *EFContext) Wrap(
-	f func(context.Context, *http.Request, *servertiming.Header) (interface{}, error),
-) http.HandlerFunc {
+// apiVersion is a version of the API served under a path prefix.
+type apiVersion struct {
+	Prefix  string
+	Version string
+	// Successor is the prefix of the routes that replace deprecated ones.
+	Successor string
+	// Envelope wraps JSON responses in an Envelope.
+	Envelope bool
+}
+
+var (
+	apiV1 = apiVersion{Prefix: "/api/v1/", Version: "v1", Envelope: true}
+	// apiLegacy serves v1 at its original unversioned paths, without the
+	// response envelope.
+	apiLegacy = apiVersion{Prefix: "/api/", Version: "v1", Successor: apiV1.Prefix}
+
+	apiVersions = []apiVersion{apiV1, apiLegacy}
+)
+
+const (
+	// maxQueryLength bounds the length of request query strings.
+	maxQueryLength = 4 << 10
+	// maxBodyBytes bounds the size of request bodies.
+	maxBodyBytes = 1 << 20
+)
+
+// Wrap adapts f to an http.HandlerFunc that isn't part of a versioned API.
+func (s *EFContext) Wrap(f apiHandler) http.HandlerFunc {
+	return s.WrapVersion(apiVersion{}, apiRoute{Handler: f})
+}
+
+// timeout returns how long route's handler may run: its configured timeout
+// if any, else its own, else the default.
+func (s *EFContext) timeout(route apiRoute) time.Duration {
+	if d := s.routeTimeouts[route.Name]; d > 0 {
+		return d
+	}
+	if route.Timeout > 0 {
+		return route.Timeout
+	}
+	return s.requestTimeout
+}
+
+// WrapVersion adapts route's handler to an http.HandlerFunc, marking
+// responses with the API version and deprecation status of v.
+func (s *EFContext) WrapVersion(v apiVersion, route apiRoute) http.HandlerFunc {
+	f := route.Handler
+	timeout := s.timeout(route)
 	return func(w http.ResponseWriter, r *http.Request) {
+		if v.Version != "" {
+			w.Header().Set("API-Version", v.Version)
+		}
+		if v.Successor != "" {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, v.Successor, strings.TrimPrefix(r.URL.Path, v.Prefix)))
+		}
+		id := requestID(r)
+		w.Header().Set("X-Request-Id", id)
+		rctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		rctx, span := tracer.Start(rctx, r.URL.Path, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPMethod(r.Method),
+			semconv.HTTPTarget(r.URL.RequestURI()),
+			attribute.String("request_id", id),
+		))
+		defer span.End()
+		reqLog := slog.Default().With("request_id", id, "path", r.URL.Path)
+		r = r.WithContext(withLogger(context.WithValue(rctx, requestIDContextKey{}, id), reqLog))
+		sw := &statusWriter{ResponseWriter: w}
+		w = sw
+		start := time.Now()
+		defer func() {
+			reqLog.Info("request", "method", r.Method, "query", r.URL.RawQuery, "status", sw.status, "duration", time.Since(start))
+		}()
 		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.Header().Set("Access-Control-Max-Age", "3600")
-			w.WriteHeader(http.StatusNoContent)
+			s.cors.preflight(w, r, route.methods())
 			return
 		}
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		s.cors.apply(w, r)
+		if !route.allows(r.Method) {
+			w.Header().Set("Allow", strings.Join(route.methods(), ", "))
+			writeError(w, r, v, methodNotAllowed(r.Method))
+			return
+		}
+		if len(r.URL.RawQuery) > maxQueryLength {
+			writeError(w, r, v, &httpError{Status: http.StatusRequestURITooLong, Code: "too_long", Message: fmt.Sprintf("query string longer than %d bytes", maxQueryLength)})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			// Parse form bodies now so handlers, which ignore ParseForm
+			// errors, don't see a truncated form.
+			if err := r.ParseForm(); err != nil {
+				writeError(w, r, v, bodyError(err))
+				return
+			}
+		}
+		if s.limiter != nil && !s.limiter.allow(w, r, v) {
+			return
+		}
+		ar, err := s.authenticate(r)
+		if err != nil {
+			writeError(w, r, v, err)
+			return
+		}
+		r = ar
 
-		ctx, cancel := context.WithTimeout(r.Context(), time.Second*60)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
 		defer cancel()
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			ctx = withReadOnly(ctx)
+		}
 		var sh servertiming.Header
 		ctx = servertiming.NewContext(ctx, &sh)
 		if v, err := url.ParseQuery(r.URL.RawQuery); err == nil {
 			r.URL.RawQuery = v.Encode()
 		}
-		url := r.URL.String()
-		start := time.Now()
-		defer func() { fmt.Printf("%s: %s\n", url, time.Since(start)) }()
+		// The pool's wait duration grows while any request waits for a
+		// connection, so its growth bounds how long this request waited.
+		pool := s.read(ctx).DB
+		waited := pool.Stats().WaitDuration
 		tm := servertiming.FromContext(ctx).NewMetric("req").Start()
-		res, err := f(ctx, r, &sh)
+		// f runs separately so a handler that ignores ctx can't hold the
+		// response past its deadline. Its late result is dropped, but it
+		// is still waited for since it may use r, which mustn't outlive
+		// this call.
+		type result struct {
+			res interface{}
+			err error
+		}
+		done := make(chan result, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					done <- result{err: errors.Errorf("panic: %v", p)}
+				}
+			}()
+			res, err := f(ctx, r, &sh)
+			done <- result{res, err}
+		}()
+		var res interface{}
+		select {
+		case d := <-done:
+			res, err = d.res, d.err
+		case <-ctx.Done():
+			reqLog.Warn("handler", "err", ctx.Err(), "timeout", timeout)
+			span.SetStatus(codes.Error, "timeout")
+			writeError(w, r, v, timeoutError(ctx))
+			// Send the error now rather than once f, whose ctx is done,
+			// returns.
+			http.NewResponseController(w).Flush()
+			<-done
+			return
+		}
 		tm.Stop()
+		if err != nil && ctx.Err() != nil {
+			// The handler gave up because of ctx, reporting whatever
+			// error that caused.
+			err = timeoutError(ctx)
+		}
+		if waited = pool.Stats().WaitDuration - waited; waited > 0 {
+			m := sh.NewMetric("dbwait")
+			m.Duration = waited
+			m.Desc = "DB pool wait of all requests"
+		}
 		if len(sh.Metrics) > 0 {
 			w.Header().Add(servertiming.HeaderKey, sh.String())
-			if *flagLog {
-				for _, m := range sh.Metrics {
-					fmt.Printf("timing: %s: %s\n", m.Name, m.Duration)
-				}
+			for _, m := range sh.Metrics {
+				reqLog.Debug("timing", "name", m.Name, "duration", m.Duration)
 			}
 		}
 		if err != nil {
-			log.Printf("%s: %+v", url, err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			lvl := slog.LevelError
+			if status, _ := errorResponse(err); status < http.StatusInternalServerError {
+				lvl = slog.LevelInfo
+			}
+			reqLog.Log(ctx, lvl, "handler", "err", fmt.Sprintf("%+v", err))
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			writeError(w, r, v, err)
+			return
+		}
+		cacheControl := route.Cache.header(apiKeyFromContext(r.Context()) != nil || characterFromContext(r.Context()) != nil)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			cacheControl = "no-store"
+		}
+		var modified time.Time
+		if m, ok := res.(modifiedResult); ok {
+			modified, res = m.modified, m.result
+		}
+		if stream, ok := res.(streamResult); ok {
+			// The status is already sent, so errors can only be logged.
+			if err := writeStream(w, r, stream, cacheControl); err != nil {
+				reqLog.Error("stream result", "err", err)
+			}
 			return
 		}
-		data, gzip, err := resultToBytes(res)
+		if _, raw := res.(rawResult); v.Envelope && !raw {
+			// The request ID is left to the X-Request-Id header so identical
+			// results have identical bytes, and so ETags, across requests.
+			res = Envelope{Data: res, Meta: Meta{Version: v.Version}}
+		}
+		contentType, data, err := encodeResult(res)
 		if err != nil {
-			log.Printf("%s: %v", url, err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			reqLog.Error("encode result", "err", err)
+			writeError(w, r, v, err)
 			return
 		}
-		writeDataGzip(w, r, data, gzip)
+		writeDataGzip(w, r, contentType, cacheControl, data, s.encodings.compress, modified)
 	}
 }
 
 func (s *EFContext) Fit(
 	ctx context.Context, r *http.Request, timing *servertiming.Header,
 ) (interface{}, error) {
-	id := r.FormValue("id")
-	if id == "" {
-		return nil, errors.New("missing fit id")
+	if r.FormValue("id") == "" {
+		return nil, badRequest("missing fit id")
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 32)
+	if err != nil || id <= 0 {
+		return nil, badRequest("invalid fit id %q", r.FormValue("id"))
+	}
+	fields := requestFields(r)
+	var rawKM, rawZKB []byte
+	var kmid int32
+	var processed sql.NullTime
+	if err := s.read(ctx).QueryRowContext(ctx, `SELECT id, km, zkb, processed_at from killmails where id = $1`, id).Scan(&kmid, &rawKM, &rawZKB, &processed); err == sql.ErrNoRows {
+		return nil, notFound("unknown fit %d", id)
+	} else if err != nil {
+		return nil, err
+	}
+	detail, err := s.fitDetail(kmid, rawKM, rawZKB)
+	if err != nil {
+		return nil, err
+	}
+	// The victim's names are looked up only if it is selected.
+	if fields == nil || slices.Contains(fields, "Victim") {
+		if err := s.nameVictim(ctx, &detail.Victim); err != nil {
+			return nil, err
+		}
+	}
+	var fit interface{} = detail
+	if fields != nil {
+		if fit, err = selectFields(fit, fields); err != nil {
+			return nil, err
+		}
+	}
+	if !processed.Valid {
+		return fit, nil
 	}
+	return modifiedResult{modified: processed.Time, result: fit}, nil
+}
+
+// FitDetail is the fit of a single killmail.
+type FitDetail struct {
+	Killmail int32
+	Zkb      Zkb
+	Ship     Item
+	// Victim is who lost the fit and where. Names not yet resolved from ESI
+	// are empty.
+	Victim Victim
+	Racks
+	// Mutated describes the fit's abyssal modules. Killmails don't identify
+	// module instances, so their rolled attributes are unknown; the possible
+	// source modules and attribute multiplier ranges are given instead.
+	Mutated []MutatedModule `json:",omitempty"`
+}
+
+// Victim is the pilot, corporation, and alliance that lost a fit, and the
+// solar system it was lost in.
+type Victim struct {
+	Character   Item
+	Corporation Item
+	Alliance    Item
+	SolarSystem Item
+	// Region, Security, the solar system's security status, and
+	// SecurityBand, its highsec, lowsec, nullsec, wormhole, or abyssal
+	// band, are omitted if the solar system isn't in the universe map.
+	Region       *Item    `json:",omitempty"`
+	Security     *float64 `json:",omitempty"`
+	SecurityBand string   `json:",omitempty"`
+}
 
+// MutatedModule is an abyssal module type and how it is created.
+type MutatedModule struct {
+	Item
+	Sources    []Item
+	Attributes []MutatedAttribute
+}
+
+// MutatedAttribute is a dogma attribute changed by mutation and the range of
+// its multiplier.
+type MutatedAttribute struct {
+	DogmaAttribute
+	Min, Max float64
+}
+
+func (s *EFContext) fit(ctx context.Context, id interface{}) (*FitDetail, error) {
 	var rawKM, rawZKB []byte
 	var kmid int32
-	if err := s.DB.QueryRowContext(ctx, `SELECT id, km, zkb from killmails where id = $1`, id).Scan(&kmid, &rawKM, &rawZKB); err != nil {
+	if err := s.read(ctx).QueryRowContext(ctx, `SELECT id, km, zkb from killmails where id = $1`, id).Scan(&kmid, &rawKM, &rawZKB); err != nil {
 		return nil, err
 	}
+	fit, err := s.fitDetail(kmid, rawKM, rawZKB)
+	if err != nil {
+		return nil, err
+	}
+	return fit, s.nameVictim(ctx, &fit.Victim)
+}
+
+// fitDetail decodes a killmail's fit from its stored ESI and zkb JSON.
+func (s *EFContext) fitDetail(kmid int32, rawKM, rawZKB []byte) (*FitDetail, error) {
 	var km KM
 	err := json.Unmarshal(rawKM, &km)
 	var zkb Zkb
 	json.Unmarshal(rawZKB, &zkb)
-	hi, med, low, rig, sub, _ := km.Items(s)
-	return struct {
-		Killmail               int32
-		Zkb                    Zkb
-		Ship                   Item
-		Hi, Med, Low, Rig, Sub [8]ItemCharge
-	}{
+	racks, _ := km.Items(s)
+	g := s.Global()
+	victim := Victim{
+		Character:   Item{ID: km.Victim.CharacterId},
+		Corporation: Item{ID: km.Victim.CorporationId},
+		Alliance:    Item{ID: km.Victim.AllianceId},
+		SolarSystem: Item{ID: km.SolarSystemId},
+	}
+	if system, ok := g.Systems[km.SolarSystemId]; ok {
+		victim.SolarSystem.Name = system.Name
+		victim.Region = &Item{ID: system.Region, Name: g.Regions[system.Region].Name}
+		victim.Security = &system.Security
+		victim.SecurityBand = system.SecurityBand()
+	}
+	return &FitDetail{
 		Killmail: kmid,
 		Zkb:      zkb,
-		Ship:     s.Global.Items[km.Victim.ShipTypeId],
-		Hi:       hi,
-		Med:      med,
-		Low:      low,
-		Rig:      rig,
-		Sub:      sub,
+		Ship:     g.Items[km.Victim.ShipTypeId],
+		Victim:   victim,
+		Racks:    racks,
+		Mutated:  g.mutatedModules(racks.Hi, racks.Med, racks.Low, racks.Rig, racks.Service),
 	}, err
 }
 
+// mutatedModules returns the distinct abyssal modules in racks.
+func (g *staticData) mutatedModules(racks ...[8]ItemCharge) []MutatedModule {
+	var ret []MutatedModule
+	seen := map[int32]bool{}
+	for _, rack := range racks {
+		for _, ic := range rack {
+			mut, ok := g.Mutations[ic.ID]
+			if !ok || seen[ic.ID] {
+				continue
+			}
+			seen[ic.ID] = true
+			m := MutatedModule{Item: ic.Item}
+			for _, id := range mut.Sources {
+				if item, ok := g.Items[id]; ok {
+					m.Sources = append(m.Sources, item)
+				}
+			}
+			for id, r := range mut.Attributes {
+				attr, ok := g.Attributes[id]
+				if !ok {
+					attr = DogmaAttribute{ID: id}
+				}
+				m.Attributes = append(m.Attributes, MutatedAttribute{DogmaAttribute: attr, Min: r.Min, Max: r.Max})
+			}
+			sort.Slice(m.Attributes, func(i, j int) bool { return m.Attributes[i].ID < m.Attributes[j].ID })
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// FitSummary is a fit in a list of fits. Charges are omitted.
+type FitSummary struct {
+	Killmail int
+	// Ship is the ship type ID, named by Name.
+	Ship int32
+	Name string
+	// Cost is the fitted value in ISK.
+	Cost int64
+	// Dropped is the value in ISK of the items that dropped.
+	Dropped int64
+	// Points are the zKillboard points of the kill.
+	Points int
+	// Score is the sum of the fit's up (+1) and down (-1) votes.
+	Score       int
+	Hi, Med, Lo []Item
+}
+
+// fitRow is a row of the fits table as selected for a FitSummary.
+type fitRow struct {
+	Killmail     int
+	Ship         int32
+	Cost         int64
+	Dropped      sql.NullInt64
+	Points       sql.NullInt64
+	Score        int
+	Hi, Med, Low int32Array
+}
+
+// FitsResult is a list of fits and the filters that selected them.
+type FitsResult struct {
+	// Filter is the resolved items of each filter parameter.
+	Filter map[string][]Item
+	Fits   []*FitSummary
+}
+
+// SparseFitsResult is a FitsResult whose fits include only the fields
+// requested by the fields parameter.
+type SparseFitsResult struct {
+	Filter map[string][]Item
+	Fits   []map[string]interface{}
+}
+
 func (s *EFContext) Fits(
 	ctx context.Context, r *http.Request, timing *servertiming.Header,
 ) (interface{}, error) {
-	var ret struct {
-		Filter map[string][]Item
-		Fits   []*struct {
-			Killmail              int
-			Ship                  int32
-			Name                  string
-			Cost                  int64
-			HiRaw, MedRaw, LowRaw []byte `json:"-"`
-			Hi, Med, Lo           []Item
-		}
-	}
-	ret.Filter = map[string][]Item{}
 	r.ParseForm()
+	if err := s.validateFitsForm(r.Form); err != nil {
+		return nil, err
+	}
+	v := &validator{form: r.Form}
+	v.oneOf("sort", "recent", "score")
+	limit := fitsLimit
+	if r.Form.Get("limit") != "" {
+		max := fitsLimit
+		if key := apiKeyFromContext(ctx); key != nil && key.Bulk {
+			max = bulkFitsLimit
+		}
+		limit = v.intRange("limit", 1, max)
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	if wantsCSV(r) || wantsNDJSON(r) {
+		return s.streamFits(ctx, r, limit)
+	}
+	fields := requestFields(r)
+	res, err := s.fits(ctx, r.Form, limit, fields, timing)
+	if err != nil {
+		return res, err
+	}
+	var ret interface{} = res
+	if fields != nil {
+		sparse := SparseFitsResult{
+			Filter: res.Filter,
+			Fits:   make([]map[string]interface{}, len(res.Fits)),
+		}
+		for i, f := range res.Fits {
+			if sparse.Fits[i], err = selectFields(f, fields); err != nil {
+				return nil, err
+			}
+		}
+		ret = sparse
+	}
+	if wantsMsgpack(r) {
+		return msgpackResult(ret)
+	}
+	return ret, nil
+}
+
+const (
+	// fitsLimit is the default and anonymous maximum number of fits listed.
+	fitsLimit = 100
+	// bulkFitsLimit is the maximum number of fits listed for API keys with
+	// bulk access.
+	bulkFitsLimit = 1000
+)
+
+func (s *EFContext) fits(ctx context.Context, form url.Values, limit int, fields []string, timing *servertiming.Header) (*FitsResult, error) {
+	ret := &FitsResult{
+		Filter: map[string][]Item{},
+	}
+	query, args := s.fitsQuery(ctx, form, limit, ret.Filter, fields)
+	selectT := timing.NewMetric("select").Start()
+	var rows []fitRow
+	err := s.read(ctx).SelectContext(ctx, &rows, query, args...)
+	selectT.Stop()
+
+	g := s.Global()
+	ret.Fits = make([]*FitSummary, len(rows))
+	for i, row := range rows {
+		ret.Fits[i] = g.fitSummary(row)
+	}
+	return ret, err
+}
 
+// fitColumns are the columns of fitRow selected for the FitSummary fields
+// that need them.
+var fitColumns = []struct {
+	column string
+	fields []string
+}{
+	{"ship", []string{"Ship", "Name"}},
+	{"cost", []string{"Cost"}},
+	{"dropped", []string{"Dropped"}},
+	{"points", []string{"Points"}},
+	{"COALESCE(score, 0) AS score", []string{"Score"}},
+	{"hi", []string{"Hi"}},
+	{"med", []string{"Med"}},
+	{"low", []string{"Lo"}},
+}
+
+// fitsQuery returns the query and arguments selecting the fitRows of the
+// latest limit fits matching the filters in form, or the highest scored if
+// its sort is score, recording the resolved filter items in filter. If
+// fields is set, only the columns of those FitSummary fields are selected.
+func (s *EFContext) fitsQuery(ctx context.Context, form url.Values, limit int, filter map[string][]Item, fields []string) (string, []interface{}) {
+	columns := []string{"killmail"}
+	for _, c := range fitColumns {
+		for _, f := range c.fields {
+			if fields == nil || slices.Contains(fields, f) {
+				columns = append(columns, c.column)
+				break
+			}
+		}
+	}
+	if form.Get("sort") == "score" {
+		return s.scoredFitsQuery(ctx, form, limit, filter, columns)
+	}
 	var sb strings.Builder
-	sb.WriteString(`
+	fmt.Fprintf(&sb, `
 		SELECT
-			killmail,
-			ship,
-			cost,
-			hi AS hiraw,
-			med AS medraw,
-			low AS lowraw
+			%s
 		FROM
-			fits
+			fits LEFT JOIN fit_scores USING (killmail)
 		WHERE
 			TRUE
+	`, strings.Join(columns, ", "))
+	args := s.writeFitsFilter(ctx, &sb, form, filter)
+	sb.WriteString(`
+		ORDER BY
+			killmail DESC
+	`)
+	args = append(args, limit)
+	fmt.Fprintf(&sb, ` LIMIT $%d`, len(args))
+	return sb.String(), args
+}
+
+// scoredFitsQuery is fitsQuery for fits sorted by score. Only voted fits are
+// in fit_scores, so fits scored above 0 and below 0 are read from its score
+// index and unscored fits, which rank between them, from fits by killmail.
+func (s *EFContext) scoredFitsQuery(ctx context.Context, form url.Values, limit int, filter map[string][]Item, columns []string) (string, []interface{}) {
+	var where strings.Builder
+	args := s.writeFitsFilter(ctx, &where, form, filter)
+	args = append(args, limit)
+	// Only the scored parts have a score column to select.
+	scored := strings.Join(columns, ", ")
+	unscored := strings.ReplaceAll(scored, "COALESCE(score, 0) AS score", "0 AS score")
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		if _, name, ok := strings.Cut(c, " AS "); ok {
+			c = name
+		}
+		names[i] = c
+	}
+	query := fmt.Sprintf(`
+		SELECT
+			%[1]s
+		FROM
+			(
+				(
+					SELECT
+						%[2]s, 0 AS sort_part, score AS sort_score
+					FROM
+						fit_scores JOIN fits USING (killmail)
+					WHERE
+						score > 0 %[4]s
+					ORDER BY
+						score DESC, killmail DESC
+					LIMIT
+						$%[5]d
+				)
+				UNION ALL
+				(
+					SELECT
+						%[3]s, 1 AS sort_part, 0 AS sort_score
+					FROM
+						fits
+					WHERE
+						NOT EXISTS (SELECT 1 FROM fit_scores WHERE fit_scores.killmail = fits.killmail AND score != 0) %[4]s
+					ORDER BY
+						killmail DESC
+					LIMIT
+						$%[5]d
+				)
+				UNION ALL
+				(
+					SELECT
+						%[2]s, 2 AS sort_part, score AS sort_score
+					FROM
+						fit_scores JOIN fits USING (killmail)
+					WHERE
+						score < 0 %[4]s
+					ORDER BY
+						score DESC, killmail DESC
+					LIMIT
+						$%[5]d
+				)
+			) AS scored
+		ORDER BY
+			sort_part, sort_score DESC, killmail DESC
+		LIMIT
+			$%[5]d
+	`, strings.Join(names, ", "), scored, unscored, where.String(), len(args))
+	return query, args
+}
+
+// fitSummary returns the FitSummary of row, leaving charges out of its
+// racks.
+func (g *staticData) fitSummary(row fitRow) *FitSummary {
+	modules := func(ids []int32) []Item {
+		var items []Item
+		for _, id := range ids {
+			item := g.Items[id]
+			if g.Groups[item.Group].IsCharge() {
+				continue
+			}
+			items = append(items, item)
+		}
+		return items
+	}
+	return &FitSummary{
+		Killmail: row.Killmail,
+		Ship:     row.Ship,
+		Name:     g.Items[row.Ship].Name,
+		Cost:     row.Cost,
+		Dropped:  row.Dropped.Int64,
+		Points:   int(row.Points.Int64),
+		Score:    row.Score,
+		Hi:       modules(row.Hi),
+		Med:      modules(row.Med),
+		Lo:       modules(row.Low),
+	}
+}
+
+// Random returns a random fit from the most recent randomFits fits matching
+// the ship, item, and group filters.
+func (s *EFContext) Random(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	const randomFits = 1000
+	r.ParseForm()
+	if err := s.validateFitsForm(r.Form); err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`
+		SELECT
+			killmail
+		FROM
+			(
+				SELECT
+					killmail
+				FROM
+					fits
+				WHERE
+					TRUE
 	`)
+	args := s.writeFitsFilter(ctx, &sb, r.Form, map[string][]Item{})
+	args = append(args, randomFits)
+	fmt.Fprintf(&sb, `
+				ORDER BY
+					killmail DESC
+				LIMIT
+					$%d
+			)
+		ORDER BY
+			random()
+		LIMIT
+			1
+	`, len(args))
+	var id int32
+	if err := s.read(ctx).QueryRowContext(ctx, sb.String(), args...).Scan(&id); err != nil {
+		return nil, err
+	}
+	return s.fit(ctx, id)
+}
+
+// fitsForm returns the form values for the given fits filters, as read by
+// writeFitsFilter.
+func fitsForm(ship int32, items, groups []int32) url.Values {
+	form := url.Values{}
+	if ship > 0 {
+		form.Set("ship", strconv.Itoa(int(ship)))
+	}
+	for _, id := range items {
+		form.Add("item", strconv.Itoa(int(id)))
+	}
+	for _, id := range groups {
+		form.Add("group", strconv.Itoa(int(id)))
+	}
+	return form
+}
+
+// writeFitsFilter appends AND clauses to sb for the flag, location,
+// category, ship, item, group, and victim filters in form, recording the resolved filter items in filter. It
+// returns the query arguments for the clauses.
+//
+// Clauses are written in a fixed order, and the ship and items share one
+// array argument, so that the same filters always produce the same query.
+// pgx prepares each distinct query once per connection and reuses it.
+func (s *EFContext) writeFitsFilter(ctx context.Context, sb *strings.Builder, form url.Values, filter map[string][]Item) []interface{} {
 	var args []interface{}
-	if ship, _ := strconv.Atoi(r.Form.Get("ship")); ship > 0 {
-		args = append(args, ship)
-		fmt.Fprintf(&sb, ` AND items @> $%d`, len(args))
-		ret.Filter["ship"] = append(ret.Filter["ship"], s.Global.Items[int32(ship)])
+	var entities []int32
+	for _, category := range entityCategoryNames {
+		id, _ := strconv.Atoi(form.Get(category))
+		if id <= 0 {
+			continue
+		}
+		args = append(args, id)
+		fmt.Fprintf(sb, ` AND %s = $%d`, category, len(args))
+		entities = append(entities, int32(id))
+	}
+	if len(entities) > 0 {
+		// Unknown names are left empty rather than failing the query.
+		names, err := s.entityNames(ctx, entities)
+		if err != nil {
+			logger(ctx).Error("filter names", "err", err)
+		}
+		for _, category := range entityCategoryNames {
+			if id, _ := strconv.Atoi(form.Get(category)); id > 0 {
+				filter[category] = append(filter[category], Item{ID: int32(id), Name: names[int32(id)]})
+			}
+		}
 	}
-	var items []int
-	for _, item := range r.Form["item"] {
+	for _, flag := range fitsFlags {
+		if v, err := strconv.ParseBool(form.Get(flag)); err == nil {
+			args = append(args, v)
+			fmt.Fprintf(sb, ` AND %s = $%d`, flag, len(args))
+		}
+	}
+	if loc, _ := strconv.Atoi(form.Get("location")); loc > 0 {
+		args = append(args, loc)
+		fmt.Fprintf(sb, ` AND location = $%d`, len(args))
+	}
+	if c, ok := fitsCategories[form.Get("category")]; ok {
+		args = append(args, c)
+		fmt.Fprintf(sb, ` AND category = $%d`, len(args))
+	}
+	g := s.Global()
+	var items []int32
+	if ship, _ := strconv.Atoi(form.Get("ship")); ship > 0 {
+		items = append(items, int32(ship))
+		filter["ship"] = append(filter["ship"], g.Items[int32(ship)])
+	}
+	for _, item := range form["item"] {
 		itemid, _ := strconv.Atoi(item)
 		if itemid <= 0 {
 			continue
 		}
-		items = append(items, itemid)
-		ret.Filter["item"] = append(ret.Filter["item"], s.Global.Items[int32(itemid)])
+		items = append(items, int32(itemid))
+		filter["item"] = append(filter["item"], g.Items[int32(itemid)])
 	}
 	if len(items) > 0 {
-		args = append(args, pq.Array(items))
-		fmt.Fprintf(&sb, ` AND items @> array_to_json($%d::int[])`, len(args))
+		args = append(args, items)
+		fmt.Fprintf(sb, ` AND items @> $%d::INT4[]`, len(args))
 	}
-	for _, group := range r.Form["group"] {
+	for _, group := range form["group"] {
 		groupid, _ := strconv.Atoi(group)
 		if groupid <= 0 {
 			continue
 		}
 		gid := int32(groupid)
-		sb.WriteString(` AND (`)
-		or := ""
-		for id, item := range s.Global.Items {
-			if item.Group != gid {
-				continue
-			}
-			args = append(args, id)
-			sb.WriteString(or)
-			or = " OR "
-			fmt.Fprintf(&sb, ` items @> $%d`, len(args))
-		}
-		sb.WriteString(`)`)
-		g := s.Global.Groups[gid]
-		ret.Filter["group"] = append(ret.Filter["group"], Item{
-			Name: g.Name,
-			ID:   g.ID,
+		args = append(args, g.groupItems[gid])
+		fmt.Fprintf(sb, ` AND items && $%d::INT4[]`, len(args))
+		grp := g.Groups[gid]
+		filter["group"] = append(filter["group"], Item{
+			Name: grp.Name,
+			ID:   grp.ID,
 		})
 	}
-
-	sb.WriteString(`
-		ORDER BY
-			killmail DESC
-		LIMIT
-			100
-	`)
-	selectT := timing.NewMetric("select").Start()
-	err := s.X.SelectContext(ctx, &ret.Fits, sb.String(), args...)
-	selectT.Stop()
-
-	var his, meds, los []int32
-	for _, f := range ret.Fits {
-		f.Name = s.Global.Items[f.Ship].Name
-		json.Unmarshal(f.HiRaw, &his)
-		json.Unmarshal(f.MedRaw, &meds)
-		json.Unmarshal(f.LowRaw, &los)
-		for _, v := range his {
-			item := s.Global.Items[v]
-			if s.Global.Groups[item.Group].IsCharge() {
-				continue
-			}
-			f.Hi = append(f.Hi, item)
-		}
-		for _, v := range meds {
-			item := s.Global.Items[v]
-			if s.Global.Groups[item.Group].IsCharge() {
-				continue
-			}
-			f.Med = append(f.Med, item)
-		}
-		for _, v := range los {
-			item := s.Global.Items[v]
-			if s.Global.Groups[item.Group].IsCharge() {
-				continue
-			}
-			f.Lo = append(f.Lo, item)
-		}
-	}
-	return ret, err
+	return args
 }
 
 var searchCategories = map[int32]string{
@@ -225,112 +811,414 @@ var searchCategories = map[int32]string{
 	7:  "item", // module
 	8:  "item", // charge
 	32: "item", // subsystem
+	65: "structure",
+	66: "item", // structure module
+	87: "item", // fighter
+}
+
+// fitsFlags are the boolean columns of fits filtered by the parameter of
+// the same name.
+var fitsFlags = []string{"npc", "solo", "awox"}
+
+// fitsCategories are the hull categories of the category fits filter.
+var fitsCategories = map[string]int32{
+	"ship":      6,
+	"structure": 65,
 }
 
 func (s *EFContext) Search(
 	ctx context.Context, r *http.Request, timing *servertiming.Header,
 ) (interface{}, error) {
-	type Result struct {
-		Type string
-		Name string
-		ID   int32
-	}
-	var ret struct {
-		Search  string
-		Results []Result
+	r.ParseForm()
+	if err := validateTerm(r.Form); err != nil {
+		return nil, err
 	}
+	var ret SearchResults
 	ret.Search = strings.ToLower(strings.TrimSpace(r.FormValue("term")))
-	if len(ret.Search) < 3 {
+	// type restricts results to a single type (ship, item, group, character,
+	// corporation, or alliance) if set.
+	results, err := s.find(ctx, ret.Search, r.FormValue("type"), timing)
+	if results == nil || err != nil {
+		return nil, err
+	}
+	ret.Results = results
+	if wantsMsgpack(r) {
+		return msgpackResult(ret)
+	}
+	return ret, nil
+}
+
+// find returns the items, groups, and names matching the lowercase search
+// term, restricted to type typ if not empty. It returns nil if term is too
+// short.
+func (s *EFContext) find(ctx context.Context, search, typ string, timing *servertiming.Header) ([]SearchResult, error) {
+	const maxResults = 50
+	idx := s.search.Load()
+	term := idx.expandSynonyms(search)
+	if len(term) < 3 {
 		return nil, nil
 	}
-	fields := strings.Fields(ret.Search)
-	match := func(s string) bool {
-		if strings.Contains(s, ret.Search) {
-			return true
-		}
-		containsAll := true
-		for _, term := range fields {
-			if !strings.Contains(s, term) {
-				containsAll = false
-				break
-			}
-		}
-		return containsAll
+	var ret []SearchResult
+	if typ == "" || !entityCategories[typ] {
+		itemPop, groupPop := s.itemPopularity(ctx)
+		ret = idx.match(term, typ, itemPop, groupPop, maxResults)
 	}
-	for id, group := range s.Global.Groups {
-		if !match(strings.ToLower(group.Name)) {
-			continue
+	if typ == "" || entityCategories[typ] {
+		namesT := timing.NewMetric("names").Start()
+		names, err := s.searchNames(ctx, search, typ, maxResults)
+		namesT.Stop()
+		if err != nil {
+			return nil, err
 		}
-		ret.Results = append(ret.Results, Result{
-			Type: "group",
-			Name: group.Name,
-			ID:   id,
-		})
+		ret = append(ret, names...)
 	}
-	for id, item := range s.Global.Items {
-		if !match(item.Lower) {
-			continue
-		}
-		if typ := searchCategories[s.Global.Groups[item.Group].Category]; typ != "" {
-			ret.Results = append(ret.Results, Result{
-				Type: typ,
-				Name: item.Name,
-				ID:   id,
-			})
-		}
-		if len(ret.Results) > 50 {
-			break
-		}
+	if len(ret) == 0 {
+		// Fall back to approximate matches so typos still find something.
+		ret = idx.fuzzy(term, typ, maxResults)
+	}
+	if ret == nil {
+		ret = []SearchResult{}
 	}
 	return ret, nil
 }
 
+// Autocomplete returns names starting with term for as-you-type lookups.
+func (s *EFContext) Autocomplete(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	const maxResults = 10
+	r.ParseForm()
+	if err := validateTerm(r.Form); err != nil {
+		return nil, err
+	}
+	term := strings.TrimSpace(r.FormValue("term"))
+	if len(term) < 2 {
+		return nil, nil
+	}
+	return s.search.Load().prefix(term, maxResults), nil
+}
+
+// Sync runs the sync jobs. The task parameter, which may be repeated or
+// comma separated, limits it to the named jobs. With dryrun=1, the jobs
+// aren't run; the amount of work pending for each is returned instead, or
+// null if a job's work can't be known in advance.
 func (s *EFContext) Sync(w http.ResponseWriter, r *http.Request) {
+	if err := s.authorizeSync(r); err != nil {
+		logger(r.Context()).Warn("sync rejected", "remote", r.RemoteAddr, "err", err)
+		writeError(w, r, apiVersion{}, err)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, r, apiVersion{}, badRequest("invalid query"))
+		return
+	}
+	jobs, err := s.selectSyncJobs(r.Form["task"])
+	if err != nil {
+		writeError(w, r, apiVersion{}, err)
+		return
+	}
+	if dryrun, _ := strconv.ParseBool(r.FormValue("dryrun")); dryrun {
+		pending, err := s.syncPending(r.Context(), jobs)
+		if err != nil {
+			writeError(w, r, apiVersion{}, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pending)
+		return
+	}
 	// Use a time just less than 5 minutes because the cloud scheduler runs every 5 minutes.
 	const almost5Min = time.Second * 295
 	ctx, cancel := context.WithTimeout(r.Context(), almost5Min)
 	defer cancel()
+	// Jobs stop after their current unit of work when the server shuts down.
+	defer context.AfterFunc(s.stopping, cancel)()
+	s.runSync(ctx, jobs).Wait()
+	slog.Info("sync ingestion", "stats", s.ingest.vars())
+}
+
+// selectSyncJobs returns the sync jobs named in tasks, each of which may be a
+// comma-separated list, or all of them if there are none.
+func (s *EFContext) selectSyncJobs(tasks []string) (map[string]func(context.Context), error) {
+	all := s.syncJobs()
+	if len(tasks) == 0 {
+		return all, nil
+	}
+	jobs := map[string]func(context.Context){}
+	for _, task := range tasks {
+		for _, name := range strings.Split(task, ",") {
+			f := all[name]
+			if f == nil {
+				var names []string
+				for n := range all {
+					names = append(names, n)
+				}
+				sort.Strings(names)
+				return nil, badRequest("task must be one of %s", strings.Join(names, ", "))
+			}
+			jobs[name] = f
+		}
+	}
+	return jobs, nil
+}
+
+// syncPendingQueries count the work waiting for the sync jobs whose work is
+// queued in the database.
+var syncPendingQueries = map[string]struct {
+	query string
+	args  []interface{}
+}{
+	"FetchKillmails":  {query: `SELECT count(*) FROM hashes WHERE processed = 0`},
+	"ProcessFits":     {query: `SELECT count(*) FROM killmails WHERE processed IN (0, $1)`, args: []interface{}{ProcKMReprocess}},
+	"ResolveNames":    {query: `SELECT count(*) FROM names WHERE name IS NULL`},
+	"DeliverWebhooks": {query: `SELECT count(*) FROM webhook_deliveries WHERE delivered IS NULL AND next_attempt <= now()`},
+}
+
+// syncPending returns the pending work of jobs by name, nil for jobs without
+// a syncPendingQueries entry.
+func (s *EFContext) syncPending(ctx context.Context, jobs map[string]func(context.Context)) (map[string]*int64, error) {
+	pending := map[string]*int64{}
+	for name := range jobs {
+		pending[name] = nil
+		q, ok := syncPendingQueries[name]
+		if !ok {
+			continue
+		}
+		var n int64
+		if err := s.DB.QueryRowContext(ctx, q.query, q.args...).Scan(&n); err != nil {
+			return nil, errors.Wrap(err, name)
+		}
+		pending[name] = &n
+	}
+	return pending, nil
+}
+
+// authorizeSync returns an error unless r has the sync secret in its
+// X-Sync-Secret header or is authenticated with an admin API key.
+func (s *EFContext) authorizeSync(r *http.Request) error {
+	if secret := r.Header.Get("X-Sync-Secret"); secret != "" {
+		if s.syncSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(s.syncSecret)) == 1 {
+			return nil
+		}
+		return &httpError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "invalid sync secret"}
+	}
+	ar, err := s.authenticate(r)
+	if err != nil {
+		return err
+	}
+	if key := apiKeyFromContext(ar.Context()); key == nil || !key.Admin {
+		return &httpError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "sync secret or admin API key required"}
+	}
+	return nil
+}
+
+// syncJobs returns the background jobs run by Sync and -sync mode by name.
+func (s *EFContext) syncJobs() map[string]func(context.Context) {
+	return map[string]func(context.Context){
+		"FetchHashes":      s.FetchHashes,
+		"FetchKillmails":   s.FetchKillmails,
+		"ProcessFits":      s.ProcessFits,
+		"GenerateReport":   s.GenerateReport,
+		"UpdatePopularity": s.UpdatePopularity,
+		"ResolveNames":     s.ResolveNames,
+		"DeliverWebhooks":  s.DeliverWebhooks,
+		"Notify":           s.Notify,
+		"UpdateSDE":        s.UpdateSDE,
+		"Archive":          s.Archive,
+		"UpdatePrices":     s.UpdatePrices,
+		"RefreshViews":     s.RefreshViews,
+	}
+}
+
+// runSync starts jobs, returning a WaitGroup done when they are.
+func (s *EFContext) runSync(ctx context.Context, jobs map[string]func(context.Context)) *sync.WaitGroup {
 	var wg sync.WaitGroup
-	for name, f := range map[string]func(context.Context){
-		"FetchHashes": s.FetchHashes,
-		"ProcessFits": s.ProcessFits,
-	} {
+	for name, f := range jobs {
 		f := f
 		name := name
 		wg.Add(1)
 		go func() {
 			start := time.Now()
 			f(ctx)
-			fmt.Println(name, "done in", time.Since(start))
+			slog.Info("sync done", "job", name, "duration", time.Since(start))
 			wg.Done()
 		}()
 	}
-	wg.Wait()
+	return &wg
+}
+
+// Envelope is the body of JSON responses of API versions with Envelope set.
+// Exactly one of Data and Error is set.
+type Envelope struct {
+	Data  interface{} `json:"data"`
+	Error *APIError   `json:"error,omitempty"`
+	Meta  Meta        `json:"meta"`
+}
+
+// APIError describes a failed request.
+type APIError struct {
+	// Code is a machine-readable error code such as not_found.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields lists the invalid parameters of a bad request.
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// Meta describes the API and request that produced a response.
+type Meta struct {
+	Version string `json:"version"`
+	// RequestID is set on errors. It is sent as X-Request-Id with every
+	// response and included in server logs.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Subscription identifies a registered webhook or notifier. Secret is
+// required to remove it.
+type Subscription struct {
+	ID     int64
+	Secret string
+}
+
+// writeError writes err as a JSON Envelope with the status from
+// errorResponse.
+func writeError(w http.ResponseWriter, r *http.Request, v apiVersion, err error) {
+	status, apiErr := errorResponse(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Envelope{
+		Error: apiErr,
+		Meta:  Meta{Version: v.Version, RequestID: requestIDFromContext(r.Context())},
+	})
+}
+
+// rawResult is a handler result that is written as-is instead of being
+// encoded as JSON.
+type rawResult struct {
+	contentType string
+	data        []byte
 }
 
-func resultToBytes(res interface{}) (data, gzipped []byte, err error) {
+// encodeResult returns the content type and bytes of res.
+func encodeResult(res interface{}) (contentType string, data []byte, err error) {
+	if raw, ok := res.(rawResult); ok {
+		return raw.contentType, raw.data, nil
+	}
 	data, err = json.Marshal(res)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "json marshal")
+		return "", nil, errors.Wrap(err, "json marshal")
+	}
+	return "application/json", data, nil
+}
+
+// compress returns the encoding of data named encoding, br or gzip.
+func compress(data []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	case "br":
+		// Brotli's best quality is too slow for per-request compression
+		// of large results; the default still beats gzip's best.
+		w = brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	default:
+		return nil, errors.Errorf("unknown encoding %q", encoding)
 	}
-	var gz bytes.Buffer
-	gzw, _ := gzip.NewWriterLevel(&gz, gzip.BestCompression)
-	if _, err := gzw.Write(data); err != nil {
-		return nil, nil, errors.Wrap(err, "gzip")
+	if _, err := w.Write(data); err != nil {
+		return nil, errors.Wrap(err, encoding)
 	}
-	if err := gzw.Close(); err != nil {
-		return nil, nil, errors.Wrap(err, "gzip close")
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, encoding+" close")
 	}
-	return data, gz.Bytes(), nil
+	return buf.Bytes(), nil
 }
 
-func writeDataGzip(w http.ResponseWriter, r *http.Request, data, gzip []byte) {
-	w.Header().Add("Content-Type", "application/json")
-	w.Header().Add("Cache-Control", "max-age=3600")
-	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-		w.Header().Add("Content-Encoding", "gzip")
-		w.Write(gzip)
-	} else {
-		w.Write(data)
+// modifiedResult is returned by handlers whose result has a known last
+// modification time, enabling Last-Modified and If-Modified-Since.
+type modifiedResult struct {
+	modified time.Time
+	result   interface{}
+}
+
+// writeDataGzip writes data, or its brotli or gzip encoding if accepted by
+// the client, with caching and validator headers. Only the negotiated
+// encoding is made, by encode, and only if the response has a body. data is
+// sent unencoded if encode fails.
+func writeDataGzip(w http.ResponseWriter, r *http.Request, contentType, cacheControl string, data []byte, encode func(data []byte, encoding string) ([]byte, error), modified time.Time) {
+	w.Header().Add("Content-Type", contentType)
+	w.Header().Add("Cache-Control", cacheControl)
+	w.Header().Add("Vary", "Accept, Accept-Encoding")
+	encoding := ""
+	if ae := r.Header.Get("Accept-Encoding"); acceptsEncoding(ae, "br") {
+		encoding = "br"
+	} else if acceptsEncoding(ae, "gzip") {
+		encoding = "gzip"
+	}
+	// Encoded representations get distinct tags because strong ETags must
+	// identify the exact bytes sent.
+	etag := dataTag(data)
+	if encoding != "" {
+		etag += "-" + encoding
+	}
+	etag = `"` + etag + `"`
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagMatch(inm, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if !modified.IsZero() {
+		// If-Modified-Since is ignored when If-None-Match is present.
+		if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.Truncate(time.Second).After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	body := data
+	if encoding != "" {
+		b, err := encode(data, encoding)
+		if err != nil {
+			logger(r.Context()).Error("compress result", "encoding", encoding, "err", err)
+			w.Header().Set("ETag", `"`+dataTag(data)+`"`)
+		} else {
+			body = b
+			w.Header().Add("Content-Encoding", encoding)
+		}
+	}
+	w.Write(body)
+}
+
+// dataTag returns the ETag of data, without quotes or encoding suffix.
+func dataTag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header value allows enc,
+// that is, lists it without a q value of 0.
+func acceptsEncoding(header, enc string) bool {
+	for _, e := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(e, ";")
+		if strings.TrimSpace(name) != enc {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// etagMatch reports whether an If-None-Match header value matches etag,
+// using the weak comparison If-None-Match requires.
+func etagMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
 	}
+	return false
 }
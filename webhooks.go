@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/cockroachdb/cockroach-go/crdb"
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/pkg/errors"
+)
+
+const (
+	// webhookAttempts is the number of times a delivery is attempted before
+	// giving up.
+	webhookAttempts = 8
+	webhookTimeout  = time.Second * 10
+	// webhooksPerKey is the most webhooks an API key may register.
+	webhooksPerKey = 20
+)
+
+// Webhook registers (POST) or removes (DELETE) a webhook subscription. New
+// subscriptions are given a secret used to sign deliveries: each POST to the
+// webhook URL has an X-Fittings-Timestamp header and an X-Fittings-Signature
+// header of "sha256=" followed by the hex HMAC-SHA256, keyed by the secret,
+// of the timestamp, a period, and the body. Registering requires an API key
+// and an https URL of a public host.
+func (s *EFContext) Webhook(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	switch r.Method {
+	case http.MethodPost:
+		key := apiKeyFromContext(r.Context())
+		if key == nil {
+			return nil, &httpError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "API key required"}
+		}
+		u, err := url.Parse(r.FormValue("url"))
+		if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+			return nil, badRequest("invalid webhook url")
+		}
+		if err := checkWebhookHost(ctx, u.Hostname()); err != nil {
+			return nil, badRequest("invalid webhook url: %v", err)
+		}
+		r.ParseForm()
+		v := &validator{form: r.Form}
+		for _, name := range []string{"ship", "item", "alliance"} {
+			v.maxCount(name, 1)
+			v.id(name, nil)
+		}
+		if err := v.err(); err != nil {
+			return nil, err
+		}
+		filter := func(name string) interface{} {
+			id, _ := strconv.Atoi(r.FormValue(name))
+			return nullID(int32(id))
+		}
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		ret := Subscription{
+			Secret: hex.EncodeToString(secret),
+		}
+		err = crdb.ExecuteTx(ctx, s.DB, nil, func(tx *sql.Tx) error {
+			var n int
+			if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM webhooks WHERE api_key = $1`, key.ID).Scan(&n); err != nil {
+				return err
+			}
+			if n >= webhooksPerKey {
+				return badRequest("at most %d webhooks may be registered per API key", webhooksPerKey)
+			}
+			return tx.QueryRowContext(ctx, `
+				INSERT
+				INTO
+					webhooks (url, secret, ship, item, alliance, api_key)
+				VALUES
+					($1, $2, $3, $4, $5, $6)
+				RETURNING
+					id
+			`, u.String(), ret.Secret, filter("ship"), filter("item"), filter("alliance"), key.ID).Scan(&ret.ID)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ret, nil
+	case http.MethodDelete:
+		res, err := s.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1 AND secret = $2`, r.FormValue("id"), r.FormValue("secret"))
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, notFound("unknown webhook")
+		}
+		return nil, nil
+	}
+	return nil, methodNotAllowed(r.Method)
+}
+
+// checkWebhookHost returns an error if host is or resolves to an address
+// that isn't public. Deliveries check again when dialing, as the host's
+// addresses may change.
+func checkWebhookHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return errors.Errorf("cannot resolve %s", host)
+	}
+	for _, a := range addrs {
+		if !publicIP(a.IP) {
+			return errors.Errorf("%s is not a public address", host)
+		}
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is routable on the internet, so not loopback,
+// private, link-local, multicast, or unspecified.
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
+
+// webhookClient delivers webhooks and notifications. It only connects to
+// public addresses, checked after DNS resolution so a host can't be pointed
+// at internal services after registration, and ignores proxy settings, which
+// would bypass the check.
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: requestIDTransport{external: true, RoundTripper: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: webhookTimeout,
+			Control: func(network, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+					return errors.Errorf("webhook address %s is not public", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     time.Minute,
+	}},
+}
+
+// enqueueWebhooks queues deliveries of a new fit to all matching webhooks.
+func enqueueWebhooks(tx *sql.Tx, km KM, items []int32) error {
+	_, err := tx.Exec(`
+		INSERT
+		INTO
+			webhook_deliveries (webhook, killmail)
+		SELECT
+			id, $1
+		FROM
+			webhooks
+		WHERE
+			(ship IS NULL OR ship = $2)
+			AND (item IS NULL OR item = ANY($3::INT4[]))
+			AND (alliance IS NULL OR alliance = $4)
+		ON CONFLICT
+			DO NOTHING
+	`, km.KillmailId, km.Victim.ShipTypeId, items, km.Victim.AllianceId)
+	return errors.Wrap(err, "enqueue webhooks")
+}
+
+// DeliverWebhooks sends pending webhook deliveries. Failed deliveries are
+// retried with exponential backoff up to webhookAttempts times.
+func (s *EFContext) DeliverWebhooks(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		var pending []struct {
+			Webhook  int64
+			Killmail int32
+			Attempts int
+			URL      string
+			Secret   string
+		}
+		if err := s.X.SelectContext(ctx, &pending, `
+			SELECT
+				d.webhook, d.killmail, d.attempts, w.url, w.secret
+			FROM
+				webhook_deliveries AS d JOIN webhooks AS w ON w.id = d.webhook
+			WHERE
+				d.delivered IS NULL
+				AND d.attempts < $1
+				AND d.next_attempt <= now()
+			ORDER BY
+				d.next_attempt
+			LIMIT
+				100
+		`, webhookAttempts); err != nil {
+			slog.Error("deliver webhooks", "err", err)
+			return
+		}
+		if len(pending) == 0 {
+			return
+		}
+		for _, p := range pending {
+			err := s.deliverWebhook(ctx, p.Killmail, p.URL, p.Secret)
+			if err == nil {
+				_, err = s.DB.ExecContext(ctx, `UPDATE webhook_deliveries SET delivered = now(), attempts = attempts + 1 WHERE webhook = $1 AND killmail = $2`, p.Webhook, p.Killmail)
+				if err != nil {
+					slog.Error("deliver webhooks", "err", err)
+				}
+				continue
+			}
+			slog.Warn("deliver webhook", "webhook", p.Webhook, "killmail", p.Killmail, "err", err)
+			backoff := time.Minute << uint(p.Attempts)
+			if _, err := s.DB.ExecContext(ctx, `
+				UPDATE
+					webhook_deliveries
+				SET
+					attempts = attempts + 1,
+					next_attempt = now() + $3::INTERVAL,
+					last_error = $4
+				WHERE
+					webhook = $1 AND killmail = $2
+			`, p.Webhook, p.Killmail, backoff.String(), err.Error()); err != nil {
+				slog.Error("deliver webhooks", "err", err)
+			}
+		}
+	}
+}
+
+func (s *EFContext) deliverWebhook(ctx context.Context, killmail int32, hookURL, secret string) error {
+	fit, err := s.fit(ctx, killmail)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(fit)
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Fittings-Timestamp", ts)
+	req.Header.Set("X-Fittings-Signature", "sha256="+webhookSignature(secret, ts, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookSignature returns the hex HMAC-SHA256 of ts and body keyed by secret.
+func webhookSignature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
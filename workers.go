@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// runWorkers calls work concurrently on s.workers goroutines, each
+// repeatedly until it returns false or ctx is canceled, and waits for them
+// to finish. Each worker waits at least s.workerPace between calls. work is
+// passed its worker number and the worker count, for partitioning the work.
+func (s *EFContext) runWorkers(ctx context.Context, work func(ctx context.Context, worker, workers int) bool) {
+	workers := max(s.workers, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				start := time.Now()
+				if !work(ctx, worker, workers) {
+					return
+				}
+				if wait := s.workerPace - time.Since(start); wait > 0 {
+					select {
+					case <-ctx.Done():
+					case <-time.After(wait):
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
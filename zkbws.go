@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	zkbWebsocketURL    = "wss://zkillboard.com/websocket/"
+	zkbWebsocketOrigin = "https://fittin.gs"
+	// zkbSeenMax is how many recent killmail IDs are remembered to drop
+	// duplicates sent on several channels or after reconnecting.
+	zkbSeenMax = 10000
+	// zkbWebsocketGap is how long without killmails from the websocket,
+	// which doesn't replay missed messages, is reported as a gap.
+	zkbWebsocketGap = 5 * time.Minute
+)
+
+// seenIDs remembers the most recent IDs added, up to a maximum.
+type seenIDs struct {
+	ids  map[int]bool
+	ring []int
+	next int
+}
+
+func newSeenIDs(max int) *seenIDs {
+	return &seenIDs{ids: map[int]bool{}, ring: make([]int, max)}
+}
+
+// add records id, reporting whether it was not already recorded.
+func (s *seenIDs) add(id int) bool {
+	if s.ids[id] {
+		return false
+	}
+	delete(s.ids, s.ring[s.next])
+	s.ring[s.next] = id
+	s.next = (s.next + 1) % len(s.ring)
+	s.ids[id] = true
+	return true
+}
+
+// ListenZKBWebsocket subscribes to the zKillboard websocket channels of
+// zkbChannels and stores and processes the killmails sent, reconnecting with
+// backoff until ctx is canceled.
+func (s *EFContext) ListenZKBWebsocket(ctx context.Context) {
+	s.warnIngestionGap(ctx, checkpointZKBWebsocket, zkbWebsocketGap)
+	seen := newSeenIDs(zkbSeenMax)
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := s.zkbWebsocket(ctx, seen)
+		if ctx.Err() != nil {
+			return
+		}
+		// Reset the backoff after a connection that stayed up a while.
+		if time.Since(start) > redisqMaxBackoff {
+			backoff = time.Second
+		}
+		slog.Warn("zkb websocket", "err", err, "backoff", backoff)
+		s.ingest.failed(checkpointZKBWebsocket)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, redisqMaxBackoff)
+	}
+}
+
+// zkbWebsocket reads one websocket connection until it fails or ctx is
+// canceled.
+func (s *EFContext) zkbWebsocket(ctx context.Context, seen *seenIDs) error {
+	config, err := websocket.NewConfig(zkbWebsocketURL, zkbWebsocketOrigin)
+	if err != nil {
+		return err
+	}
+	config.Dialer = &net.Dialer{Timeout: time.Second * 30}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return errors.Wrap(err, "dial")
+	}
+	defer ws.Close()
+	// Unblock reads when ctx is canceled.
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	for _, ch := range s.zkbChannels {
+		if err := websocket.JSON.Send(ws, map[string]string{"action": "sub", "channel": ch}); err != nil {
+			return errors.Wrap(err, "subscribe")
+		}
+	}
+	slog.Info("zkb websocket connected", "channels", s.zkbChannels)
+
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return errors.Wrap(err, "receive")
+		}
+		// Messages are ESI killmails with an added zkb object.
+		var km struct {
+			KillmailID int `json:"killmail_id"`
+			Zkb        Zkb `json:"zkb"`
+		}
+		if err := json.Unmarshal(msg, &km); err != nil || km.KillmailID == 0 {
+			slog.Debug("zkb websocket: skipped message", "msg", string(msg))
+			continue
+		}
+		if !seen.add(km.KillmailID) {
+			continue
+		}
+		rawZKB, err := json.Marshal(km.Zkb)
+		if err != nil {
+			return err
+		}
+		if err := s.storeKillmail(context.Background(), km.KillmailID, km.Zkb.Hash, msg, rawZKB); err != nil {
+			slog.Error("zkb websocket", "killmail", km.KillmailID, "err", err)
+			continue
+		}
+		s.checkpointKillmail(context.Background(), checkpointZKBWebsocket, km.KillmailID)
+		s.ProcessFits(ctx)
+	}
+}